@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// expandAlias replaces an alias from the config's aliases with the command
+// it stands for, followed by the arguments given after the alias. Aliases
+// cannot hide built-in commands or refer to other aliases.
+func expandAlias(config *Config, args []string) ([]string, error) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	if _, ok := commandSpecs[args[0]]; ok {
+		return args, nil
+	}
+	expansion, ok := config.Aliases[args[0]]
+	if !ok {
+		return args, nil
+	}
+	words, err := splitArgs(expansion)
+	if err != nil {
+		return nil, fmt.Errorf("alias %s in %s: %v", args[0], configFile, err)
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("alias %s in %s is empty", args[0], configFile)
+	}
+	if _, ok := config.Aliases[words[0]]; ok {
+		return nil, fmt.Errorf("alias %s in %s refers to the alias %s; spell the command out", args[0], configFile, words[0])
+	}
+	return append(words, args[1:]...), nil
+}
+
+// splitArgs splits a command line on spaces, keeping quoted words together
+// the way a shell would.
+func splitArgs(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unclosed %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// managedEnvAnnotation lists the env vars the last deploy set, so the next
+// one unsets those dropped from the config without touching variables set
+// by hand or with env push.
+const managedEnvAnnotation = "treeline-cli/managed-env"
+
+// desiredEnv is every variable a deploy sets: the config's env, for the
+// selected environment, and what the deploy's own steps need.
+func (d *deployment) desiredEnv() map[string]string {
+	env := map[string]string{}
+	if d.config.nodeProject() {
+		env = d.config.nodeEnvVars()
+	}
+	for key, value := range d.config.Env {
+		env[key] = value
+	}
+	if d.assetsURL != "" {
+		env["ASSETS_URL"] = d.assetsURL
+	}
+	if tracing := d.config.Tracing; tracing.enabled() {
+		service := tracing.ServiceName
+		if service == "" {
+			service = d.config.App
+		}
+		env["TRACING_COLLECTOR_URL"] = tracing.CollectorURL
+		env["TRACING_SERVICE_NAME"] = service
+	}
+	return env
+}
+
+// npmToken is the registry token variable staging needs, as the pushed
+// .npmrc refers to the token by name. It is empty without one, or when the
+// config's env sets the variable itself.
+func (d *deployment) npmToken() (key, token string) {
+	npm := d.config.Npm
+	if _, ok := d.config.Env[npm.tokenEnv()]; ok || !npm.enabled() {
+		return "", ""
+	}
+	if token = npm.token(); token == "" {
+		return "", ""
+	}
+	return npm.tokenEnv(), token
+}
+
+// removeNpmToken takes the registry token out of the app's env once staging
+// is over, so the running app and anyone who can read its env don't see it.
+func (d *deployment) removeNpmToken() error {
+	key, _ := d.npmToken()
+	if key == "" {
+		return nil
+	}
+	app, err := findApp(d.cliConnection, d.name)
+	if err != nil || app == nil {
+		return err
+	}
+	return cfCurl(d.cliConnection, "PATCH", "/v3/apps/"+app.GUID+"/environment_variables", map[string]interface{}{"var": map[string]interface{}{key: nil}}, nil)
+}
+
+// applyEnv sets the variables of want that differ on the app and unsets the
+// ones an earlier deploy set that want no longer has, in one request.
+func (d *deployment) applyEnv(name string, want map[string]string) error {
+	app, err := findApp(d.cliConnection, name)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("app %s not found", name)
+	}
+	var current appEnv
+	err = cfCurl(d.cliConnection, "GET", "/v3/apps/"+app.GUID+"/env", nil, &current)
+	if err != nil {
+		return err
+	}
+
+	changes := map[string]interface{}{}
+	var set, unset []string
+	for _, key := range sortedKeys(want) {
+		if have, ok := current.Variables[key]; !ok || fmt.Sprint(have) != want[key] {
+			changes[key] = want[key]
+			set = append(set, key)
+		}
+	}
+	for _, key := range strings.Split(app.Metadata.Annotations[managedEnvAnnotation], ",") {
+		if _, wanted := want[key]; key == "" || wanted {
+			continue
+		}
+		if _, ok := current.Variables[key]; ok {
+			changes[key] = nil
+			unset = append(unset, key)
+		}
+	}
+	sort.Strings(unset)
+
+	if len(changes) == 0 {
+		fmt.Printf("The env of %s is up to date\n", name)
+	} else {
+		if len(set) > 0 {
+			fmt.Printf("Setting %s on %s\n", strings.Join(set, ", "), name)
+		}
+		if len(unset) > 0 {
+			fmt.Printf("Unsetting %s on %s\n", strings.Join(unset, ", "), name)
+		}
+		err = cfCurl(d.cliConnection, "PATCH", "/v3/apps/"+app.GUID+"/environment_variables", map[string]interface{}{"var": changes}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	managed := strings.Join(sortedKeys(want), ",")
+	if managed == app.Metadata.Annotations[managedEnvAnnotation] {
+		return nil
+	}
+	return cfCurl(d.cliConnection, "PATCH", "/v3/apps/"+app.GUID, map[string]v3Metadata{
+		"metadata": {Annotations: map[string]string{managedEnvAnnotation: managed}},
+	}, nil)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// apply converges the live app toward .treeline-cf.yml: env vars, service
+// bindings, scaling and routes. Code changes still need a deploy.
+func apply(cliConnection plugin.CliConnection, config *Config, args []string) {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	env := flags.String("env", "", "apply the environment `NAME` from the config's environments")
+	yes := flags.Bool("yes", false, "apply the plan without asking")
+	planOnly := flags.Bool("plan", false, "only show the plan")
+	asJSON := flags.Bool("json", false, "with --plan, print the plan as JSON")
+	flags.Parse(args)
+
+	err := config.useEnvironment(*env)
+	if err == nil {
+		err = config.targetSpace(cliConnection)
+	}
+	if err != nil {
+		fail(err)
+	}
+	app, err := findApp(cliConnection, config.App)
+	if err != nil {
+		fail(err)
+	}
+	if app == nil {
+		fmt.Printf("%s does not exist yet; create it with 'cf treeline deploy'\n", config.App)
+		exitFailed("not-found")
+	}
+	drifts, err := configDrift(cliConnection, config)
+	if err != nil {
+		fail(err)
+	}
+	if *planOnly && *asJSON {
+		printPlanJSON(newPlan(config, true, drifts))
+		return
+	}
+	if len(drifts) == 0 {
+		fmt.Printf("%s matches %s, nothing to apply\n", config.App, configFile)
+		return
+	}
+
+	printPlan(drifts)
+	if *planOnly {
+		return
+	}
+	if !*yes {
+		fmt.Print("\nApply these changes? Type yes to continue: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != "yes" {
+			fmt.Println("Nothing applied")
+			exitFailed("declined")
+		}
+	}
+
+	err = applyDrifts(cliConnection, config.App, drifts)
+	if err != nil {
+		fail(err)
+	}
+}
+
+// applyDrifts runs each drift's fix, in order, and restarts or restages the
+// app when a change only takes effect then.
+func applyDrifts(cliConnection plugin.CliConnection, name string, drifts []drift) error {
+	pending := &pendingRestart{}
+	for _, d := range drifts {
+		if d.fix == nil {
+			fmt.Printf("Skipping, redeploy to fix: %s\n", d.description())
+			continue
+		}
+		events.record("apply", map[string]interface{}{"change": d.description()})
+		run := cliConnection.CliCommand
+		if d.fix[0] == "set-env" {
+			// cf echoes the value it sets, which may be a secret.
+			run = cliConnection.CliCommandWithoutTerminalOutput
+			fmt.Printf("Setting %s on %s\n", d.fix[2], d.fix[1])
+		}
+		_, err := run(d.fix...)
+		if err != nil {
+			return fmt.Errorf("could not apply %q: %v", d.description(), err)
+		}
+		switch d.fix[0] {
+		case "set-env", "unset-env":
+			pending.envChanged(d.fix[2])
+		case "bs", "unbind-service":
+			pending.bindingChanged(d.fix[2])
+		}
+	}
+	return pending.apply(cliConnection, name)
+}
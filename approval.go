@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const approvalPollInterval = 10 * time.Second
+
+// approveDeploy waits for someone to confirm a deploy to a protected
+// environment, after deploy has shown its plan.
+func approveDeploy(config *Config, ci bool, timeout time.Duration) error {
+	environment := config.Environments[config.Environment]
+	if config.Environment == "" || !environment.protected(config.Environment) {
+		return nil
+	}
+
+	fmt.Printf("Deploying %s to the protected %s environment\n", config.App, config.Environment)
+	events.record("approval-requested", map[string]interface{}{"app": config.App, "env": config.Environment})
+
+	if ci {
+		if environment.ApprovalURL == "" {
+			return fmt.Errorf("--ci needs environments.%s.approval_url in %s to approve the deploy", config.Environment, configFile)
+		}
+		return waitForApproval(environment.ApprovalURL, config, timeout)
+	}
+
+	fmt.Printf("Type the app name (%s) to deploy: ", config.App)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(answer) != config.App {
+		return fmt.Errorf("deploy to %s was not approved", config.Environment)
+	}
+	events.record("approved", map[string]interface{}{"by": "terminal"})
+	return nil
+}
+
+// waitForApproval polls the approval URL, passing the app, environment and
+// commit, until it answers 200 or timeout passes.
+func waitForApproval(approvalURL string, config *Config, timeout time.Duration) error {
+	query := url.Values{"app": {config.App}, "env": {config.Environment}}
+	if sha := resourceLabels(config)["git-sha"]; sha != "" {
+		query.Set("sha", sha)
+	}
+	separator := "?"
+	if strings.Contains(approvalURL, "?") {
+		separator = "&"
+	}
+	target := approvalURL + separator + query.Encode()
+
+	fmt.Println("Waiting for approval from", approvalURL)
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := httpClient.Get(target)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == 200 {
+				events.record("approved", map[string]interface{}{"by": approvalURL})
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("deploy to %s was not approved within %s", config.Environment, timeout)
+		}
+		time.Sleep(approvalPollInterval)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// AssetsConfig moves the app's built static assets to an S3-compatible
+// bucket from the marketplace, so Sails serves them from there.
+type AssetsConfig struct {
+	// Service and Plan are the marketplace offering to provision as Name.
+	Service string `yaml:"service,omitempty"`
+	Plan    string `yaml:"plan,omitempty"`
+	Name    string `yaml:"name,omitempty"`
+	// Dir holds the built assets, .tmp/public by default.
+	Dir string `yaml:"dir,omitempty"`
+	// Build is run first when set, such as "grunt buildProd".
+	Build string `yaml:"build,omitempty"`
+	// CDNURL is the public base URL of the bucket when it sits behind a CDN.
+	CDNURL string `yaml:"cdn_url,omitempty"`
+}
+
+func (c AssetsConfig) enabled() bool {
+	return c.Service != ""
+}
+
+// assetsConfigFile points Sails at the bucket through ASSETS_URL, which
+// deploy sets on the app.
+const assetsConfigFile = "config/assets.js"
+
+var assetsConfig = []byte(`/**
+ * Static assets are served from object storage, see .treeline-cf.yml.
+ * Use sails.config.assets.url as the base URL for asset links.
+ */
+
+module.exports.assets = {
+  url: process.env.ASSETS_URL || ''
+};
+`)
+
+type s3Credentials struct {
+	accessKey string
+	secretKey string
+	bucket    string
+	endpoint  string
+	region    string
+}
+
+// offloadAssets provisions the bucket, uploads the built assets to it and
+// remembers their public URL for setEnv.
+func (d *deployment) offloadAssets() error {
+	config := d.config.Assets
+	if !config.enabled() {
+		return nil
+	}
+	name := config.Name
+	if name == "" {
+		name = d.config.App + "-assets"
+	}
+	dir := config.Dir
+	if dir == "" {
+		dir = filepath.Join(".tmp", "public")
+	}
+	// A prebuilt directory already has its assets built; a zip serves its
+	// own.
+	if info, err := os.Stat(d.pushPath()); d.path != "" && (err != nil || !info.IsDir()) {
+		fmt.Println("Skipping asset offload for the prebuilt", d.path)
+		return nil
+	}
+	dir = filepath.Join(d.pushPath(), dir)
+
+	if config.Build != "" && d.path == "" {
+		fields := strings.Fields(config.Build)
+		build := command(fields[0], fields[1:]...)
+		build.Stdout = os.Stdout
+		build.Stderr = os.Stderr
+		err := build.Run()
+		if err != nil {
+			return fmt.Errorf("building assets: %v", err)
+		}
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("no built assets in %s; build them first or set assets.build: %v", dir, err)
+	}
+
+	instance, err := findServiceInstance(d.cliConnection, name)
+	if err != nil {
+		return err
+	}
+	if instance == nil {
+		_, err = d.cliConnection.CliCommand("cs", config.Service, config.Plan, name)
+		if err != nil {
+			return err
+		}
+		err = labelServiceInstance(d.cliConnection, name, resourceLabels(d.config))
+		if err != nil {
+			return err
+		}
+	}
+	credentials, err := serviceKeyCredentials(d.cliConnection, name, "treeline-cf-assets")
+	if err != nil {
+		return err
+	}
+	s3 := s3CredentialsFrom(credentials)
+	if s3.accessKey == "" || s3.bucket == "" {
+		return fmt.Errorf("the %s service key has no S3 access key or bucket", name)
+	}
+
+	uploaded := 0
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(file))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		uploaded++
+		return s3.put(filepath.ToSlash(rel), data, contentType)
+	})
+	if err != nil {
+		return fmt.Errorf("uploading assets: %v", err)
+	}
+
+	d.assetsURL = config.CDNURL
+	if d.assetsURL == "" {
+		d.assetsURL = s3.endpoint + "/" + s3.bucket
+	}
+	fmt.Printf("Uploaded %d assets to %s\n", uploaded, d.assetsURL)
+	return writeGenerated(filepath.Join(d.pushPath(), assetsConfigFile), assetsConfig)
+}
+
+// serviceKeyCredentials creates the named service key if needed and returns
+// its credentials.
+func serviceKeyCredentials(cliConnection plugin.CliConnection, service, key string) (map[string]interface{}, error) {
+	_, err := cliConnection.CliCommandWithoutTerminalOutput("create-service-key", service, key)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := findServiceInstance(cliConnection, service)
+	if err != nil {
+		return nil, err
+	}
+	if instance == nil {
+		return nil, fmt.Errorf("service %s not found", service)
+	}
+	var keys struct {
+		Resources []struct {
+			GUID string `json:"guid"`
+		} `json:"resources"`
+	}
+	err = cfCurl(cliConnection, "GET", "/v3/service_credential_bindings?type=key&service_instance_guids="+instance.GUID+"&names="+url.QueryEscape(key), nil, &keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys.Resources) == 0 {
+		return nil, fmt.Errorf("service key %s for %s was not created", key, service)
+	}
+	var details struct {
+		Credentials map[string]interface{} `json:"credentials"`
+	}
+	err = cfCurl(cliConnection, "GET", "/v3/service_credential_bindings/"+keys.Resources[0].GUID+"/details", nil, &details)
+	return details.Credentials, err
+}
+
+// s3CredentialsFrom accepts the spellings common S3-compatible brokers use.
+func s3CredentialsFrom(credentials map[string]interface{}) s3Credentials {
+	get := func(keys ...string) string {
+		for _, key := range keys {
+			if value, ok := credentials[key]; ok && fmt.Sprint(value) != "" {
+				return fmt.Sprint(value)
+			}
+		}
+		return ""
+	}
+	s3 := s3Credentials{
+		accessKey: get("access_key_id", "aws_access_key_id", "accessKeyId", "access_key"),
+		secretKey: get("secret_access_key", "aws_secret_access_key", "secretAccessKey", "secret_key"),
+		bucket:    get("bucket", "bucket_name", "bucketName"),
+		endpoint:  get("endpoint", "host", "s3_endpoint"),
+		region:    get("region", "aws_region"),
+	}
+	if s3.endpoint == "" {
+		s3.endpoint = "https://s3.amazonaws.com"
+	}
+	if !strings.Contains(s3.endpoint, "://") {
+		s3.endpoint = "https://" + s3.endpoint
+	}
+	s3.endpoint = strings.TrimRight(s3.endpoint, "/")
+	if s3.region == "" {
+		s3.region = "us-east-1"
+	}
+	return s3
+}
+
+// put uploads one public object, signed with AWS signature version 4.
+func (s3 s3Credentials) put(key string, body []byte, contentType string) error {
+	var segments []string
+	for _, segment := range strings.Split(path.Join(s3.bucket, key), "/") {
+		segments = append(segments, url.PathEscape(segment))
+	}
+	target, err := url.Parse(s3.endpoint + "/" + strings.Join(segments, "/"))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", target.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Acl", "public-read")
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "content-type;host;x-amz-acl;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		"PUT",
+		target.EscapedPath(),
+		"",
+		"content-type:" + contentType,
+		"host:" + target.Host,
+		"x-amz-acl:public-read",
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s3.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	signingKey := hmacSHA256([]byte("AWS4"+s3.secretKey), date)
+	signingKey = hmacSHA256(signingKey, s3.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3.accessKey, scope, signedHeaders, signature))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("PUT %s: %s %s", key, resp.Status, message)
+	}
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type AuditConfig struct {
+	// Threshold is the lowest severity that blocks a deploy: low, moderate,
+	// high, critical (the default) or off.
+	Threshold string `yaml:"threshold,omitempty"`
+	// Tool is npm (the default) or osv-scanner.
+	Tool string `yaml:"tool,omitempty"`
+}
+
+var severities = []string{"info", "low", "moderate", "high", "critical"}
+
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if s == strings.ToLower(severity) {
+			return i
+		}
+	}
+	return -1
+}
+
+type auditReport struct {
+	counts   map[string]int
+	packages map[string]string
+}
+
+// auditDependencies runs the configured scanner and returns an error when
+// vulnerabilities at or above the threshold are found. force reports them
+// without blocking.
+func auditDependencies(config AuditConfig, force bool) error {
+	threshold := config.Threshold
+	if threshold == "" {
+		threshold = "critical"
+	}
+	if threshold == "off" {
+		return nil
+	}
+	if severityRank(threshold) < 0 {
+		return fmt.Errorf("unknown audit threshold %q, use one of %s or off", threshold, strings.Join(severities[1:], ", "))
+	}
+
+	var report *auditReport
+	var err error
+	if config.Tool == "osv-scanner" {
+		report, err = osvAudit()
+	} else {
+		report, err = npmAudit()
+	}
+	if err != nil {
+		fmt.Println("Skipping the dependency audit:", err)
+		return nil
+	}
+
+	var summary []string
+	blocking := 0
+	for i := len(severities) - 1; i > 0; i-- {
+		count := report.counts[severities[i]]
+		if count > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", count, severities[i]))
+		}
+		if i >= severityRank(threshold) {
+			blocking += count
+		}
+	}
+	if len(summary) == 0 {
+		fmt.Println("Dependency audit found no vulnerabilities")
+		return nil
+	}
+	fmt.Println("Dependency audit found", strings.Join(summary, ", "))
+	var names []string
+	for name := range report.packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if severityRank(report.packages[name]) >= severityRank(threshold) {
+			fmt.Printf("  %s (%s)\n", name, report.packages[name])
+		}
+	}
+	if blocking == 0 {
+		return nil
+	}
+	if force {
+		fmt.Println("Deploying anyway because of --force")
+		return nil
+	}
+	return fmt.Errorf("%d vulnerabilities at or above %s; fix them with 'npm audit fix' or deploy with --force", blocking, threshold)
+}
+
+func npmAudit() (*auditReport, error) {
+	// npm audit exits nonzero when it finds anything, so only the output
+	// tells whether it ran.
+	out, _ := command("npm", "audit", "--json").Output()
+	var result struct {
+		Error *struct {
+			Summary string `json:"summary"`
+		} `json:"error"`
+		Vulnerabilities map[string]struct {
+			Severity string `json:"severity"`
+		} `json:"vulnerabilities"`
+		Advisories map[string]struct {
+			ModuleName string `json:"module_name"`
+			Severity   string `json:"severity"`
+		} `json:"advisories"`
+		Metadata struct {
+			Vulnerabilities map[string]int `json:"vulnerabilities"`
+		} `json:"metadata"`
+	}
+	err := json.Unmarshal(out, &result)
+	if err != nil {
+		return nil, fmt.Errorf("npm audit did not produce a report")
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("npm audit: %s", result.Error.Summary)
+	}
+	report := &auditReport{counts: result.Metadata.Vulnerabilities, packages: map[string]string{}}
+	for name, vulnerability := range result.Vulnerabilities {
+		report.packages[name] = vulnerability.Severity
+	}
+	// npm 6 reports advisories rather than vulnerable packages.
+	for _, advisory := range result.Advisories {
+		if severityRank(advisory.Severity) > severityRank(report.packages[advisory.ModuleName]) {
+			report.packages[advisory.ModuleName] = advisory.Severity
+		}
+	}
+	return report, nil
+}
+
+func osvAudit() (*auditReport, error) {
+	out, _ := command("osv-scanner", "--format", "json", "-r", ".").Output()
+	var result struct {
+		Results []struct {
+			Packages []struct {
+				Package struct {
+					Name string `json:"name"`
+				} `json:"package"`
+				Groups []struct {
+					MaxSeverity string `json:"max_severity"`
+				} `json:"groups"`
+			} `json:"packages"`
+		} `json:"results"`
+	}
+	err := json.Unmarshal(out, &result)
+	if err != nil {
+		return nil, fmt.Errorf("osv-scanner did not produce a report")
+	}
+	report := &auditReport{counts: map[string]int{}, packages: map[string]string{}}
+	for _, source := range result.Results {
+		for _, pkg := range source.Packages {
+			for _, group := range pkg.Groups {
+				severity := cvssSeverity(group.MaxSeverity)
+				report.counts[severity]++
+				if severityRank(severity) > severityRank(report.packages[pkg.Package.Name]) {
+					report.packages[pkg.Package.Name] = severity
+				}
+			}
+		}
+	}
+	return report, nil
+}
+
+// cvssSeverity maps a CVSS score to npm's severity names.
+func cvssSeverity(score string) string {
+	value, err := strconv.ParseFloat(score, 64)
+	switch {
+	case err != nil:
+		return "info"
+	case value >= 9:
+		return "critical"
+	case value >= 7:
+		return "high"
+	case value >= 4:
+		return "moderate"
+	case value > 0:
+		return "low"
+	}
+	return "info"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AptConfig is written to apt.yml for the apt buildpack, which installs
+// native libraries such as image processing ones before the Node buildpack
+// builds the app.
+type AptConfig struct {
+	Packages []string `yaml:"packages,omitempty"`
+	Repos    []string `yaml:"repos,omitempty"`
+	Keys     []string `yaml:"keys,omitempty"`
+}
+
+func (c AptConfig) enabled() bool {
+	return len(c.Packages) > 0
+}
+
+// buildpacks are passed to push in order; the last one starts the app.
+func (c *Config) buildpacks() []string {
+	if len(c.Buildpacks) > 0 {
+		return c.Buildpacks
+	}
+	if c.Buildpack != "" {
+		return []string{c.Buildpack}
+	}
+	return nil
+}
+
+// finalBuildpack is the buildpack that runs the app, "" when detected.
+func (c *Config) finalBuildpack() string {
+	buildpacks := c.buildpacks()
+	if len(buildpacks) == 0 {
+		return ""
+	}
+	return buildpacks[len(buildpacks)-1]
+}
+
+// useNativePackages adds native_packages to apt.yml and puts the apt
+// buildpack in front of the app's buildpack, so listing packages such as
+// imagemagick is all a project has to do.
+func (c *Config) useNativePackages() {
+	if len(c.NativePackages) == 0 {
+		return
+	}
+	listed := map[string]bool{}
+	for _, pkg := range c.Apt.Packages {
+		listed[pkg] = true
+	}
+	for _, pkg := range c.NativePackages {
+		if !listed[pkg] {
+			c.Apt.Packages = append(c.Apt.Packages, pkg)
+			listed[pkg] = true
+		}
+	}
+
+	buildpacks := c.buildpacks()
+	if len(buildpacks) == 0 {
+		buildpacks = []string{"nodejs_buildpack"}
+	}
+	for _, buildpack := range buildpacks {
+		if strings.Contains(buildpack, "apt") {
+			c.Buildpacks = buildpacks
+			return
+		}
+	}
+	c.Buildpacks = append([]string{"apt_buildpack"}, buildpacks...)
+}
+
+// writeAptFile writes apt.yml into the directory being pushed.
+func writeAptFile(config AptConfig, dir string) error {
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		fmt.Println("Not writing apt.yml into the prebuilt", dir)
+		return nil
+	}
+	aptFile := struct {
+		CleanCache bool `yaml:"cleancache"`
+		AptConfig  `yaml:",inline"`
+	}{true, config}
+	data, err := yaml.Marshal(aptFile)
+	if err != nil {
+		return err
+	}
+	err = writeGenerated(filepath.Join(dir, "apt.yml"), append([]byte("---\n"), data...))
+	if err != nil {
+		return fmt.Errorf("writing apt.yml: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+)
+
+// routerStatus picks the response status out of a gorouter access log line.
+var routerStatus = regexp.MustCompile(`\[RTR/\d+\]\s+OUT .*?"[A-Z]+ [^"]*" (\d{3}) `)
+
+// canarySkips are the deploy steps a canary leaves to the main deploy: the
+// workers and network policies belong to the app, and the canary gets no
+// traffic to warm up until serveCanary maps its routes.
+var canarySkips = map[string]bool{"workers": true, "network": true, "warmup": true}
+
+type canaryOptions struct {
+	percent      int
+	window       time.Duration
+	maxErrorRate float64
+}
+
+// runCanary deploys the new version as <app>-canary on the app's routes with
+// roughly percent of its instances, watches the canary's router logs, and
+// removes it again. It fails if the canary served too many 5xx responses.
+func (d *deployment) runCanary(options canaryOptions) error {
+	if onKorifi(d.cliConnection) {
+		return fmt.Errorf("canary deploys read the app's routes and instances through the v2 API, which Korifi does not serve")
+	}
+	app, err := d.cliConnection.GetApp(d.name)
+	if err != nil {
+		return fmt.Errorf("canary deploys need %s to be running already: %v", d.name, err)
+	}
+	if len(app.Routes) == 0 {
+		return fmt.Errorf("canary deploys need %s to have a route to share", d.name)
+	}
+
+	canary := d.forApp(d.name + "-canary")
+	canary.noRoute = true
+	canary.canary = true
+	canary.monitorWindow = 0
+	fmt.Printf("Deploying canary %s\n", canary.name)
+	err = canary.run()
+	if err == nil {
+		err = d.serveCanary(canary, app, options)
+	}
+
+	fmt.Printf("Removing canary %s\n", canary.name)
+	for _, route := range app.Routes {
+		d.cliConnection.CliCommand(unmapRouteArgs(canary.name, route)...)
+	}
+	_, deleteErr := d.cliConnection.CliCommand("delete", canary.name, "-f")
+	if deleteErr != nil {
+		fmt.Printf("Could not delete the canary, run 'cf delete %s -f': %v\n", canary.name, deleteErr)
+	}
+	return err
+}
+
+func (d *deployment) serveCanary(canary *deployment, app plugin_models.GetAppModel, options canaryOptions) error {
+	instances := canaryInstances(app.InstanceCount, options.percent)
+	_, err := d.cliConnection.CliCommand("scale", canary.name, "-i", fmt.Sprint(instances))
+	if err != nil {
+		return err
+	}
+	err = canary.waitForInstances()
+	if err != nil {
+		return err
+	}
+	for _, route := range app.Routes {
+		_, err = d.cliConnection.CliCommand(mapRouteArgs(canary.name, route)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Canary is taking about %d%% of traffic, watching it for %v\n", 100*instances/(app.InstanceCount+instances), options.window)
+	requests, failures, err := d.watchRouterLogs(canary.name, options.window)
+	if err != nil {
+		return err
+	}
+	rate := 0.0
+	if requests > 0 {
+		rate = 100 * float64(failures) / float64(requests)
+	}
+	fmt.Printf("Canary served %d requests, %d with a 5xx status (%.1f%%)\n", requests, failures, rate)
+	if rate > options.maxErrorRate {
+		return fmt.Errorf("canary error rate %.1f%% is above %.1f%%, rolled back", rate, options.maxErrorRate)
+	}
+	return nil
+}
+
+// canaryInstances is how many instances beside existing ones make up about
+// percent of all instances, since the router balances across instances.
+func canaryInstances(existing, percent int) int {
+	if percent >= 100 {
+		return existing
+	}
+	instances := int(math.Round(float64(existing*percent) / float64(100-percent)))
+	if instances < 1 {
+		return 1
+	}
+	return instances
+}
+
+// watchRouterLogs counts the requests and 5xx responses in the app's router
+// logs for window. Recent logs are polled and de-duplicated rather than
+// streamed, since streaming blocks the CLI connection.
+func (d *deployment) watchRouterLogs(name string, window time.Duration) (int, int, error) {
+	seen := map[string]bool{}
+	requests, failures := 0, 0
+	deadline := time.Now().Add(window)
+	for {
+		lines, err := recentLogs(d.cliConnection, name)
+		if err != nil {
+			return requests, failures, err
+		}
+		for _, line := range lines {
+			match := routerStatus.FindStringSubmatch(line)
+			if match == nil || seen[line] {
+				continue
+			}
+			seen[line] = true
+			requests++
+			if match[1][0] == '5' {
+				failures++
+			}
+		}
+		if time.Now().After(deadline) {
+			return requests, failures, nil
+		}
+		time.Sleep(30 * time.Second)
+	}
+}
+
+func mapRouteArgs(name string, route plugin_models.GetApp_RouteSummary) []string {
+	args := []string{"map-route", name, route.Domain.Name}
+	if route.Host != "" {
+		args = append(args, "--hostname", route.Host)
+	}
+	if route.Path != "" {
+		args = append(args, "--path", route.Path)
+	}
+	return args
+}
+
+func unmapRouteArgs(name string, route plugin_models.GetApp_RouteSummary) []string {
+	args := mapRouteArgs(name, route)
+	args[0] = "unmap-route"
+	return args
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+type capiErrors struct {
+	Errors []struct {
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	} `json:"errors"`
+}
+
+// cfCurl calls the Cloud Controller through `cf curl`, so requests use the
+// endpoint and token the CLI is already logged in with. in is sent as the
+// JSON body when non-nil and the response is decoded into out when non-nil.
+func cfCurl(cliConnection plugin.CliConnection, method, path string, in, out interface{}) error {
+	args := []string{"curl", path, "-X", method}
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-d", string(data))
+	}
+	output, err := cliConnection.CliCommandWithoutTerminalOutput(args...)
+	if err != nil {
+		return err
+	}
+	raw := []byte(strings.Join(output, "\n"))
+
+	// cf curl succeeds whatever the response status, so API errors have to
+	// be picked out of the body.
+	var failure capiErrors
+	if json.Unmarshal(raw, &failure) == nil && len(failure.Errors) > 0 {
+		return fmt.Errorf("%s %s: %s", method, path, failure.Errors[0].Detail)
+	}
+	if out == nil || len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, out)
+}
+
+type v3App struct {
+	GUID      string     `json:"guid"`
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	UpdatedAt string     `json:"updated_at"`
+	Metadata  v3Metadata `json:"metadata"`
+}
+
+// findApp looks the app up by name in the targeted space and returns nil
+// when it does not exist yet.
+func findApp(cliConnection plugin.CliConnection, name string) (*v3App, error) {
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil {
+		return nil, err
+	}
+	var apps struct {
+		Resources []v3App `json:"resources"`
+	}
+	path := "/v3/apps?names=" + url.QueryEscape(name) + "&space_guids=" + space.Guid
+	err = cfCurl(cliConnection, "GET", path, nil, &apps)
+	if err != nil {
+		return nil, err
+	}
+	if len(apps.Resources) == 0 {
+		return nil, nil
+	}
+	return &apps.Resources[0], nil
+}
+
+type v3ServiceInstance struct {
+	GUID     string     `json:"guid"`
+	Name     string     `json:"name"`
+	Type     string     `json:"type"`
+	Metadata v3Metadata `json:"metadata"`
+}
+
+// findServiceInstance looks the service instance up by name in the targeted
+// space and returns nil when it does not exist. It reads the v3 API, so it
+// works on Korifi as well.
+func findServiceInstance(cliConnection plugin.CliConnection, name string) (*v3ServiceInstance, error) {
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil {
+		return nil, err
+	}
+	var instances struct {
+		Resources []v3ServiceInstance `json:"resources"`
+	}
+	path := "/v3/service_instances?names=" + url.QueryEscape(name) + "&space_guids=" + space.Guid
+	err = cfCurl(cliConnection, "GET", path, nil, &instances)
+	if err != nil {
+		return nil, err
+	}
+	if len(instances.Resources) == 0 {
+		return nil, nil
+	}
+	return &instances.Resources[0], nil
+}
+
+// currentDroplet returns the GUID of the droplet the app runs, or "" when it
+// has never been staged or the droplet cannot be read.
+func currentDroplet(cliConnection plugin.CliConnection, appGUID string) string {
+	var droplet struct {
+		GUID string `json:"guid"`
+	}
+	err := cfCurl(cliConnection, "GET", "/v3/apps/"+appGUID+"/droplets/current", nil, &droplet)
+	if err != nil {
+		return ""
+	}
+	return droplet.GUID
+}
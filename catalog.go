@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// catalogFile maps logical service types to the offerings a foundation's
+// marketplace has. It is checked in so a team shares one mapping.
+const catalogFile = "service-catalog.yml"
+
+type catalogEntry struct {
+	Service string `yaml:"service"`
+	Plan    string `yaml:"plan"`
+}
+
+// builtinCatalog is used for types service-catalog.yml does not list.
+var builtinCatalog = map[string]catalogEntry{
+	"mysql":    {Service: "cleardb", Plan: "turtle"},
+	"postgres": {Service: "elephantsql", Plan: "turtle"},
+	"redis":    {Service: "rediscloud", Plan: "30mb"},
+	"email":    {Service: "sendgrid", Plan: "free"},
+}
+
+// loadCatalog maps the service types, from service-catalog.yml over the
+// preset's offerings, if one is named, over the built-in ones.
+func loadCatalog(presetName string) (map[string]catalogEntry, error) {
+	catalog := map[string]catalogEntry{}
+	for serviceType, entry := range builtinCatalog {
+		catalog[serviceType] = entry
+	}
+	if chosen, ok := presets[presetName]; ok {
+		for serviceType, entry := range chosen.catalog {
+			catalog[serviceType] = entry
+		}
+	}
+	data, err := ioutil.ReadFile(catalogFile)
+	if os.IsNotExist(err) {
+		return catalog, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]catalogEntry
+	err = yaml.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", catalogFile, err)
+	}
+	for serviceType, entry := range entries {
+		catalog[serviceType] = entry
+	}
+	return catalog, nil
+}
+
+// resolveServiceTypes fills in the offering and plan of services that only
+// give a type. Services naming an offering are left alone.
+func (c *Config) resolveServiceTypes() error {
+	catalog, err := loadCatalog(c.Preset)
+	if err != nil {
+		return err
+	}
+	for i, service := range c.Services {
+		if service.Type == "" || service.Service != "" {
+			continue
+		}
+		entry, ok := catalog[service.Type]
+		if !ok {
+			var known []string
+			for serviceType := range catalog {
+				known = append(known, serviceType)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("service %s has type %q, which %s does not map; known types are %s", service.Name, service.Type, catalogFile, strings.Join(known, ", "))
+		}
+		c.Services[i].Service = entry.Service
+		c.Services[i].Plan = entry.Plan
+	}
+	return nil
+}
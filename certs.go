@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+func certs(cliConnection plugin.CliConnection, config *Config, args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Println("Usage: cf treeline certs check [--days N] [APP]")
+		exitFailed("usage")
+	}
+	flags := flag.NewFlagSet("certs check", flag.ExitOnError)
+	days := flags.Int("days", 30, "warn about certificates expiring within this many days")
+	flags.Parse(args[1:])
+
+	name := flags.Arg(0)
+	if name == "" {
+		name = config.App
+	}
+	if skipOffline("the certificate check") {
+		return
+	}
+	ok, err := checkCertificates(cliConnection, name, time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		fail(err)
+	}
+	if !ok {
+		exitFailed("check-failed")
+	}
+}
+
+// checkCertificates connects to each of the app's routes on a domain other
+// than the foundation's shared ones, whose certificates the platform looks
+// after, and reports the certificate served there. It returns false when
+// one is expiring within threshold or could not be checked.
+func checkCertificates(cliConnection plugin.CliConnection, name string, threshold time.Duration) (bool, error) {
+	app, err := cliConnection.GetApp(name)
+	if err != nil {
+		return false, err
+	}
+	domains, err := orgDomains(cliConnection)
+	if err != nil {
+		return false, err
+	}
+	shared := map[string]bool{}
+	for _, domain := range domains {
+		if domain.shared() {
+			shared[domain.Name] = true
+		}
+	}
+
+	checked := map[string]bool{}
+	ok := true
+	for _, route := range app.Routes {
+		if shared[route.Domain.Name] {
+			continue
+		}
+		host := route.Domain.Name
+		if route.Host == "*" {
+			host = "treeline-cf-check." + host
+		} else if route.Host != "" {
+			host = route.Host + "." + host
+		}
+		if checked[host] {
+			continue
+		}
+		checked[host] = true
+
+		certificate, err := serverCertificate(host)
+		if err != nil {
+			fmt.Printf("%s: %v\n", host, err)
+			ok = false
+			continue
+		}
+		issuer := certificate.Issuer.CommonName
+		if len(certificate.Issuer.Organization) > 0 {
+			issuer = strings.Join(certificate.Issuer.Organization, ", ")
+		}
+		left := time.Until(certificate.NotAfter)
+		status := fmt.Sprintf("expires %s, in %d days", certificate.NotAfter.Format("2006-01-02"), int(left.Hours()/24))
+		switch {
+		case left <= 0:
+			status = "EXPIRED on " + certificate.NotAfter.Format("2006-01-02")
+			ok = false
+		case left < threshold:
+			status = "Warning: " + status
+			ok = false
+		}
+		fmt.Printf("%s: issued by %s, %s\n", host, issuer, status)
+	}
+	if len(checked) == 0 {
+		fmt.Printf("%s has no routes on custom domains\n", name)
+	}
+	return ok, nil
+}
+
+// serverCertificate returns the certificate host serves on port 443. It is
+// read even when it does not verify, so an expired one is still reported.
+func serverCertificate(host string) (*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, "443"), &tls.Config{ServerName: host, InsecureSkipVerify: true})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("no certificate served")
+	}
+	leaf := state.PeerCertificates[0]
+	if err := leaf.VerifyHostname(host); err != nil {
+		return nil, err
+	}
+	return leaf, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// commandSpec describes a subcommand for shell completion. Keep it in step
+// with the flags each command defines.
+type commandSpec struct {
+	subcommands []string
+	flags       []string
+	// apps completes positional arguments with app names in the space.
+	apps bool
+}
+
+var commandSpecs = map[string]commandSpec{
+	"new":        {flags: []string{"--skip-link", "--deploy", "--preset"}},
+	"config-pws": {flags: []string{"--queue", "--scaffold"}},
+	"deploy": {flags: []string{"--pr", "--steal-lock", "--resume", "--ready-timeout", "--monitor", "--auto-fix",
+		"--canary", "--canary-window", "--canary-max-error-rate", "--force", "--log-file", "--env", "--ci",
+		"--approval-timeout", "--override-window", "--path", "--artifact", "--save-droplet", "--watch", "--rollback",
+		"--stack", "--sandbox", "--plan", "--json", "--targets",
+		"--break-glass", "--bandwidth", "--staging-timeout", "--start-timeout", "--push-arg"}},
+	"diagnose":      {apps: true},
+	"routes":        {subcommands: []string{"split", "show", "check"}, flags: []string{"--domain", "--hostname", "--path", "--weight", "--instances"}, apps: true},
+	"diff":          {flags: []string{"--apply"}},
+	"apply":         {flags: []string{"--env", "--yes", "--plan", "--json"}},
+	"import-app":    {flags: []string{"--force"}, apps: true},
+	"licenses":      {flags: []string{"--format", "--output"}},
+	"mp":            {subcommands: []string{"browse", "install"}},
+	"space":         {subcommands: []string{"status", "cleanup"}, flags: []string{"--days", "--yes"}},
+	"env":           {subcommands: []string{"push", "pull", "show", "keygen"}, flags: []string{"--env", "--reveal"}, apps: true},
+	"auth":          {subcommands: []string{"set", "remove", "list"}},
+	"completion":    {subcommands: []string{"bash", "zsh", "fish"}},
+	"droplet":       {subcommands: []string{"save", "load"}, flags: []string{"-o"}, apps: true},
+	"snapshot":      {subcommands: []string{"save", "restore"}, flags: []string{"--app", "--no-droplet"}},
+	"ssh":           {flags: []string{"-i", "--repl"}},
+	"console":       {flags: []string{"-i"}},
+	"logs":          {flags: []string{"--grep", "--since", "--source", "--follow", "--json"}, apps: true},
+	"watch":         {flags: []string{"--window", "--max-error-rate", "--max-crashes", "--rollback"}, apps: true},
+	"guard":         {flags: []string{"--interval", "--grace", "--webhook", "--no-restart"}, apps: true},
+	"sleep":         {flags: []string{"--after", "--interval", "--once"}, apps: true},
+	"wake":          {apps: true},
+	"cf":            {flags: []string{"--env"}},
+	"certs":         {subcommands: []string{"check"}, flags: []string{"--days"}, apps: true},
+	"diff-files":    {apps: true},
+	"freeze":        {subcommands: []string{"on", "off", "status"}, flags: []string{"--reason"}},
+	"failover":      {flags: []string{"--to", "--from", "--yes"}},
+	"migrate-stack": {flags: []string{"--stack"}, apps: true},
+	"ui":            {apps: true},
+	"serve":         {flags: []string{"--port", "--token"}},
+	"extensions":    {},
+	"telemetry":     {subcommands: []string{"on", "off", "status"}, flags: []string{"--endpoint"}},
+}
+
+// flagValues completes the value after a flag. The config is nil when it
+// could not be loaded.
+var flagValues = map[string]func(config *Config) []string{
+	"--queue":  func(*Config) []string { return []string{"bull", "kue"} },
+	"--repl":   func(*Config) []string { return []string{"node", "sails"} },
+	"--source": func(*Config) []string { return []string{"APP", "RTR", "STG", "CELL", "API"} },
+	"--format": func(*Config) []string { return []string{"text", "csv", "json"} },
+	"--preset": func(*Config) []string { return strings.Split(presetNames(), ", ") },
+	"--env": func(config *Config) []string {
+		if config == nil {
+			return nil
+		}
+		var names []string
+		for name := range config.Environments {
+			names = append(names, name)
+		}
+		return names
+	},
+}
+
+const bashCompletion = `# cf treeline completion for bash. Load it with:
+#   source <(cf treeline completion bash)
+_cf_treeline() {
+  local cur="${COMP_WORDS[COMP_CWORD]}"
+  if [ "$COMP_CWORD" -lt 2 ] || [ "${COMP_WORDS[1]}" != "treeline" ]; then
+    return
+  fi
+  local IFS=$'\n'
+  COMPREPLY=($(compgen -W "$(cf treeline completion __complete "${COMP_WORDS[@]:2:COMP_CWORD-2}" 2>/dev/null)" -- "$cur"))
+}
+complete -o default -F _cf_treeline cf
+`
+
+const zshCompletion = `#compdef cf
+# cf treeline completion for zsh. Load it with:
+#   source <(cf treeline completion zsh)
+_cf_treeline() {
+  if (( CURRENT < 3 )) || [[ ${words[2]} != treeline ]]; then
+    _files
+    return
+  fi
+  local -a candidates
+  candidates=(${(f)"$(cf treeline completion __complete ${words[3,CURRENT-1]} 2>/dev/null)"})
+  compadd -a candidates
+}
+compdef _cf_treeline cf
+`
+
+const fishCompletion = `# cf treeline completion for fish. Load it with:
+#   cf treeline completion fish | source
+function __cf_treeline_complete
+  set -l words (commandline -opc)
+  cf treeline completion __complete $words[3..-1] 2>/dev/null
+end
+complete -c cf -n '__fish_seen_subcommand_from treeline' -f -a '(__cf_treeline_complete)'
+`
+
+func completion(cliConnection plugin.CliConnection, config *Config, args []string) {
+	if len(args) > 0 && args[0] == "__complete" {
+		for _, candidate := range completeWords(cliConnection, config, args[1:]) {
+			fmt.Println(candidate)
+		}
+		return
+	}
+	scripts := map[string]string{"bash": bashCompletion, "zsh": zshCompletion, "fish": fishCompletion}
+	if len(args) != 1 || scripts[args[0]] == "" {
+		fmt.Println("Usage: cf treeline completion bash|zsh|fish")
+		exitFailed("usage")
+	}
+	fmt.Print(scripts[args[0]])
+}
+
+// completeWords returns the candidates for the word after words, which are
+// the arguments typed after 'cf treeline'.
+func completeWords(cliConnection plugin.CliConnection, config *Config, words []string) []string {
+	if len(words) == 0 {
+		var names []string
+		for name := range commandSpecs {
+			names = append(names, name)
+		}
+		if config != nil {
+			for name := range config.Aliases {
+				if _, ok := commandSpecs[name]; !ok {
+					names = append(names, name)
+				}
+			}
+		}
+		sort.Strings(names)
+		return append(names, "--offline", "--install-missing", "--project-dir")
+	}
+	spec, ok := commandSpecs[words[0]]
+	if !ok {
+		return nil
+	}
+	last := words[len(words)-1]
+	if values, ok := flagValues[last]; ok {
+		return values(config)
+	}
+	if strings.HasPrefix(last, "--") && !boolFlags[last] {
+		return nil
+	}
+
+	var candidates []string
+	if len(words) == 1 {
+		candidates = append(candidates, spec.subcommands...)
+	}
+	if words[0] == "auth" && len(words) == 2 && words[1] != "list" {
+		for name := range credentials {
+			candidates = append(candidates, name)
+		}
+	}
+	if spec.apps && (len(spec.subcommands) == 0 || len(words) > 1) {
+		apps, err := cliConnection.GetApps()
+		if err == nil {
+			for _, app := range apps {
+				candidates = append(candidates, app.Name)
+			}
+		}
+	}
+	candidates = append(candidates, spec.flags...)
+	sort.Strings(candidates)
+	return candidates
+}
+
+// boolFlags take no value, so the next word is completed as usual.
+var boolFlags = map[string]bool{
+	"--skip-link": true, "--deploy": true, "--steal-lock": true, "--resume": true, "--auto-fix": true,
+	"--force": true, "--ci": true, "--override-window": true, "--apply": true, "--yes": true, "--offline": true,
+	"--follow": true, "--json": true, "--rollback": true, "--sandbox": true, "--plan": true, "--no-droplet": true, "--break-glass": true,
+	"--no-restart": true, "--reveal": true, "--once": true, "--install-missing": true, "--scaffold": true,
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/SocalNick/cf-treeline-cli/treelinecf"
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is the optional per-project plugin configuration, read from the
+// directory the plugin is run in.
+const configFile = ".treeline-cf.yml"
+
+type Config struct {
+	// App is the Cloud Foundry app name.
+	App string `yaml:"app"`
+	// AppSuffix is appended to App and to environment app names, usually
+	// from .treeline-cf.local.yml for a personal copy.
+	AppSuffix string `yaml:"app_suffix,omitempty"`
+	// Org and Space are targeted before deploying when set.
+	Org   string `yaml:"org,omitempty"`
+	Space string `yaml:"space,omitempty"`
+	// Memory is the memory limit per instance, such as 512M or 1G.
+	Memory    string `yaml:"memory,omitempty"`
+	Instances int    `yaml:"instances,omitempty"`
+	Buildpack string `yaml:"buildpack,omitempty"`
+	// Buildpacks replaces Buildpack with several, applied in order, such as
+	// [apt_buildpack, nodejs_buildpack].
+	Buildpacks []string  `yaml:"buildpacks,omitempty"`
+	Apt        AptConfig `yaml:"apt,omitempty"`
+	// NativePackages are apt packages the app needs, like imagemagick or
+	// postgresql-client. Listing any adds the apt buildpack.
+	NativePackages []string `yaml:"native_packages,omitempty"`
+	// Stack is the root filesystem, such as cflinuxfs4.
+	Stack string `yaml:"stack,omitempty"`
+	// StagingTimeout is how long staging may take, such as 15m, and
+	// StartTimeout how long the app may take to start after it, which is
+	// also its health check timeout. Sails apps with big asset builds often
+	// need more than the cf CLI defaults.
+	StagingTimeout string `yaml:"staging_timeout,omitempty"`
+	StartTimeout   string `yaml:"start_timeout,omitempty"`
+	// PushArgs are appended to the app's cf push as they are, such as
+	// [--disk, 2G], for cf push flags the config does not cover.
+	PushArgs []string `yaml:"push_args,omitempty"`
+	// Type is sails or node, detected when empty, or generic to deploy
+	// other projects with Buildpack and skip the Node specific steps.
+	Type string `yaml:"type,omitempty"`
+	// Preset is pws-free, pws-paid, korifi or tanzu, which picks the
+	// service offerings, buildpack and size the config leaves unset.
+	Preset string `yaml:"preset,omitempty"`
+	// Routes are mapped to the app after it is pushed, in addition to its
+	// default route.
+	Routes []RouteConfig `yaml:"routes,omitempty"`
+	// Env is set on the app at deploy.
+	Env map[string]string `yaml:"env,omitempty"`
+	// PruneDevDependencies true leaves devDependencies out of staging, with
+	// NPM_CONFIG_PRODUCTION, even though NODE_ENV is development.
+	PruneDevDependencies *bool `yaml:"prune_dev_dependencies,omitempty"`
+	// Services are created when missing and bound to the app.
+	Services []ServiceConfig `yaml:"services"`
+	Workers  []WorkerConfig  `yaml:"workers,omitempty"`
+	Network  NetworkConfig   `yaml:"network,omitempty"`
+	// WorkerParallelism is how many workers deploy at once, 4 by default.
+	WorkerParallelism int `yaml:"worker_parallelism,omitempty"`
+
+	GitHub   GitHubConfig   `yaml:"github,omitempty"`
+	Metrics  MetricsConfig  `yaml:"metrics,omitempty"`
+	Audit    AuditConfig    `yaml:"audit,omitempty"`
+	Licenses LicensesConfig `yaml:"licenses,omitempty"`
+	Npm      NpmConfig      `yaml:"npm,omitempty"`
+	Proxy    ProxyConfig    `yaml:"proxy,omitempty"`
+	Assets   AssetsConfig   `yaml:"assets,omitempty"`
+	Uploads  UploadsConfig  `yaml:"uploads,omitempty"`
+	Upload   UploadConfig   `yaml:"upload,omitempty"`
+	Warmup   WarmupConfig   `yaml:"warmup,omitempty"`
+	Tracing  TracingConfig  `yaml:"tracing,omitempty"`
+
+	Database    DatabaseConfig    `yaml:"database,omitempty"`
+	CrashLoop   CrashLoopConfig   `yaml:"crash_loop,omitempty"`
+	SchemaCheck SchemaCheckConfig `yaml:"schema_check,omitempty"`
+	Sleep       SleepConfig       `yaml:"sleep,omitempty"`
+	Treeline    TreelineConfig    `yaml:"treeline,omitempty"`
+	Tools       ToolsConfig       `yaml:"tools,omitempty"`
+	// Umask, such as 027, sets the permissions of the files the plugin
+	// generates; they are 0644 by default.
+	Umask string `yaml:"umask,omitempty"`
+
+	DeployWindows DeployWindowsConfig `yaml:"deploy_windows,omitempty"`
+	// UpdateCheck set to false stops the daily check for a newer plugin.
+	UpdateCheck *bool `yaml:"update_check,omitempty"`
+	// NotifyAfter is how long a deploy runs, such as 2m, before a desktop
+	// notification says it ended. It is 1m by default; off disables it.
+	NotifyAfter string `yaml:"notify_after,omitempty"`
+
+	// Aliases name the team's standard invocations, such as
+	// ship: "deploy --env prod --watch 5m", run as 'cf treeline ship'.
+	Aliases map[string]string `yaml:"aliases,omitempty"`
+
+	Environments map[string]EnvironmentConfig `yaml:"environments,omitempty"`
+	// Targets are foundations deploy --targets deploys to in turn.
+	Targets  map[string]TargetConfig `yaml:"targets,omitempty"`
+	Failover FailoverConfig          `yaml:"failover,omitempty"`
+	// Environment is the environment selected with --env, if any.
+	Environment string `yaml:"-"`
+}
+
+type RouteConfig struct {
+	Hostname string `yaml:"hostname,omitempty"`
+	Domain   string `yaml:"domain"`
+	Path     string `yaml:"path,omitempty"`
+	// Protocol is http1, the default, or http2 for gRPC-web and multiplexed
+	// connections between the router and the app.
+	Protocol string `yaml:"protocol,omitempty"`
+	// CreateDomain creates Domain as a private domain of the org when it
+	// is not there yet.
+	CreateDomain bool `yaml:"create_domain,omitempty"`
+}
+
+func (r RouteConfig) String() string {
+	if r.Hostname == "" {
+		return r.Domain + r.Path
+	}
+	return r.Hostname + "." + r.Domain + r.Path
+}
+
+func (r RouteConfig) mapArgs(app string) []string {
+	args := []string{"map-route", app, r.Domain}
+	if r.Hostname != "" {
+		args = append(args, "--hostname", r.Hostname)
+	}
+	if r.Path != "" {
+		args = append(args, "--path", r.Path)
+	}
+	return args
+}
+
+func (r RouteConfig) unmapArgs(app string) []string {
+	args := r.mapArgs(app)
+	args[0] = "unmap-route"
+	return args
+}
+
+type ServiceConfig struct {
+	// Name is the service instance name.
+	Name string `yaml:"name"`
+	// Service is the marketplace offering and Plan one of its plans. They
+	// are empty for user-provided services, which are only bound.
+	Service string `yaml:"service,omitempty"`
+	Plan    string `yaml:"plan,omitempty"`
+	// Type is a logical type such as mysql, redis or email, looked up in
+	// service-catalog.yml when Service is empty.
+	Type string `yaml:"type,omitempty"`
+	// Parameters are passed to the broker when binding, as with
+	// 'cf bind-service -c', such as {read_only: true} or {database: app}.
+	Parameters map[string]interface{} `yaml:"parameters,omitempty"`
+	// BindingName names the binding, such as db or cache. The generated
+	// Sails config looks credentials up by it first, so it keeps working
+	// when the instance or offering changes.
+	BindingName string `yaml:"binding_name,omitempty"`
+}
+
+func (s ServiceConfig) service() treelinecf.Service {
+	return treelinecf.Service{Name: s.Name, Offering: s.Service, Plan: s.Plan, Parameters: s.Parameters, BindingName: s.BindingName}
+}
+
+// bindArgs are the cf arguments that bind the service to app.
+func (s ServiceConfig) bindArgs(app string) ([]string, error) {
+	return s.service().BindArgs(app)
+}
+
+// defaultServices are the services the generated Sails configuration
+// expects when the config does not list any.
+var defaultServices = []ServiceConfig{
+	{Name: "hackday-rediscloud", Type: "redis", BindingName: "cache"},
+	{Name: "hackday-cleardb", Type: "mysql", BindingName: "db"},
+}
+
+type GitHubConfig struct {
+	// Token is used to comment on pull requests. GITHUB_TOKEN is used when
+	// it is not set.
+	Token string `yaml:"token,omitempty"`
+	// Repo is "owner/name". It is derived from the origin remote when empty.
+	Repo string `yaml:"repo,omitempty"`
+	// API defaults to https://api.github.com and can point at GitHub
+	// Enterprise instead.
+	API string `yaml:"api,omitempty"`
+}
+
+func loadConfig() (*Config, error) {
+	config := &Config{}
+	doc := &yaml.Node{Kind: yaml.DocumentNode}
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		err = yaml.Unmarshal(data, doc)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %v", configFile, err)
+		}
+	}
+	local, err := readLocalConfig()
+	if err != nil {
+		return nil, err
+	}
+	if local != nil && len(doc.Content) == 0 {
+		doc = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if local != nil {
+		mergeConfig(doc.Content[0], local)
+	}
+	err = interpolate(doc, targetedSpace(doc))
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) > 0 {
+		err = doc.Decode(config)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %v", configFile, err)
+		}
+	}
+	err = config.usePreset()
+	if err != nil {
+		return nil, err
+	}
+	config.setDefaults()
+	config.useNativePackages()
+	err = config.useTarget(os.Getenv(targetEnv))
+	if err != nil {
+		return nil, err
+	}
+	err = config.resolveServiceTypes()
+	if err != nil {
+		return nil, err
+	}
+	useProxy(config.Proxy)
+	useTools(config.Tools)
+	err = useUmask(config.Umask)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (c *Config) setDefaults() {
+	if c.App == "" {
+		c.App = "hackday-nc"
+	}
+	c.App += c.AppSuffix
+	if c.Env == nil {
+		c.Env = map[string]string{"NODE_ENV": "development"}
+	}
+	if c.Services == nil {
+		c.Services = append([]ServiceConfig{}, defaultServices...)
+	}
+}
+
+// megabytes converts a Cloud Foundry memory or disk size such as 512M, 1G or
+// 1024MB to megabytes.
+func megabytes(size string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(size))
+	value = strings.TrimSuffix(value, "B")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1024
+		value = strings.TrimSuffix(value, "G")
+	case strings.HasSuffix(value, "M"):
+		value = strings.TrimSuffix(value, "M")
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return number * multiplier, nil
+}
+
+// editConfig applies edit to the YAML tree of .treeline-cf.yml, creating the
+// file if needed, so comments and formatting the user added are kept.
+func editConfig(edit func(root *yaml.Node) error) error {
+	doc := &yaml.Node{Kind: yaml.DocumentNode}
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		err = yaml.Unmarshal(data, doc)
+		if err != nil {
+			return fmt.Errorf("could not parse %s: %v", configFile, err)
+		}
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode}}
+	}
+	err = edit(doc.Content[0])
+	if err != nil {
+		return err
+	}
+	data, err = yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return writeGenerated(configFile, data)
+}
+
+// mappingEntry returns the value for key in a YAML mapping, adding an empty
+// node of the given kind when the key is missing.
+func mappingEntry(mapping *yaml.Node, key string, kind yaml.Kind) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	value := &yaml.Node{Kind: kind}
+	if kind == yaml.ScalarNode {
+		value.Tag = "!!str"
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	return value
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CrashLoopConfig sets when instances crashing while a deploy waits for them
+// fail it early instead of running out the wait.
+type CrashLoopConfig struct {
+	// Restarts is how many crashes within Window are a crash loop, 3 by
+	// default.
+	Restarts int `yaml:"restarts,omitempty"`
+	// Window is 5m by default.
+	Window string `yaml:"window,omitempty"`
+}
+
+func (c CrashLoopConfig) limits() (int, time.Duration, error) {
+	restarts, window := 3, 5*time.Minute
+	if c.Restarts > 0 {
+		restarts = c.Restarts
+	}
+	if c.Window != "" {
+		var err error
+		window, err = time.ParseDuration(c.Window)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid crash_loop window %q: %v", c.Window, err)
+		}
+	}
+	return restarts, window, nil
+}
+
+// stackTraceLines is the most log lines a crash loop failure quotes.
+const stackTraceLines = 30
+
+// crashLoop fails when the app's instances crashed more than the configured
+// number of times within the window since started, quoting the last stack
+// trace the app logged.
+func (d *deployment) crashLoop(appGUID string, started time.Time) error {
+	restarts, window, err := d.config.CrashLoop.limits()
+	if err != nil {
+		return err
+	}
+	since := time.Now().UTC().Add(-window)
+	if since.Before(started) {
+		since = started
+	}
+	crashes, err := crashEventsSince(d, appGUID, since)
+	if err != nil || len(crashes) <= restarts {
+		return nil
+	}
+	fmt.Printf("%s crashed %d times in %s, it is in a crash loop\n", d.name, len(crashes), window)
+	message := fmt.Sprintf("crash loop: %d crashes in %s, last: %s", len(crashes), window, crashes[len(crashes)-1])
+	logs, err := recentLogs(d.cliConnection, d.name)
+	if err != nil {
+		return fmt.Errorf("%s; could not read the crash logs: %v", message, err)
+	}
+	if trace := stackTrace(logs); len(trace) > 0 {
+		message += "\n" + strings.Join(trace, "\n")
+	}
+	return fmt.Errorf("%s", message)
+}
+
+// stackTrace returns the last block of error output the app logged that
+// holds a stack trace, or its last error lines when none does.
+func stackTrace(lines []string) []string {
+	var errors []string
+	end := -1
+	for _, line := range lines {
+		entry, ok := parseLogLine(line)
+		if !ok || entry.Stream != "ERR" || !strings.HasPrefix(entry.Source, "APP") {
+			continue
+		}
+		errors = append(errors, entry.Message)
+		if strings.HasPrefix(strings.TrimSpace(entry.Message), "at ") {
+			end = len(errors)
+		}
+	}
+	if end < 0 {
+		if len(errors) > stackTraceLines {
+			errors = errors[len(errors)-stackTraceLines:]
+		}
+		return errors
+	}
+	// Walk back over the frames to the error message above them.
+	start := end - 1
+	for start > 0 && strings.HasPrefix(strings.TrimSpace(errors[start]), "at ") {
+		start--
+	}
+	if end-start > stackTraceLines {
+		end = start + stackTraceLines
+	}
+	return errors[start:end]
+}
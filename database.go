@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+
+	"github.com/SocalNick/cf-treeline-cli/treelinecf"
+)
+
+// DatabaseConfig tunes the Postgres connection config-pws generates.
+type DatabaseConfig struct {
+	// PoolSize is how many connections each instance opens at most.
+	PoolSize int `yaml:"pool_size,omitempty"`
+	// IdleTimeout closes connections unused for this long, such as 30s.
+	IdleTimeout string `yaml:"idle_timeout,omitempty"`
+	// SSL connects over TLS when true. SSLRejectUnauthorized false accepts
+	// the self-signed certificates some brokers use.
+	SSL                   *bool `yaml:"ssl,omitempty"`
+	SSLRejectUnauthorized *bool `yaml:"ssl_reject_unauthorized,omitempty"`
+}
+
+// smallPlanPoolSize keeps within the five connections free plans such as
+// ElephantSQL's turtle allow, leaving one for cf treeline console.
+const smallPlanPoolSize = 4
+
+var smallPlans = map[string]bool{"elephantsql/turtle": true}
+
+func (c DatabaseConfig) pool(service ServiceConfig) treelinecf.Pool {
+	pool := treelinecf.Pool{Size: c.PoolSize}
+	if pool.Size == 0 && smallPlans[service.Service+"/"+service.Plan] {
+		pool.Size = smallPlanPoolSize
+	}
+	if timeout, err := time.ParseDuration(c.IdleTimeout); err == nil {
+		pool.IdleTimeoutMillis = int64(timeout / time.Millisecond)
+	}
+	switch {
+	case c.SSLRejectUnauthorized != nil && !*c.SSLRejectUnauthorized:
+		pool.SSL = "{ rejectUnauthorized: false }"
+	case c.SSL != nil && *c.SSL:
+		pool.SSL = "true"
+	case c.SSL != nil:
+		pool.SSL = "false"
+	}
+	return pool
+}
@@ -0,0 +1,656 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+	"github.com/SocalNick/cf-treeline-cli/treelinecf"
+)
+
+func deploy(cliConnection plugin.CliConnection, config *Config, args []string) {
+	flags := flag.NewFlagSet("deploy", flag.ExitOnError)
+	pr := flags.Int("pr", 0, "deploy a review app for the given pull request number")
+	stealLock := flags.Bool("steal-lock", false, "take over the deploy lock from a deploy that is no longer running")
+	resume := flags.Bool("resume", false, "continue the last failed deploy from the step that failed")
+	readyTimeout := flags.Duration("ready-timeout", 5*time.Minute, "how long to wait for every instance to be running")
+	monitorWindow := flags.Duration("monitor", 30*time.Second, "watch the app for out of memory crashes for this long after it starts")
+	autoFix := flags.Bool("auto-fix", false, "raise the memory limit when instances run out of memory while monitoring")
+	canaryPercent := flags.Int("canary", 0, "deploy to a canary app taking about `PERCENT` of traffic first")
+	canaryWindow := flags.Duration("canary-window", 5*time.Minute, "how long to watch the canary")
+	canaryMaxErrors := flags.Float64("canary-max-error-rate", 1, "roll back when more than this percent of canary requests fail")
+	force := flags.Bool("force", false, "deploy even when the dependency audit finds vulnerabilities")
+	logFile := flags.String("log-file", "", "append a JSON lines record of every action and cf command to `FILE`")
+	env := flags.String("env", "", "deploy the environment `NAME` from the config's environments")
+	ci := flags.Bool("ci", false, "poll the environment's approval_url instead of asking to confirm a protected deploy")
+	approvalTimeout := flags.Duration("approval-timeout", 30*time.Minute, "how long to wait for approval with --ci")
+	overrideWindow := flags.Bool("override-window", false, "deploy outside the configured deploy windows")
+	path := flags.String("path", "", "push the prebuilt directory `DIR` instead of the current directory")
+	artifact := flags.String("artifact", "", "push the prebuilt zip `FILE`, such as one built by CI")
+	saveDropletTo := flags.String("save-droplet", "", "download the staged droplet to `FILE` after a successful deploy")
+	watchWindow := flags.Duration("watch", 0, "watch 5xx responses and crashes for this long after the deploy, like 'cf treeline watch'")
+	rollback := flags.Bool("rollback", false, "with --watch, go back to the previous droplet when the app fails the watch")
+	stack := flags.String("stack", "", "stage on `STACK`, such as cflinuxfs4, instead of the config's")
+	sandbox := flags.Bool("sandbox", false, "deploy a personal copy with the app, routes and services suffixed with your cf username")
+	planOnly := flags.Bool("plan", false, "only show what the deploy would change")
+	asJSON := flags.Bool("json", false, "with --plan, print the plan as JSON")
+	breakGlass := flags.Bool("break-glass", false, "deploy even though the space is frozen; this is recorded")
+	stagingTimeout := flags.Duration("staging-timeout", 0, "how long staging may take, instead of the config's staging_timeout")
+	startTimeout := flags.Duration("start-timeout", 0, "how long the app may take to start after staging, instead of the config's start_timeout")
+	bandwidth := flags.String("bandwidth", "", "upload the app through the packages API at most `RATE` a second, such as 2M")
+	targets := flags.String("targets", "", "deploy to each of the comma separated `NAMES` from the config's targets in turn")
+	var pushArgs repeatedFlag
+	flags.Var(&pushArgs, "push-arg", "append `ARG` to cf push as it is; repeat for several")
+	flags.Parse(args)
+
+	if *logFile != "" {
+		err := events.open(userPath(*logFile))
+		if err != nil {
+			fmt.Println("Could not open log file:", err)
+			exitFailed(errorClass(err))
+		}
+		defer events.close()
+		cliConnection = loggedConnection{cliConnection}
+	}
+
+	if *targets != "" {
+		deployTargets(config, strings.Split(*targets, ","), withoutFlag(args, "--targets"))
+		return
+	}
+	err := config.useEnvironment(*env)
+	if err == nil {
+		err = config.checkProjectType()
+	}
+	if err == nil {
+		err = config.targetSpace(cliConnection)
+	}
+	if err == nil {
+		err = config.checkTarget(cliConnection, os.Getenv(targetEnv))
+	}
+	if err == nil && *sandbox {
+		var suffix string
+		suffix, err = sandboxSuffix(cliConnection)
+		if err == nil {
+			config.sandbox(suffix)
+			fmt.Println("Deploying the sandbox", config.App)
+		}
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	prebuilt, err := prebuiltPath(userPath(*path), userPath(*artifact))
+	if err != nil {
+		fail(err)
+	}
+	if *stack != "" {
+		config.Stack = *stack
+	}
+	if *stagingTimeout > 0 {
+		config.StagingTimeout = stagingTimeout.String()
+	}
+	if *startTimeout > 0 {
+		config.StartTimeout = startTimeout.String()
+	}
+	if *bandwidth != "" {
+		config.Upload.Bandwidth = *bandwidth
+	}
+	config.PushArgs = append(config.PushArgs, pushArgs...)
+	if config.Stack != "" {
+		err = checkStack(cliConnection, config.Stack, config.buildpacks())
+		if err != nil {
+			fail(err)
+		}
+	}
+
+	name := config.App
+	if *pr > 0 {
+		name = fmt.Sprintf("%s-pr-%d", config.App, *pr)
+	}
+
+	deployChanges, drifts, err := deployPlan(cliConnection, config, name)
+	if err != nil {
+		fail(err)
+	}
+	if *planOnly && *asJSON {
+		printPlanJSON(deployChanges)
+		return
+	}
+	if len(drifts) == 0 {
+		fmt.Println("The settings match; only the code is redeployed")
+	} else {
+		printPlan(drifts)
+	}
+	if *planOnly {
+		return
+	}
+
+	events.record("deploy", map[string]interface{}{"app": name, "args": args, "plan": deployChanges.Changes})
+
+	err = checkFreeze(cliConnection, *breakGlass)
+	if err != nil {
+		fail(err)
+	}
+
+	if windowErr := checkDeployWindow(config.DeployWindows, time.Now()); windowErr != nil {
+		event := "deploy-window-closed"
+		if *overrideWindow {
+			event = "deploy-window-overridden"
+		}
+		err = recordDeployAudit(cliConnection, event, map[string]interface{}{"app": name, "reason": windowErr.Error()})
+		if err == nil && !*overrideWindow {
+			err = windowErr
+		}
+		if err != nil {
+			fail(err)
+		}
+		fmt.Println("Overriding:", windowErr)
+	}
+
+	if prebuilt != "" {
+		fmt.Printf("Deploying the prebuilt %s, skipping local npm, audit and asset build steps\n", prebuilt)
+	}
+	if config.nodeProject() && prebuilt == "" {
+		if offline {
+			err = requireVendored(nil)
+		}
+		if err == nil {
+			err = checkSocketScaleOut(config, config.Instances)
+		}
+		if err == nil {
+			err = checkNodeVersions(config)
+		}
+		if err == nil {
+			checkDevDependencies(config)
+		}
+		if err != nil {
+			fail(err)
+		}
+		if !skipOffline("the dependency audit") {
+			err = auditDependencies(config.Audit, *force)
+		}
+	}
+	if err != nil {
+		events.record("audit-failed", map[string]interface{}{"error": err.Error()})
+		fail(err)
+	}
+
+	if config.SchemaCheck.enabled() {
+		err = checkSchema(cliConnection, config, name)
+		if err != nil {
+			events.record("schema-check-failed", map[string]interface{}{"error": err.Error()})
+			fail(err)
+		}
+	}
+
+	if *pr == 0 && !*sandbox {
+		err = approveDeploy(config, *ci, *approvalTimeout)
+		if err != nil {
+			events.record("approval-denied", map[string]interface{}{"error": err.Error()})
+			fail(err)
+		}
+	}
+
+	d := newDeployment(cliConnection, config, name)
+	d.readyTimeout = *readyTimeout
+	d.monitorWindow = *monitorWindow
+	d.autoFix = *autoFix
+	d.path = prebuilt
+	d.estimates = stepEstimates(name)
+	if *resume {
+		err = d.resume()
+	} else {
+		err = clearDeployState()
+	}
+	if err != nil {
+		fail(err)
+	}
+	err = d.recordPreviousVersion()
+	if err != nil {
+		fail(err)
+	}
+	stopTrap := d.trapInterrupts()
+	defer stopTrap()
+
+	err = acquireDeployLock(cliConnection, name, *stealLock)
+	if err != nil {
+		events.record("lock", map[string]interface{}{"acquired": false, "error": err.Error()})
+		fail(err)
+	}
+	events.record("lock", map[string]interface{}{"acquired": true})
+	d.deferCleanup("release the deploy lock", func() error {
+		return releaseDeployLock(cliConnection, name)
+	})
+
+	if prebuilt == "" {
+		restoreNpmrc, err := writeNpmrc(config.Npm)
+		if err != nil {
+			d.cleanup()
+			fmt.Println("Could not write .npmrc:", err)
+			exitFailed(errorClass(err))
+		}
+		d.deferCleanup("restore .npmrc", restoreNpmrc)
+	}
+
+	started := time.Now()
+	if *canaryPercent > 0 {
+		err = d.runCanary(canaryOptions{
+			percent:      *canaryPercent,
+			window:       *canaryWindow,
+			maxErrorRate: *canaryMaxErrors,
+		})
+	}
+	if err == nil {
+		err = d.run()
+	}
+	if err == nil && *watchWindow > 0 {
+		if d.appGUID == "" {
+			err = d.recordPreviousVersion()
+		}
+		if err == nil {
+			err = d.watch(watchOptions{window: *watchWindow, maxErrorRate: 5, rollback: *rollback})
+		}
+	}
+	d.cleanup()
+	notifyDeployDone(config, name, time.Since(started), err)
+	historyErr := recordDeploy(name, started, d.durations, err == nil)
+	if historyErr != nil {
+		fmt.Println("Could not update the deploy history:", historyErr)
+	}
+
+	if config.Metrics.enabled() && !skipOffline("pushing metrics") {
+		size, sizeErr := packageSize(d.pushPath())
+		if sizeErr != nil {
+			fmt.Println("Could not measure the package size:", sizeErr)
+		}
+		pushMetrics(config.Metrics, deployMetrics{
+			app:          name,
+			phases:       d.durations,
+			total:        time.Since(started),
+			succeeded:    err == nil,
+			packageBytes: size,
+		})
+	}
+
+	if *pr > 0 && !skipOffline("the pull request comment") {
+		commentErr := commentOnPullRequest(cliConnection, config, *pr, name, err)
+		if commentErr != nil {
+			fmt.Println("Could not update pull request comment:", commentErr)
+		}
+	}
+
+	if err != nil {
+		events.record("deploy-failed", map[string]interface{}{"app": name, "error": err.Error()})
+		events.close()
+		fmt.Println(err)
+		fmt.Println("Run 'cf treeline deploy --resume' to continue from the failed step")
+		exitFailed(errorClass(err))
+	}
+	events.record("deploy-succeeded", map[string]interface{}{"app": name})
+	if *saveDropletTo != "" {
+		_, err = saveDroplet(cliConnection, name, userPath(*saveDropletTo))
+		if err != nil {
+			fmt.Println("Could not save the droplet:", err)
+			exitFailed(errorClass(err))
+		}
+	}
+}
+
+type deployStep struct {
+	name string
+	run  func(d *deployment) error
+}
+
+var deploySteps = []deployStep{
+	{"assets", (*deployment).offloadAssets},
+	{"push", (*deployment).push},
+	{"set-env", (*deployment).setEnv},
+	{"services", (*deployment).services},
+	{"labels", (*deployment).label},
+	{"start", (*deployment).start},
+	{"ready", (*deployment).waitForInstances},
+	{"warmup", (*deployment).warmup},
+	{"workers", (*deployment).deployWorkers},
+	{"network", (*deployment).network},
+	{"monitor", (*deployment).monitor},
+}
+
+type cleanupFunc struct {
+	description string
+	run         func() error
+}
+
+// deployment tracks a deploy in progress so it can be cleaned up and
+// reported on however it ends.
+type deployment struct {
+	cliConnection plugin.CliConnection
+	config        *Config
+	name          string
+
+	readyTimeout  time.Duration
+	monitorWindow time.Duration
+	autoFix       bool
+	noRoute       bool
+	// path is a prebuilt directory or zip to push instead of the current
+	// directory.
+	path string
+
+	appGUID         string
+	previousDroplet string
+	assetsURL       string
+	// canary deployments skip canarySkips and keep no resume state, which
+	// is the main deploy's.
+	canary bool
+	// estimates are the step durations of earlier deploys, for progress.
+	estimates map[string]time.Duration
+
+	mutex     sync.Mutex
+	current   string
+	completed []string
+	durations map[string]time.Duration
+	cleanups  []cleanupFunc
+	cleanedUp bool
+}
+
+func newDeployment(cliConnection plugin.CliConnection, config *Config, name string) *deployment {
+	return &deployment{
+		cliConnection: cliConnection,
+		config:        config,
+		name:          name,
+		durations:     map[string]time.Duration{},
+	}
+}
+
+// resume marks the steps a previous, unfinished deploy of the same app
+// completed so run skips them.
+func (d *deployment) resume() error {
+	state, err := loadDeployState()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		fmt.Println("No unfinished deploy to resume, deploying from scratch")
+		return nil
+	}
+	if state.App != d.name {
+		return fmt.Errorf("the unfinished deploy in %s is for %s, not %s", stateFile, state.App, d.name)
+	}
+	d.completed = state.Completed
+	return nil
+}
+
+func (d *deployment) isCompleted(step string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, completed := range d.completed {
+		if completed == step {
+			return true
+		}
+	}
+	return false
+}
+
+// forApp returns a deployment of another app with the same options.
+func (d *deployment) forApp(name string) *deployment {
+	other := newDeployment(d.cliConnection, d.config, name)
+	other.readyTimeout = d.readyTimeout
+	other.monitorWindow = d.monitorWindow
+	other.autoFix = d.autoFix
+	other.noRoute = d.noRoute
+	other.path = d.path
+	return other
+}
+
+func (d *deployment) pushPath() string {
+	if d.path == "" {
+		return "."
+	}
+	return d.path
+}
+
+// prebuiltPath validates --path and --artifact, returning the one given.
+func prebuiltPath(dir, artifact string) (string, error) {
+	switch {
+	case dir != "" && artifact != "":
+		return "", fmt.Errorf("use either --path or --artifact, not both")
+	case dir != "":
+		info, err := os.Stat(dir)
+		if err != nil {
+			return "", err
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("--path %s is not a directory; use --artifact for a zip", dir)
+		}
+		return dir, nil
+	case artifact != "":
+		info, err := os.Stat(artifact)
+		if err != nil {
+			return "", err
+		}
+		if info.IsDir() || (!strings.HasSuffix(artifact, ".zip") && !strings.HasSuffix(artifact, ".jar")) {
+			return "", fmt.Errorf("--artifact %s is not a zip file", artifact)
+		}
+		return artifact, nil
+	}
+	return "", nil
+}
+
+func (d *deployment) run() error {
+	for i, step := range deploySteps {
+		if d.canary && canarySkips[step.name] {
+			continue
+		}
+		if d.isCompleted(step.name) {
+			fmt.Printf("Skipping %s, completed by the previous deploy\n", step.name)
+			events.record("step-skipped", map[string]interface{}{"step": step.name})
+			continue
+		}
+
+		d.mutex.Lock()
+		d.current = step.name
+		d.mutex.Unlock()
+
+		if progress := d.progress(i); progress != "" {
+			fmt.Printf("Step %s (%s)\n", step.name, progress)
+		}
+		events.record("step-started", map[string]interface{}{"step": step.name})
+		started := time.Now()
+		err := d.runStep(step)
+		duration := time.Since(started)
+
+		d.mutex.Lock()
+		d.durations[step.name] = duration
+		d.mutex.Unlock()
+
+		if err != nil {
+			events.record("step-failed", map[string]interface{}{"step": step.name, "seconds": duration.Seconds(), "error": err.Error()})
+			return fmt.Errorf("%s failed: %v", step.name, err)
+		}
+		events.record("step-completed", map[string]interface{}{"step": step.name, "seconds": duration.Seconds()})
+
+		d.mutex.Lock()
+		d.completed = append(d.completed, step.name)
+		d.current = ""
+		state := &deployState{App: d.name, Completed: d.completed}
+		d.mutex.Unlock()
+
+		if d.canary {
+			continue
+		}
+		err = saveDeployState(state)
+		if err != nil {
+			fmt.Println("Could not save deploy progress:", err)
+		}
+	}
+	if d.canary {
+		return nil
+	}
+	return clearDeployState()
+}
+
+// deferCleanup registers fn to run, most recent first, when the deploy
+// finishes or is interrupted.
+func (d *deployment) deferCleanup(description string, fn func() error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.cleanups = append(d.cleanups, cleanupFunc{description, fn})
+}
+
+func (d *deployment) cleanup() {
+	d.mutex.Lock()
+	if d.cleanedUp {
+		d.mutex.Unlock()
+		return
+	}
+	d.cleanedUp = true
+	cleanups := d.cleanups
+	d.mutex.Unlock()
+
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		err := cleanups[i].run()
+		fields := map[string]interface{}{"action": cleanups[i].description}
+		if err != nil {
+			fields["error"] = err.Error()
+			fmt.Printf("Could not %s: %v\n", cleanups[i].description, err)
+		}
+		events.record("cleanup", fields)
+	}
+}
+
+func (d *deployment) push() error {
+	if d.config.Apt.enabled() {
+		err := writeAptFile(d.config.Apt, d.pushPath())
+		if err != nil {
+			return err
+		}
+	}
+	pushed := d.path
+	if d.config.Upload.enabled() {
+		placeholder, err := placeholderDir()
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(placeholder)
+		pushed = placeholder
+	}
+	app := treelinecf.App{
+		Name:       d.name,
+		Path:       pushed,
+		Memory:     d.config.Memory,
+		Instances:  d.config.Instances,
+		Buildpacks: d.config.buildpacks(),
+		Stack:      d.config.Stack,
+		NoRoute:    d.noRoute,
+
+		HealthCheckTimeout: d.config.healthCheckTimeout(),
+		ExtraArgs:          d.config.PushArgs,
+	}
+	if onKorifi(d.cliConnection) {
+		// Korifi stages with Cloud Native Buildpacks, which have no stacks,
+		// and turns health checks into Kubernetes probes without the cf
+		// timeout; the ready step waits for the instances instead.
+		if app.Stack != "" {
+			fmt.Printf("Ignoring stack %s, which Korifi does not have\n", app.Stack)
+		}
+		app.Stack, app.HealthCheckTimeout = "", 0
+	}
+	err := d.deployer().Push(app)
+	if err == nil && d.config.Upload.enabled() {
+		err = d.uploadBits()
+	}
+	if err != nil {
+		return err
+	}
+	if info, statErr := os.Stat(d.pushPath()); statErr == nil && info.IsDir() {
+		err = saveFileManifest(d.name, d.pushPath())
+		if err != nil {
+			fmt.Println("Could not save the file manifest:", err)
+		}
+	}
+	if d.noRoute {
+		return nil
+	}
+	for _, route := range d.config.Routes {
+		err = checkRoute(d.cliConnection, d.name, route)
+		if err != nil {
+			return err
+		}
+		_, err = d.cliConnection.CliCommand(route.mapArgs(d.name)...)
+		if err != nil {
+			return err
+		}
+		if route.Protocol != "" {
+			err = setRouteProtocol(d.cliConnection, d.name, route)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setEnv also sets the npm registry token for staging. start removes it
+// again, and so does the cleanup if the deploy never gets that far.
+func (d *deployment) setEnv() error {
+	env := d.desiredEnv()
+	if key, token := d.npmToken(); key != "" {
+		env[key] = token
+		d.deferCleanup("remove "+key+" from the app env", d.removeNpmToken)
+	}
+	return d.applyEnv(d.name, env)
+}
+
+// services gives the app its services and labels the instances it created
+// with resourceLabels. Existing and shared instances are not the plugin's,
+// so they are left as they are.
+func (d *deployment) services() error {
+	created, err := createServices(d.cliConnection, d.name, d.config.Services)
+	if err != nil {
+		return err
+	}
+	labels := resourceLabels(d.config)
+	for _, name := range created {
+		err = labelServiceInstance(d.cliConnection, name, labels)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *deployment) start() error {
+	staging, start, err := d.config.timeouts()
+	if err != nil {
+		return err
+	}
+	if staging == 0 && start == 0 {
+		err = d.deployer().Start(d.name)
+	} else {
+		err = startWithTimeouts(d.name, staging, start)
+	}
+	tokenErr := d.removeNpmToken()
+	if err != nil {
+		return d.startFailure(err, staging, start)
+	}
+	return tokenErr
+}
+
+func (d *deployment) deployer() treelinecf.Deployer {
+	return treelinecf.Deployer{Conn: d.cliConnection}
+}
+
+// repeatedFlag collects every value of a flag given more than once.
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *repeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// A failure is a known way for a Node/Sails app to break on Cloud Foundry.
+// advice may refer to submatches of pattern ($1) and to the app as {app}.
+type failure struct {
+	name    string
+	pattern *regexp.Regexp
+	advice  string
+}
+
+var knownFailures = []failure{
+	{
+		"missing module",
+		regexp.MustCompile(`Cannot find module '([^']+)'`),
+		"The module $1 is not installed in the droplet. Add it to \"dependencies\" (not \"devDependencies\") in package.json and redeploy.",
+	},
+	{
+		"port binding",
+		regexp.MustCompile(`EADDRINUSE|listen EACCES|failed to accept connections within the time limit|failed to start accepting connections`),
+		"The app is not listening on the port Cloud Foundry assigns. Sails must use process.env.PORT; run 'cf treeline config-pws' to regenerate config/env/development.js.",
+	},
+	{
+		"out of memory",
+		regexp.MustCompile(`(?i)out of memory|exited with status 137|JavaScript heap out of memory`),
+		"Instances are being killed for exceeding their memory limit. Raise it with 'cf scale {app} -m 1G', or cap Node's heap with --max-old-space-size.",
+	},
+	{
+		"npm install",
+		regexp.MustCompile(`npm ERR! (.+)`),
+		"npm failed during staging ($1). Check that package.json and any shrinkwrap agree and that every dependency is published.",
+	},
+	{
+		"database connection",
+		regexp.MustCompile(`ER_ACCESS_DENIED_ERROR|ECONNREFUSED|ETIMEDOUT .*:3306|Redis connection to .* failed`),
+		"The app could not reach a bound service. Check 'cf services' shows the services bound to {app} and that their credentials are current with 'cf restage {app}'.",
+	},
+	{
+		"asset build",
+		regexp.MustCompile(`Aborted due to warnings|Grunt :: (.+)`),
+		"Grunt failed while building assets on lift. Run 'sails lift' locally to reproduce, or move asset compilation before deploy.",
+	},
+	{
+		"disk quota",
+		regexp.MustCompile(`(?i)no space left on device|exceeds? (the )?disk quota`),
+		"The app ran out of disk. Add node_modules and .tmp to .cfignore, or raise the quota with 'cf scale {app} -k 2G'.",
+	},
+}
+
+type diagnosis struct {
+	failure  failure
+	evidence string
+	advice   string
+}
+
+// diagnoseLines matches lines from logs or events against knownFailures,
+// reporting each kind of failure once.
+func diagnoseLines(name string, lines []string) []diagnosis {
+	var found []diagnosis
+	seen := map[string]bool{}
+	for _, line := range lines {
+		for _, known := range knownFailures {
+			if seen[known.name] {
+				continue
+			}
+			match := known.pattern.FindStringSubmatchIndex(line)
+			if match == nil {
+				continue
+			}
+			advice := string(known.pattern.ExpandString(nil, known.advice, line, match))
+			found = append(found, diagnosis{
+				failure:  known,
+				evidence: strings.TrimSpace(line),
+				advice:   strings.Replace(advice, "{app}", name, -1),
+			})
+			seen[known.name] = true
+		}
+	}
+	return found
+}
+
+type auditEvent struct {
+	Type      string                 `json:"type"`
+	CreatedAt string                 `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+func recentEvents(cliConnection plugin.CliConnection, appGUID string) ([]auditEvent, error) {
+	var page struct {
+		Resources []auditEvent `json:"resources"`
+	}
+	err := cfCurl(cliConnection, "GET", "/v3/audit_events?target_guids="+appGUID+"&order_by=-created_at&per_page=20", nil, &page)
+	return page.Resources, err
+}
+
+// describe flattens an event into one line, including the crash details
+// Cloud Foundry records for process crashes.
+func (e auditEvent) describe() string {
+	description := e.CreatedAt + " " + strings.TrimPrefix(e.Type, "audit.")
+	for _, key := range []string{"index", "reason", "exit_description"} {
+		if value, ok := e.Data[key]; ok && fmt.Sprint(value) != "" {
+			description += fmt.Sprintf(" %s=%v", key, value)
+		}
+	}
+	return description
+}
+
+func recentLogs(cliConnection plugin.CliConnection, name string) ([]string, error) {
+	return cliConnection.CliCommandWithoutTerminalOutput("logs", name, "--recent")
+}
+
+func diagnose(cliConnection plugin.CliConnection, config *Config, args []string) {
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	} else {
+		name = config.App
+	}
+
+	app, err := cliConnection.GetApp(name)
+	if err != nil {
+		fmt.Println("Could not find app", name, err)
+		exitFailed(errorClass(err))
+	}
+	fmt.Printf("%s is %s with %d of %d instances running\n", name, app.State, app.RunningInstances, app.InstanceCount)
+	for i, instance := range app.Instances {
+		fmt.Printf("  #%d %s %s\n", i, instance.State, instance.Details)
+	}
+
+	var evidence []string
+	v3, err := findApp(cliConnection, name)
+	if err == nil && v3 != nil {
+		appEvents, err := recentEvents(cliConnection, v3.GUID)
+		if err != nil {
+			fmt.Println("Could not read app events:", err)
+		}
+		if len(appEvents) > 0 {
+			fmt.Println("\nRecent events:")
+		}
+		for _, event := range appEvents {
+			fmt.Println("  " + event.describe())
+			evidence = append(evidence, event.describe())
+		}
+	}
+
+	logs, err := recentLogs(cliConnection, name)
+	if err != nil {
+		fmt.Println("Could not read recent logs:", err)
+	}
+	evidence = append(evidence, logs...)
+
+	found := diagnoseLines(name, evidence)
+	fmt.Println()
+	if len(found) == 0 {
+		fmt.Printf("No known failure patterns found. Read the full logs with 'cf logs %s --recent'.\n", name)
+		return
+	}
+	for _, problem := range found {
+		fmt.Printf("Problem: %s\n  seen: %s\n  fix:  %s\n\n", problem.failure.name, problem.evidence, problem.advice)
+	}
+}
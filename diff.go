@@ -0,0 +1,231 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// A drift is one difference between .treeline-cf.yml and the live app. fix
+// is the cf command that reconciles it, or nil when only a redeploy can.
+type drift struct {
+	// action is create, update or delete.
+	action string
+	// resource is env, service, binding, route, memory, instances or
+	// buildpack, and name the env var, service or route, if any.
+	resource string
+	name     string
+	detail   string
+	fix      []string
+}
+
+var driftSymbols = map[string]string{"create": "+", "update": "~", "delete": "-"}
+
+// description reads like "+ env NODE_ENV is not set".
+func (d drift) description() string {
+	parts := []string{driftSymbols[d.action], d.resource}
+	if d.name != "" {
+		parts = append(parts, d.name)
+	}
+	return strings.Join(append(parts, d.detail), " ")
+}
+
+func diff(cliConnection plugin.CliConnection, config *Config, args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	apply := flags.Bool("apply", false, "change the live app to match the config")
+	flags.Parse(args)
+
+	drifts, err := configDrift(cliConnection, config)
+	if err != nil {
+		fail(err)
+	}
+	if len(drifts) == 0 {
+		fmt.Printf("%s matches %s\n", config.App, configFile)
+		return
+	}
+
+	for _, d := range drifts {
+		fmt.Println(d.description())
+	}
+	if !*apply {
+		fmt.Println("\nRun 'cf treeline diff --apply' to reconcile")
+		exitFailed("drift")
+	}
+
+	fmt.Println()
+	err = applyDrifts(cliConnection, config.App, drifts)
+	if err != nil {
+		fail(err)
+	}
+}
+
+func configDrift(cliConnection plugin.CliConnection, config *Config) ([]drift, error) {
+	name := config.App
+	if onKorifi(cliConnection) {
+		return nil, fmt.Errorf("comparing %s with the live app needs the v2 API, which Korifi does not serve", name)
+	}
+	app, err := cliConnection.GetApp(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", name, err)
+	}
+	var drifts []drift
+
+	// The uploaded assets' URL is only known once a deploy uploads them, so
+	// the live value stands in for it.
+	d := newDeployment(cliConnection, config, name)
+	if have, ok := app.EnvironmentVars["ASSETS_URL"]; ok && config.Assets.enabled() {
+		d.assetsURL = fmt.Sprint(have)
+	}
+	env := d.desiredEnv()
+	for _, key := range sortedKeys(env) {
+		want := env[key]
+		have, ok := app.EnvironmentVars[key]
+		switch {
+		case !ok:
+			drifts = append(drifts, drift{
+				"create", "env", key, "is not set",
+				[]string{"set-env", name, key, want},
+			})
+		case fmt.Sprint(have) != want:
+			drifts = append(drifts, drift{
+				"update", "env", key, fmt.Sprintf("is %q, want %q", envValue(key, fmt.Sprint(have), false), envValue(key, want, false)),
+				[]string{"set-env", name, key, want},
+			})
+		}
+	}
+
+	// Only variables an earlier deploy set are the config's to remove;
+	// those set by hand or with env push are left alone.
+	live, err := findApp(cliConnection, name)
+	if err != nil {
+		return nil, err
+	}
+	var extra []string
+	if live != nil {
+		for _, key := range strings.Split(live.Metadata.Annotations[managedEnvAnnotation], ",") {
+			if _, wanted := env[key]; key == "" || wanted {
+				continue
+			}
+			if _, ok := app.EnvironmentVars[key]; ok {
+				extra = append(extra, key)
+			}
+		}
+	}
+	sort.Strings(extra)
+	for _, key := range extra {
+		drifts = append(drifts, drift{
+			"delete", "env", key, "was set by a deploy but is no longer declared",
+			[]string{"unset-env", name, key},
+		})
+	}
+
+	bound := map[string]bool{}
+	for _, service := range app.Services {
+		bound[service.Name] = true
+	}
+	existing := map[string]bool{}
+	declared := map[string]bool{}
+	for _, service := range config.Services {
+		declared[service.Name] = true
+		if bound[service.Name] {
+			continue
+		}
+		if len(existing) == 0 {
+			instances, err := cliConnection.GetServices()
+			if err != nil {
+				return nil, err
+			}
+			for _, instance := range instances {
+				existing[instance.Name] = true
+			}
+		}
+		if !existing[service.Name] && service.Service != "" {
+			drifts = append(drifts, drift{
+				"create", "service", service.Name, fmt.Sprintf("(%s %s) does not exist", service.Service, service.Plan),
+				[]string{"create-service", service.Service, service.Plan, service.Name},
+			})
+		}
+		bind, err := service.bindArgs(name)
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, drift{
+			"create", "binding", service.Name, "is missing",
+			bind,
+		})
+	}
+	for _, service := range app.Services {
+		if !declared[service.Name] {
+			drifts = append(drifts, drift{
+				"delete", "binding", service.Name, "is not declared",
+				[]string{"unbind-service", name, service.Name},
+			})
+		}
+	}
+
+	if config.Memory != "" {
+		want, err := megabytes(config.Memory)
+		if err != nil {
+			return nil, err
+		}
+		if want != app.Memory {
+			drifts = append(drifts, drift{
+				"update", "memory", "", fmt.Sprintf("is %dM, want %dM", app.Memory, want),
+				[]string{"scale", name, "-m", fmt.Sprintf("%dM", want), "-f"},
+			})
+		}
+	}
+
+	if config.Instances > 0 && config.Instances != app.InstanceCount {
+		drifts = append(drifts, drift{
+			"update", "instances", "", fmt.Sprintf("is %d, want %d", app.InstanceCount, config.Instances),
+			[]string{"scale", name, "-i", fmt.Sprint(config.Instances)},
+		})
+	}
+
+	mapped := map[string]bool{}
+	for _, route := range app.Routes {
+		mapped[RouteConfig{Hostname: route.Host, Domain: route.Domain.Name, Path: route.Path}.String()] = true
+	}
+	wanted := map[string]bool{}
+	for _, route := range config.Routes {
+		wanted[route.String()] = true
+		if !mapped[route.String()] {
+			drifts = append(drifts, drift{
+				"create", "route", route.String(), "is not mapped",
+				route.mapArgs(name),
+			})
+		}
+	}
+	for _, summary := range app.Routes {
+		route := RouteConfig{Hostname: summary.Host, Domain: summary.Domain.Name, Path: summary.Path}
+		// The default route and internal routes are managed by deploy.
+		if wanted[route.String()] || route.Hostname == name || route.Domain == config.Network.internalDomain() {
+			continue
+		}
+		drifts = append(drifts, drift{
+			"delete", "route", route.String(), "is mapped but not declared",
+			route.unmapArgs(name),
+		})
+	}
+
+	if config.Buildpack != "" && config.Buildpack != app.BuildpackUrl {
+		drifts = append(drifts, drift{
+			"update", "buildpack", "", fmt.Sprintf("is %q, want %q", app.BuildpackUrl, config.Buildpack),
+			nil,
+		})
+	}
+	return drifts, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
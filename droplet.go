@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// dropletInfo is saved next to a downloaded droplet, as FILE.json, since
+// uploading it again needs its process types.
+type dropletInfo struct {
+	App          string            `json:"app"`
+	GUID         string            `json:"guid"`
+	ProcessTypes map[string]string `json:"process_types"`
+	SHA256       string            `json:"sha256"`
+	SavedAt      string            `json:"saved_at"`
+}
+
+type v3Droplet struct {
+	GUID         string            `json:"guid"`
+	State        string            `json:"state"`
+	Error        string            `json:"error"`
+	ProcessTypes map[string]string `json:"process_types"`
+}
+
+func droplet(cliConnection plugin.CliConnection, config *Config, args []string) {
+	if len(args) == 0 || (args[0] != "save" && args[0] != "load") {
+		fmt.Println("Usage: cf treeline droplet save [-o FILE] [APP]")
+		fmt.Println("       cf treeline droplet load FILE [APP]")
+		exitFailed("usage")
+	}
+	flags := flag.NewFlagSet("droplet "+args[0], flag.ExitOnError)
+	output := flags.String("o", "", "write the droplet to `FILE`, APP-GUID.tgz by default")
+	flags.Parse(args[1:])
+
+	name := config.App
+	var err error
+	if args[0] == "save" {
+		if flags.NArg() > 0 {
+			name = flags.Arg(0)
+		}
+		_, err = saveDroplet(cliConnection, name, userPath(*output))
+	} else {
+		if flags.NArg() < 1 {
+			fmt.Println("Usage: cf treeline droplet load FILE [APP]")
+			exitFailed("usage")
+		}
+		if flags.NArg() > 1 {
+			name = flags.Arg(1)
+		}
+		err = loadDroplet(cliConnection, userPath(flags.Arg(0)), name)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+// saveDroplet downloads the droplet the app runs, returning the file name.
+func saveDroplet(cliConnection plugin.CliConnection, name, file string) (string, error) {
+	app, err := findApp(cliConnection, name)
+	if err != nil {
+		return "", err
+	}
+	if app == nil {
+		return "", fmt.Errorf("app %s not found", name)
+	}
+	var current v3Droplet
+	err = cfCurl(cliConnection, "GET", "/v3/apps/"+app.GUID+"/droplets/current", nil, &current)
+	if err != nil {
+		return "", fmt.Errorf("%s has no staged droplet: %v", name, err)
+	}
+	if file == "" {
+		file = fmt.Sprintf("%s-%s.tgz", name, current.GUID[:8])
+	}
+
+	resp, err := capiRequest(cliConnection, "GET", "/v3/droplets/"+current.GUID+"/download", nil, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	out, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return "", fmt.Errorf("downloading droplet: %v", err)
+	}
+
+	info, err := json.MarshalIndent(dropletInfo{
+		App:          name,
+		GUID:         current.GUID,
+		ProcessTypes: current.ProcessTypes,
+		SHA256:       hex.EncodeToString(hash.Sum(nil)),
+		SavedAt:      time.Now().UTC().Format(time.RFC3339),
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(file+".json", info, 0644)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Saved droplet %s of %s to %s (%d bytes)\n", current.GUID, name, file, size)
+	return file, nil
+}
+
+// loadDroplet uploads a saved droplet to the app, creating the app when it
+// does not exist, and restarts it on that droplet.
+func loadDroplet(cliConnection plugin.CliConnection, file, name string) error {
+	data, err := ioutil.ReadFile(file + ".json")
+	if err != nil {
+		return fmt.Errorf("%s.json, written by droplet save, is missing: %v", file, err)
+	}
+	var info dropletInfo
+	err = json.Unmarshal(data, &info)
+	if err != nil {
+		return fmt.Errorf("could not parse %s.json: %v", file, err)
+	}
+	sum, err := fileSHA256(file)
+	if err != nil {
+		return err
+	}
+	if sum != info.SHA256 {
+		return fmt.Errorf("%s does not match the checksum recorded when it was saved", file)
+	}
+
+	app, err := findApp(cliConnection, name)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		app, err = createApp(cliConnection, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	var created v3Droplet
+	err = cfCurl(cliConnection, "POST", "/v3/droplets", map[string]interface{}{
+		"relationships": map[string]interface{}{"app": map[string]interface{}{"data": map[string]string{"guid": app.GUID}}},
+		"process_types": info.ProcessTypes,
+	}, &created)
+	if err != nil {
+		return err
+	}
+
+	bits, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer bits.Close()
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		part, err := form.CreateFormFile("bits", "droplet.tgz")
+		if err == nil {
+			_, err = io.Copy(part, bits)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	resp, err := capiRequest(cliConnection, "POST", "/v3/droplets/"+created.GUID+"/upload", body, form.FormDataContentType())
+	if err != nil {
+		return fmt.Errorf("uploading droplet: %v", err)
+	}
+	resp.Body.Close()
+
+	fmt.Printf("Uploaded %s to %s, waiting for it to be processed\n", file, name)
+	for {
+		var uploaded v3Droplet
+		err = cfCurl(cliConnection, "GET", "/v3/droplets/"+created.GUID, nil, &uploaded)
+		if err != nil {
+			return err
+		}
+		if uploaded.State == "STAGED" {
+			break
+		}
+		if uploaded.State == "FAILED" || uploaded.State == "EXPIRED" {
+			return fmt.Errorf("droplet upload %s: %s", strings.ToLower(uploaded.State), uploaded.Error)
+		}
+		time.Sleep(2 * time.Second)
+	}
+
+	relationship := map[string]interface{}{"data": map[string]string{"guid": created.GUID}}
+	err = cfCurl(cliConnection, "PATCH", "/v3/apps/"+app.GUID+"/relationships/current_droplet", relationship, nil)
+	if err != nil {
+		return err
+	}
+	err = cfCurl(cliConnection, "POST", "/v3/apps/"+app.GUID+"/actions/restart", nil, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is running droplet %s, saved from %s\n", name, created.GUID, info.App)
+	return nil
+}
+
+// createApp creates an empty app in the targeted space.
+func createApp(cliConnection plugin.CliConnection, name string) (*v3App, error) {
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil {
+		return nil, err
+	}
+	app := &v3App{}
+	err = cfCurl(cliConnection, "POST", "/v3/apps", map[string]interface{}{
+		"name":          name,
+		"relationships": map[string]interface{}{"space": map[string]interface{}{"data": map[string]string{"guid": space.Guid}}},
+	}, app)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Created app", name)
+	return app, nil
+}
+
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	return hex.EncodeToString(hash.Sum(nil)), err
+}
+
+// capiRequest calls the Cloud Controller directly, for the binary uploads
+// and downloads cf curl cannot carry.
+func capiRequest(cliConnection plugin.CliConnection, method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	endpoint, err := cliConnection.ApiEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	token, err := cliConnection.AccessToken()
+	if err != nil {
+		return nil, err
+	}
+	skipVerify, _ := cliConnection.IsSSLDisabled()
+
+	req, err := http.NewRequest(method, strings.TrimRight(endpoint, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	// No timeout, as droplets can take minutes to transfer.
+	client := &http.Client{Transport: &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return proxySettings.ProxyFunc()(req.URL)
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: skipVerify},
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s %s", method, path, resp.Status, message)
+	}
+	return resp, nil
+}
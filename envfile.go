@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// envKeyEnv holds the base64 encoded AES-256 key env files are encrypted
+// with. 'cf treeline env keygen' makes one.
+const envKeyEnv = "TREELINE_CF_ENV_KEY"
+
+const envFileHeader = "treeline-cf aes-256-gcm v1\n"
+
+// envFileName is the encrypted env file for an environment. It is safe to
+// commit.
+func envFileName(environment string) string {
+	if environment == "" {
+		return ".treeline-cf.env.enc"
+	}
+	return ".treeline-cf." + environment + ".env.enc"
+}
+
+func envCommand(cliConnection plugin.CliConnection, config *Config, args []string) {
+	if len(args) == 0 || (args[0] != "push" && args[0] != "pull" && args[0] != "keygen" && args[0] != "show") {
+		fmt.Println("Usage: cf treeline env push [--env NAME]")
+		fmt.Println("       cf treeline env pull [--env NAME]")
+		fmt.Println("       cf treeline env show [--env NAME] [--reveal] [APP]")
+		fmt.Println("       cf treeline env keygen")
+		exitFailed("usage")
+	}
+	if args[0] == "keygen" {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Printf("export %s=%s\n", envKeyEnv, base64.StdEncoding.EncodeToString(key))
+		return
+	}
+
+	flags := flag.NewFlagSet("env "+args[0], flag.ExitOnError)
+	environment := flags.String("env", "", "use the environment `NAME` from the config's environments")
+	reveal := flags.Bool("reveal", false, "with show, print secrets instead of hiding them")
+	flags.Parse(args[1:])
+
+	err := config.useEnvironment(*environment)
+	if err == nil && args[0] == "show" {
+		name := config.App
+		if flags.NArg() > 0 {
+			name = flags.Arg(0)
+		}
+		err = showEnv(cliConnection, name, *reveal)
+	} else if err == nil {
+		if args[0] == "push" {
+			err = pushEnvFile(cliConnection, config.App, envFileName(*environment))
+		} else {
+			err = pullEnvFile(cliConnection, config.App, envFileName(*environment))
+		}
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+// pushEnvFile decrypts the env file and sets every variable in it on the app.
+func pushEnvFile(cliConnection plugin.CliConnection, name, file string) error {
+	encrypted, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	plain, err := decryptEnv(encrypted)
+	if err != nil {
+		return fmt.Errorf("could not decrypt %s: %v", file, err)
+	}
+	env, err := parseEnv(plain)
+	if err != nil {
+		return fmt.Errorf("could not parse %s: %v", file, err)
+	}
+	pending := &pendingRestart{}
+	for _, key := range sortedKeys(env) {
+		// Without terminal output, as cf echoes the value it sets.
+		_, err = cliConnection.CliCommandWithoutTerminalOutput("set-env", name, key, env[key])
+		if err != nil {
+			return err
+		}
+		fmt.Println("Set", key)
+		pending.envChanged(key)
+	}
+	if command := pending.command(); command != "" {
+		fmt.Printf("Run 'cf %s %s' for the changes to take effect\n", command, name)
+	}
+	return nil
+}
+
+// pullEnvFile encrypts the app's user provided env into the env file.
+func pullEnvFile(cliConnection plugin.CliConnection, name, file string) error {
+	app, err := cliConnection.GetApp(name)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %v", name, err)
+	}
+	env := map[string]string{}
+	for key, value := range app.EnvironmentVars {
+		env[key] = fmt.Sprint(value)
+	}
+	var plain bytes.Buffer
+	for _, key := range sortedKeys(env) {
+		fmt.Fprintf(&plain, "%s=%s\n", key, strconv.Quote(env[key]))
+	}
+	encrypted, err := encryptEnv(plain.Bytes())
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(file, encrypted, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d variables from %s to %s\n", len(env), name, file)
+	return nil
+}
+
+// parseEnv reads KEY=VALUE lines, skipping blanks and # comments. A value
+// in double quotes is unquoted the way Go does, which is how pull writes
+// them; anything else is taken as it is, except that "\n" stands for a
+// newline.
+func parseEnv(data []byte) (map[string]string, error) {
+	env := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimLeft(scanner.Text(), " \t")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		eq := strings.Index(text, "=")
+		if eq < 1 {
+			return nil, fmt.Errorf("line %d is not KEY=VALUE", line)
+		}
+		key, value := strings.TrimRight(text[:eq], " \t"), text[eq+1:]
+		if strings.HasPrefix(value, `"`) {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d has a badly quoted value", line)
+			}
+			env[key] = unquoted
+			continue
+		}
+		env[key] = strings.Replace(value, `\n`, "\n", -1)
+	}
+	return env, scanner.Err()
+}
+
+func envCipher() (cipher.AEAD, error) {
+	encoded := credential("env-key", envKeyEnv)
+	if encoded == "" {
+		return nil, fmt.Errorf("set %s or run 'cf treeline auth set env-key' with the env file key; 'cf treeline env keygen' makes a new one", envKeyEnv)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s must be 32 bytes, base64 encoded", envKeyEnv)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptEnv(plain []byte) ([]byte, error) {
+	gcm, err := envCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, []byte(envFileHeader))
+	return []byte(envFileHeader + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+func decryptEnv(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(envFileHeader)) {
+		return nil, fmt.Errorf("not a treeline-cf env file")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data[len(envFileHeader):])))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := envCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("file is truncated")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, []byte(envFileHeader))
+	if err != nil {
+		return nil, fmt.Errorf("wrong key or the file was changed")
+	}
+	return plain, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EnvironmentConfig overrides the top-level settings when deploying with
+// --env NAME.
+type EnvironmentConfig struct {
+	App       string `yaml:"app,omitempty"`
+	Memory    string `yaml:"memory,omitempty"`
+	Instances int    `yaml:"instances,omitempty"`
+	// Env is merged over the top-level env.
+	Env map[string]string `yaml:"env,omitempty"`
+	// PruneDevDependencies replaces the top-level setting when set, such
+	// as true for production only.
+	PruneDevDependencies *bool `yaml:"prune_dev_dependencies,omitempty"`
+	// Protected deploys need approval. Environments named prod or
+	// production are always protected.
+	Protected bool `yaml:"protected,omitempty"`
+	// ApprovalURL is polled with --ci until it answers 200 instead of
+	// asking on the terminal.
+	ApprovalURL string `yaml:"approval_url,omitempty"`
+	// DeployWindows replaces the top-level deploy windows when set.
+	DeployWindows *DeployWindowsConfig `yaml:"deploy_windows,omitempty"`
+}
+
+func (e EnvironmentConfig) protected(name string) bool {
+	return e.Protected || name == "prod" || name == "production"
+}
+
+// useEnvironment applies the overrides of the named environment. An empty
+// name leaves the config as it is.
+func (c *Config) useEnvironment(name string) error {
+	if name == "" {
+		return nil
+	}
+	environment, ok := c.Environments[name]
+	if !ok {
+		var known []string
+		for key := range c.Environments {
+			known = append(known, key)
+		}
+		sort.Strings(known)
+		return fmt.Errorf("%s has no environment %q; it defines: %s", configFile, name, strings.Join(known, ", "))
+	}
+	c.Environment = name
+	if environment.App != "" {
+		c.App = environment.App + c.AppSuffix
+	}
+	if environment.Memory != "" {
+		c.Memory = environment.Memory
+	}
+	if environment.Instances > 0 {
+		c.Instances = environment.Instances
+	}
+	if environment.PruneDevDependencies != nil {
+		c.PruneDevDependencies = environment.PruneDevDependencies
+	}
+	if environment.DeployWindows != nil {
+		c.DeployWindows = *environment.DeployWindows
+	}
+	env := map[string]string{}
+	for key, value := range c.Env {
+		env[key] = value
+	}
+	for key, value := range environment.Env {
+		env[key] = value
+	}
+	c.Env = env
+	return nil
+}
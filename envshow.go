@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// secretName matches the names of values env show hides unless --reveal.
+var secretName = regexp.MustCompile(`(?i)pass|secret|token|key|credential|private|cert|auth|salt`)
+
+const redacted = "[redacted]"
+
+// appEnv is the environment the Cloud Controller gives an app.
+type appEnv struct {
+	Variables   map[string]interface{} `json:"environment_variables"`
+	System      map[string]interface{} `json:"system_env_json"`
+	Application map[string]interface{} `json:"application_env_json"`
+}
+
+// showEnv prints the app's env, VCAP_SERVICES and VCAP_APPLICATION as an
+// indented tree, hiding secrets unless reveal is set.
+func showEnv(cliConnection plugin.CliConnection, name string, reveal bool) error {
+	app, err := findApp(cliConnection, name)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("app %s not found", name)
+	}
+	var env appEnv
+	err = cfCurl(cliConnection, "GET", "/v3/apps/"+app.GUID+"/env", nil, &env)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("User-provided")
+	printEnvTree(env.Variables, 1, reveal)
+	fmt.Println("VCAP_SERVICES")
+	services, _ := env.System["VCAP_SERVICES"].(map[string]interface{})
+	for _, label := range sortedInterfaceKeys(services) {
+		instances, _ := services[label].([]interface{})
+		for _, instance := range instances {
+			service, ok := instance.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			details := []string{label}
+			if plan, ok := service["plan"].(string); ok && plan != "" {
+				details = append(details, "plan "+plan)
+			}
+			if binding, ok := service["binding_name"].(string); ok && binding != "" {
+				details = append(details, "binding "+binding)
+			}
+			fmt.Printf("  %v (%s)\n", service["name"], strings.Join(details, ", "))
+			credentials, _ := service["credentials"].(map[string]interface{})
+			printEnvTree(credentials, 2, reveal)
+		}
+	}
+	fmt.Println("VCAP_APPLICATION")
+	application, _ := env.Application["VCAP_APPLICATION"].(map[string]interface{})
+	printEnvTree(application, 1, reveal)
+	if !reveal {
+		fmt.Println("\nSecrets are hidden; add --reveal to show them.")
+	}
+	return nil
+}
+
+func printEnvTree(values map[string]interface{}, depth int, reveal bool) {
+	indent := strings.Repeat("  ", depth)
+	if len(values) == 0 {
+		fmt.Println(indent + "(none)")
+		return
+	}
+	for _, key := range sortedInterfaceKeys(values) {
+		switch value := values[key].(type) {
+		case map[string]interface{}:
+			if !reveal && secretName.MatchString(key) {
+				fmt.Printf("%s%s: %s\n", indent, key, redacted)
+				continue
+			}
+			fmt.Printf("%s%s:\n", indent, key)
+			printEnvTree(value, depth+1, reveal)
+		case []interface{}:
+			var items []string
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			fmt.Printf("%s%s: [%s]\n", indent, key, strings.Join(items, ", "))
+		default:
+			fmt.Printf("%s%s: %s\n", indent, key, envValue(key, fmt.Sprint(value), reveal))
+		}
+	}
+}
+
+// envValue hides values with secret sounding names and the passwords in
+// URLs, such as database URIs.
+func envValue(key, value string, reveal bool) string {
+	if reveal {
+		return value
+	}
+	if secretName.MatchString(key) && value != "" {
+		return redacted
+	}
+	if parsed, err := url.Parse(value); err == nil && parsed.User != nil {
+		if _, ok := parsed.User.Password(); ok {
+			parsed.User = url.UserPassword(parsed.User.Username(), "xxxxx")
+			return strings.Replace(parsed.String(), "xxxxx", redacted, 1)
+		}
+	}
+	return value
+}
+
+func sortedInterfaceKeys(values map[string]interface{}) []string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
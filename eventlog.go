@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// eventLog writes one JSON object per line for every action the plugin takes.
+// It does nothing until opened, so callers can record unconditionally.
+type eventLog struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+var events = &eventLog{}
+
+func (l *eventLog) open(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.file = file
+	l.encoder = json.NewEncoder(file)
+	return nil
+}
+
+func (l *eventLog) record(event string, fields map[string]interface{}) {
+	if failureEvent(event) {
+		sendTelemetry(event)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.encoder == nil {
+		return
+	}
+	entry := map[string]interface{}{}
+	for key, value := range fields {
+		entry[key] = value
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["event"] = event
+	l.encoder.Encode(entry)
+}
+
+func (l *eventLog) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+		l.encoder = nil
+	}
+}
+
+func deployAuditFile() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".treeline-cf", "deploy-audit.log")
+}
+
+// recordDeployAudit logs a deploy stopped by, or let through despite, a
+// freeze or closed deploy window. Besides the event log, which is only
+// written with --log-file, it always appends the event, with who deployed
+// where, to ~/.treeline-cf/deploy-audit.log, and fails when it cannot so no
+// override goes unrecorded.
+func recordDeployAudit(cliConnection plugin.CliConnection, event string, fields map[string]interface{}) error {
+	entry := map[string]interface{}{}
+	for key, value := range fields {
+		entry[key] = value
+	}
+	entry["user"], _ = cliConnection.Username()
+	entry["api"], _ = cliConnection.ApiEndpoint()
+	if space, err := cliConnection.GetCurrentSpace(); err == nil {
+		entry["space"] = space.Name
+	}
+	events.record(event, entry)
+
+	entry["time"] = time.Now().UTC().Format(time.RFC3339)
+	entry["event"] = event
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(deployAuditFile()), 0700)
+	if err != nil {
+		return fmt.Errorf("could not write the deploy audit log: %v", err)
+	}
+	file, err := os.OpenFile(deployAuditFile(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("could not write the deploy audit log: %v", err)
+	}
+	defer file.Close()
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("could not write the deploy audit log: %v", err)
+	}
+	return nil
+}
+
+// loggedConnection records every cf command run through it, with its output,
+// in the event log. Output that holds env var values or service credentials
+// is left out.
+type loggedConnection struct {
+	plugin.CliConnection
+}
+
+func (c loggedConnection) CliCommand(args ...string) ([]string, error) {
+	output, err := c.CliConnection.CliCommand(args...)
+	recordCommand(args, output, err)
+	return output, err
+}
+
+func (c loggedConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	output, err := c.CliConnection.CliCommandWithoutTerminalOutput(args...)
+	recordCommand(args, output, err)
+	return output, err
+}
+
+func recordCommand(args, output []string, err error) {
+	fields := map[string]interface{}{"args": redactArgs(args)}
+	if !secretOutput(args) {
+		fields["output"] = output
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	events.record("cf", fields)
+}
+
+// redactArgs hides env var values, service credentials and cf curl request
+// bodies, which are often secrets, from the log.
+func redactArgs(args []string) []string {
+	redacted := append([]string(nil), args...)
+	if len(redacted) > 3 && redacted[0] == "set-env" {
+		redacted[3] = "[redacted]"
+	}
+	for i := 1; i < len(redacted); i++ {
+		switch {
+		case redacted[i-1] == "-p" && userProvidedServiceCommand(redacted[0]):
+			redacted[i] = "[redacted]"
+		case redacted[i-1] == "-c" && serviceParametersCommand(redacted[0]):
+			redacted[i] = "[redacted]"
+		case redacted[i-1] == "-d" && redacted[0] == "curl":
+			redacted[i] = "[redacted]"
+		}
+	}
+	return redacted
+}
+
+func userProvidedServiceCommand(command string) bool {
+	switch command {
+	case "create-user-provided-service", "cups", "update-user-provided-service", "uups":
+		return true
+	}
+	return false
+}
+
+// serviceParametersCommand reports whether -c of a command carries service
+// parameters, which often hold credentials.
+func serviceParametersCommand(command string) bool {
+	switch command {
+	case "bind-service", "bs", "create-service", "cs", "update-service",
+		"create-service-key", "csk", "bind-route-service", "brs":
+		return true
+	}
+	return false
+}
+
+// secretOutput reports whether a command prints an app's environment, a
+// service instance's credentials or a service key's details.
+func secretOutput(args []string) bool {
+	if len(args) < 2 || args[0] != "curl" {
+		return false
+	}
+	path := strings.SplitN(args[1], "?", 2)[0]
+	for _, suffix := range []string{"/env", "/environment_variables", "/credentials", "/details"} {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
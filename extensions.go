@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// extensionPrefix names executables that add commands: treeline-cf-report
+// on PATH or in the extensions directory runs as 'cf treeline report'.
+const extensionPrefix = "treeline-cf-"
+
+func extensionDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".treeline-cf", "plugins")
+}
+
+// findExtension returns the executable for the command, preferring the
+// extensions directory over PATH, or "".
+func findExtension(name string) string {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return ""
+	}
+	file := extensionPrefix + name
+	if runtime.GOOS == "windows" {
+		file += ".exe"
+	}
+	if info, err := os.Stat(filepath.Join(extensionDir(), file)); err == nil && !info.IsDir() {
+		return filepath.Join(extensionDir(), file)
+	}
+	path, err := exec.LookPath(extensionPrefix + name)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// extensions lists the command names of the installed extensions.
+func extensions() []string {
+	found := map[string]bool{}
+	dirs := append([]string{extensionDir()}, filepath.SplitList(os.Getenv("PATH"))...)
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			name := strings.TrimSuffix(file.Name(), ".exe")
+			if strings.HasPrefix(name, extensionPrefix) && !file.IsDir() {
+				found[strings.TrimPrefix(name, extensionPrefix)] = true
+			}
+		}
+	}
+	var names []string
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runExtension runs the extension with the plugin's context in its
+// environment: the resolved config as JSON, the app, and the cf target and
+// token, so it does not have to work them out again.
+func runExtension(cliConnection plugin.CliConnection, config *Config, path string, args []string) error {
+	env := []string{}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	abs, _ := filepath.Abs(configFile)
+	env = append(env, "TREELINE_CF_CONFIG="+abs, "TREELINE_CF_CONFIG_JSON="+string(data), "TREELINE_CF_APP="+config.App)
+	if api, err := cliConnection.ApiEndpoint(); err == nil {
+		env = append(env, "TREELINE_CF_API="+api)
+	}
+	if org, err := cliConnection.GetCurrentOrg(); err == nil {
+		env = append(env, "TREELINE_CF_ORG="+org.Name)
+	}
+	if space, err := cliConnection.GetCurrentSpace(); err == nil {
+		env = append(env, "TREELINE_CF_SPACE="+space.Name, "TREELINE_CF_SPACE_GUID="+space.Guid)
+	}
+	if token, err := cliConnection.AccessToken(); err == nil {
+		env = append(env, "TREELINE_CF_TOKEN="+token)
+	}
+	if offline {
+		env = append(env, "TREELINE_CF_OFFLINE=1")
+	}
+
+	cmd := command(path, args...)
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func extensionsCommand() {
+	names := extensions()
+	if len(names) == 0 {
+		fmt.Printf("No extensions; add executables named %sCOMMAND to PATH or %s\n", extensionPrefix, extensionDir())
+		return
+	}
+	for _, name := range names {
+		fmt.Printf("%-16s %s\n", name, findExtension(name))
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// FailoverConfig lists the production routes failover moves between
+// targets. They default to the top-level routes.
+type FailoverConfig struct {
+	Routes []RouteConfig `yaml:"routes,omitempty"`
+}
+
+// failover moves the production routes from the app on one target to the
+// app on another, for when a region is down. The secondary's routes are
+// mapped first so traffic has somewhere to go; unmapping from the primary
+// is best effort, since it may be unreachable.
+func failover(config *Config, args []string) {
+	flags := flag.NewFlagSet("failover", flag.ExitOnError)
+	to := flags.String("to", "", "the target `NAME` to move the production routes to")
+	from := flags.String("from", "", "the target `NAME` to move them from, the config's primary by default")
+	yes := flags.Bool("yes", false, "fail over without asking")
+	flags.Parse(args)
+
+	if *to == "" {
+		fmt.Println("Usage: cf treeline failover --to TARGET [--from TARGET] [--yes]")
+		exitFailed("usage")
+	}
+	if *from == "" {
+		*from = config.primaryTarget(*to)
+	}
+	secondary, ok := config.Targets[*to]
+	if !ok || *from == "" || *from == *to {
+		fmt.Printf("Set the targets to fail over between with --to and --from; %s defines: %s\n", configFile, strings.Join(config.targetNames(), ", "))
+		exitFailed("config")
+	}
+	primary, ok := config.Targets[*from]
+	if !ok {
+		fmt.Printf("%s has no target %q\n", configFile, *from)
+		exitFailed("config")
+	}
+	routes := config.Failover.Routes
+	if len(routes) == 0 {
+		routes = config.Routes
+	}
+	if len(routes) == 0 {
+		fmt.Printf("No production routes to fail over; set failover.routes or routes in %s\n", configFile)
+		exitFailed("config")
+	}
+
+	fmt.Printf("Moving these routes of %s from %s to %s:\n", config.App, *from, *to)
+	for _, route := range routes {
+		fmt.Println("  " + route.String())
+	}
+	if !*yes {
+		fmt.Print("Type the app name to fail over: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != config.App {
+			fmt.Println("Not failing over")
+			exitFailed("declined")
+		}
+	}
+	events.record("failover", map[string]interface{}{"from": *from, "to": *to})
+
+	err := secondary.login(*to)
+	if err != nil {
+		fail(err)
+	}
+	for _, route := range routes {
+		err = secondary.cf(*to, route.mapArgs(config.App)...).Run()
+		if err != nil {
+			fmt.Printf("Could not map %s on %s: %v\n", route, *to, err)
+			exitFailed(errorClass(err))
+		}
+	}
+	fmt.Printf("%s now serves the production routes on %s\n", config.App, *to)
+
+	err = primary.login(*from)
+	for _, route := range routes {
+		if err != nil {
+			break
+		}
+		err = primary.cf(*from, route.unmapArgs(config.App)...).Run()
+	}
+	if err != nil {
+		events.record("failover-unmap-failed", map[string]interface{}{"target": *from, "error": err.Error()})
+		fmt.Printf("Could not unmap the routes on %s, unmap them when it recovers: %v\n", *from, err)
+	}
+	fmt.Printf("Point DNS for the routes at %s's router if it does not already resolve there\n", *to)
+}
+
+// primaryTarget is the target marked primary, or the only other one.
+func (c *Config) primaryTarget(secondary string) string {
+	var others []string
+	for _, name := range c.targetNames() {
+		if c.Targets[name].Primary && name != secondary {
+			return name
+		}
+		if name != secondary {
+			others = append(others, name)
+		}
+	}
+	if len(others) == 1 {
+		return others[0]
+	}
+	return ""
+}
+
+func (c *Config) targetNames() []string {
+	var names []string
+	for name := range c.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
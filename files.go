@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// cf push never uploads these, whatever .cfignore says.
+var defaultIgnored = []string{".cfignore", "_darcs", ".DS_Store", ".git", ".gitignore", ".hg", "manifest.yml", ".svn"}
+
+// ignorePattern is one glob of a .cfignore line. Later lines win, so a
+// ! line can upload what an earlier line ignores.
+type ignorePattern struct {
+	glob    *regexp.Regexp
+	rooted  bool
+	exclude bool
+}
+
+// walkAppFiles calls fn for every regular file under root that cf push would
+// upload, with paths relative to root and slash separated.
+func walkAppFiles(root string, fn func(path string, info os.FileInfo) error) error {
+	patterns := readIgnorePatterns(filepath.Join(root, ".cfignore"))
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			// cf push decides file by file, so a directory can only be
+			// skipped when no ! line could bring a file in it back.
+			if isIgnored(rel, patterns) && !negates(patterns) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || isIgnored(rel, patterns) {
+			return nil
+		}
+		return fn(rel, info)
+	})
+}
+
+// readIgnorePatterns reads .cfignore the way cf push does: every line also
+// matches what is below it, lines without a leading / match at any depth,
+// ** crosses directories and ! uploads what an earlier line ignores.
+func readIgnorePatterns(file string) []ignorePattern {
+	lines := append([]string{}, defaultIgnored...)
+	data, err := ioutil.ReadFile(file)
+	if err == nil {
+		lines = append(lines, strings.Split(string(data), "\n")...)
+	}
+	var patterns []ignorePattern
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		exclude := true
+		if strings.HasPrefix(line, "!") {
+			exclude = false
+			line = line[1:]
+		}
+		line = path.Clean(line)
+		globs := []string{line, path.Join(line, "*"), path.Join(line, "**", "*")}
+		if !strings.HasPrefix(line, "/") {
+			globs = append(globs, path.Join("**", line), path.Join("**", line, "*"), path.Join("**", line, "**", "*"))
+		}
+		for _, glob := range globs {
+			patterns = append(patterns, ignorePattern{glob: compileGlob(glob), rooted: strings.HasPrefix(glob, "/"), exclude: exclude})
+		}
+	}
+	return patterns
+}
+
+// compileGlob turns a .cfignore glob into a regexp: ** matches across
+// directories, * and ? within one, and [...] is a character class.
+func compileGlob(glob string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			// **/ also matches no directory at all.
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[' && strings.IndexByte(glob[i:], ']') > 1:
+			end := i + strings.IndexByte(glob[i:], ']')
+			class := glob[i+1 : end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	compiled, err := regexp.Compile(expr.String())
+	if err != nil {
+		// A class regexp does not accept, such as [z-a], matches nothing.
+		return regexp.MustCompile(`^\b\B$`)
+	}
+	return compiled
+}
+
+func isIgnored(rel string, patterns []ignorePattern) bool {
+	ignored := false
+	for _, pattern := range patterns {
+		target := rel
+		if pattern.rooted {
+			target = "/" + rel
+		}
+		if pattern.glob.MatchString(target) {
+			ignored = pattern.exclude
+		}
+	}
+	return ignored
+}
+
+func negates(patterns []ignorePattern) bool {
+	for _, pattern := range patterns {
+		if !pattern.exclude {
+			return true
+		}
+	}
+	return false
+}
+
+// packageSize is the total size of the files cf push would upload from root,
+// which may also be a zip.
+func packageSize(root string) (int64, error) {
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		return info.Size(), nil
+	}
+	var size int64
+	err := walkAppFiles(root, func(path string, info os.FileInfo) error {
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
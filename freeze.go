@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// The freeze is kept in annotations on the space, so it applies to everyone
+// deploying there. Changing it needs the space manager role.
+const (
+	freezeReason = "treeline-cli/freeze-reason"
+	frozenBy     = "treeline-cli/frozen-by"
+	frozenAt     = "treeline-cli/frozen-at"
+)
+
+type spaceFreeze struct {
+	reason string
+	by     string
+	at     string
+}
+
+func freeze(cliConnection plugin.CliConnection, args []string) {
+	if len(args) == 0 || (args[0] != "on" && args[0] != "off" && args[0] != "status") {
+		fmt.Println("Usage: cf treeline freeze on --reason REASON")
+		fmt.Println("       cf treeline freeze off|status")
+		exitFailed("usage")
+	}
+	flags := flag.NewFlagSet("freeze "+args[0], flag.ExitOnError)
+	reason := flags.String("reason", "", "why deploys are frozen, shown to anyone who tries")
+	flags.Parse(args[1:])
+
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil {
+		fail(err)
+	}
+	switch args[0] {
+	case "on":
+		if *reason == "" {
+			fmt.Println("Say why with --reason")
+			exitFailed("usage")
+		}
+		user, _ := cliConnection.Username()
+		err = setFreeze(cliConnection, space.Guid, map[string]interface{}{
+			freezeReason: *reason,
+			frozenBy:     user,
+			frozenAt:     time.Now().UTC().Format(time.RFC3339),
+		})
+		if err == nil {
+			fmt.Printf("Deploys to %s are frozen: %s\n", space.Name, *reason)
+		}
+	case "off":
+		err = setFreeze(cliConnection, space.Guid, map[string]interface{}{freezeReason: nil, frozenBy: nil, frozenAt: nil})
+		if err == nil {
+			fmt.Printf("Deploys to %s are unfrozen\n", space.Name)
+		}
+	case "status":
+		var current *spaceFreeze
+		current, err = currentFreeze(cliConnection)
+		if err == nil && current == nil {
+			fmt.Printf("Deploys to %s are not frozen\n", space.Name)
+		}
+		if current != nil {
+			fmt.Printf("Deploys to %s are frozen by %s since %s: %s\n", space.Name, current.by, current.at, current.reason)
+		}
+	}
+	if err != nil {
+		fail(err)
+	}
+	events.record("freeze", map[string]interface{}{"action": args[0], "space": space.Name, "reason": *reason})
+}
+
+func setFreeze(cliConnection plugin.CliConnection, spaceGUID string, annotations map[string]interface{}) error {
+	body := map[string]interface{}{"metadata": map[string]interface{}{"annotations": annotations}}
+	err := cfCurl(cliConnection, "PATCH", "/v3/spaces/"+spaceGUID, body, nil)
+	if err != nil {
+		return fmt.Errorf("could not update the space, which needs the space manager role: %v", err)
+	}
+	return nil
+}
+
+// currentFreeze returns the freeze on the targeted space, or nil.
+func currentFreeze(cliConnection plugin.CliConnection) (*spaceFreeze, error) {
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil {
+		return nil, err
+	}
+	var details struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	err = cfCurl(cliConnection, "GET", "/v3/spaces/"+space.Guid, nil, &details)
+	if err != nil {
+		return nil, err
+	}
+	annotations := details.Metadata.Annotations
+	if annotations[freezeReason] == "" {
+		return nil, nil
+	}
+	return &spaceFreeze{annotations[freezeReason], annotations[frozenBy], annotations[frozenAt]}, nil
+}
+
+// checkFreeze refuses to deploy to a frozen space unless breakGlass is set,
+// which is recorded with recordDeployAudit.
+func checkFreeze(cliConnection plugin.CliConnection, breakGlass bool) error {
+	current, err := currentFreeze(cliConnection)
+	if err != nil || current == nil {
+		return err
+	}
+	if !breakGlass {
+		err = recordDeployAudit(cliConnection, "deploy-frozen", map[string]interface{}{"reason": current.reason, "frozen_by": current.by, "frozen_at": current.at})
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("deploys are frozen by %s since %s: %s\nRerun with --break-glass if this deploy must go out anyway", current.by, current.at, current.reason)
+	}
+	user, _ := cliConnection.Username()
+	err = recordDeployAudit(cliConnection, "freeze-broken", map[string]interface{}{"reason": current.reason, "frozen_by": current.by, "frozen_at": current.at})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Breaking the deploy freeze (%s) as %s\n", current.reason, user)
+	return nil
+}
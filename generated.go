@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/SocalNick/cf-treeline-cli/treelinecf"
+)
+
+// generatedMode is the permission of the files the plugin generates in the
+// project, 0644 unless the config sets a umask.
+var generatedMode os.FileMode = 0644
+
+// useUmask applies an octal umask such as 027 to generated files.
+func useUmask(umask string) error {
+	if umask == "" {
+		return nil
+	}
+	mask, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || mask > 0777 {
+		return fmt.Errorf("umask %q in %s is not an octal mask such as 022", umask, configFile)
+	}
+	generatedMode = 0666 &^ os.FileMode(mask)
+	return nil
+}
+
+// writeGenerated writes a generated config file atomically, creating its
+// directory when needed. JavaScript goes in a managed block, so what the
+// user adds around it is kept, and is syntax checked once written.
+func writeGenerated(file string, data []byte) error {
+	if filepath.Ext(file) != ".js" {
+		return treelinecf.WriteFile(file, data, generatedMode)
+	}
+	noteUnmanaged(file)
+	err := treelinecf.WriteManaged(file, data, generatedMode)
+	if err == nil {
+		err = checkJavaScript(file)
+	}
+	return err
+}
+
+// noteUnmanaged says where the settings of a file from before managed
+// blocks go when it is replaced.
+func noteUnmanaged(file string) {
+	if treelinecf.Unmanaged(file) {
+		fmt.Printf("%s has no managed block yet, so it is kept as %s.orig; copy any settings you added below the block\n", file, file)
+	}
+}
+
+// nodeMissing is set once node turned out not to be installed, so the check
+// is only skipped with a note once.
+var nodeMissing bool
+
+// checkJavaScript runs node --check on generated files, so a template
+// mistake fails here rather than when the app starts after staging.
+func checkJavaScript(files ...string) error {
+	if nodeMissing {
+		return nil
+	}
+	for _, file := range files {
+		out, err := command("node", "--check", file).CombinedOutput()
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			fmt.Println("node is not installed, so the generated JavaScript was not checked")
+			nodeMissing = true
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("generated %s is not valid JavaScript, please report this:\n%s", file, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
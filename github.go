@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// reviewAppMarker identifies the comment this plugin owns on a pull request,
+// so redeploys update it instead of adding another one.
+const reviewAppMarker = "<!-- treeline-cf review app -->"
+
+// githubNextLink picks the next page's URL out of a Link header.
+var githubNextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+var githubRemote = regexp.MustCompile(`github\.com[:/]([^/]+/[^/]+?)(\.git)?$`)
+
+type githubComment struct {
+	ID   int    `json:"id,omitempty"`
+	Body string `json:"body"`
+}
+
+func commentOnPullRequest(cliConnection plugin.CliConnection, config *Config, pr int, name string, deployErr error) error {
+	token := config.GitHub.Token
+	if token == "" {
+		token = credential("github", "GITHUB_TOKEN")
+	}
+	if token == "" {
+		return nil
+	}
+	repo, err := githubRepo(config)
+	if err != nil {
+		return err
+	}
+	api := strings.TrimRight(config.GitHub.API, "/")
+	if api == "" {
+		api = "https://api.github.com"
+	}
+
+	body := reviewAppComment(cliConnection, name, deployErr)
+
+	// Busy pull requests have more than a page of comments.
+	url := fmt.Sprintf("%s/repos/%s/issues/%d/comments?per_page=100", api, repo, pr)
+	for url != "" {
+		var comments []githubComment
+		url, err = githubPage(token, url, &comments)
+		if err != nil {
+			return err
+		}
+		for _, comment := range comments {
+			if strings.HasPrefix(comment.Body, reviewAppMarker) {
+				url = fmt.Sprintf("%s/repos/%s/issues/comments/%d", api, repo, comment.ID)
+				return githubRequest(token, "PATCH", url, githubComment{Body: body}, nil)
+			}
+		}
+	}
+	url = fmt.Sprintf("%s/repos/%s/issues/%d/comments", api, repo, pr)
+	return githubRequest(token, "POST", url, githubComment{Body: body}, nil)
+}
+
+func reviewAppComment(cliConnection plugin.CliConnection, name string, deployErr error) string {
+	var body bytes.Buffer
+	fmt.Fprintln(&body, reviewAppMarker)
+	fmt.Fprintln(&body, "### Review app")
+	fmt.Fprintln(&body)
+	if deployErr != nil {
+		fmt.Fprintf(&body, "**Status:** :x: deploy failed at %s\n\n", time.Now().UTC().Format(time.RFC1123))
+		fmt.Fprintf(&body, "```\n%v\n```\n", deployErr)
+	} else {
+		fmt.Fprintf(&body, "**Status:** :white_check_mark: deployed at %s\n\n", time.Now().UTC().Format(time.RFC1123))
+	}
+	fmt.Fprintf(&body, "**App:** `%s`\n", name)
+	if url := appURL(cliConnection, name); url != "" {
+		fmt.Fprintf(&body, "**URL:** %s\n", url)
+	}
+	fmt.Fprintln(&body)
+	fmt.Fprintln(&body, "To tear down the review app once this pull request is closed:")
+	fmt.Fprintf(&body, "```\ncf delete %s -r -f\n```\n", name)
+	return body.String()
+}
+
+func appURL(cliConnection plugin.CliConnection, name string) string {
+	app, err := cliConnection.GetApp(name)
+	if err != nil || len(app.Routes) == 0 {
+		return ""
+	}
+	route := app.Routes[0]
+	if route.Host == "" {
+		return "https://" + route.Domain.Name + route.Path
+	}
+	return "https://" + route.Host + "." + route.Domain.Name + route.Path
+}
+
+func githubRepo(config *Config) (string, error) {
+	if config.GitHub.Repo != "" {
+		return config.GitHub.Repo, nil
+	}
+	out, err := command("git", "config", "--get", "remote.origin.url").Output()
+	if err != nil {
+		return "", fmt.Errorf("set github.repo in %s: could not read the origin remote: %v", configFile, err)
+	}
+	match := githubRemote.FindStringSubmatch(strings.TrimSpace(string(out)))
+	if match == nil {
+		return "", fmt.Errorf("set github.repo in %s: origin is not a GitHub remote", configFile)
+	}
+	return match[1], nil
+}
+
+func githubRequest(token, method, url string, in, out interface{}) error {
+	_, err := githubDo(token, method, url, in, out)
+	return err
+}
+
+// githubPage gets one page of a list and returns the URL of the next, empty
+// on the last page.
+func githubPage(token, url string, out interface{}) (string, error) {
+	resp, err := githubDo(token, "GET", url, nil, out)
+	if err != nil {
+		return "", err
+	}
+	if next := githubNextLink.FindStringSubmatch(resp.Header.Get("Link")); next != nil {
+		return next[1], nil
+	}
+	return "", nil
+}
+
+func githubDo(token, method, url string, in, out interface{}) (*http.Response, error) {
+	var body bytes.Buffer
+	if in != nil {
+		err := json.NewEncoder(&body).Encode(in)
+		if err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, url, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "token "+token)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	if out == nil {
+		return resp, nil
+	}
+	return resp, json.NewDecoder(resp.Body).Decode(out)
+}
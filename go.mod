@@ -0,0 +1,16 @@
+module github.com/SocalNick/cf-treeline-cli
+
+go 1.18
+
+require (
+	code.cloudfoundry.org/cli v6.53.0+incompatible
+	github.com/Masterminds/semver/v3 v3.2.1
+	golang.org/x/net v0.30.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/text v0.19.0 // indirect
+
+// The cf CLI's packages import each other by the code.cloudfoundry.org
+// vanity path, which serves the same repository.
+replace code.cloudfoundry.org/cli => github.com/cloudfoundry/cli v6.53.0+incompatible
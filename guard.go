@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+type guardOptions struct {
+	interval time.Duration
+	grace    time.Duration
+	webhook  string
+	restart  bool
+}
+
+func guard(cliConnection plugin.CliConnection, config *Config, args []string) {
+	flags := flag.NewFlagSet("guard", flag.ExitOnError)
+	interval := flags.Duration("interval", 30*time.Second, "how often to check the app's instances")
+	grace := flags.Duration("grace", 2*time.Minute, "how long the platform has to recover a crashed instance itself")
+	webhook := flags.String("webhook", "", "post a JSON message to `URL` when an instance stays down")
+	noRestart := flags.Bool("no-restart", false, "only notify the webhook, without restarting instances")
+	flags.Parse(args)
+
+	name := config.App
+	if flags.NArg() > 0 {
+		name = flags.Arg(0)
+	}
+	err := guardApp(cliConnection, name, guardOptions{*interval, *grace, *webhook, !*noRestart})
+	if err != nil {
+		fail(err)
+	}
+}
+
+// guardApp checks the app's instances until interrupted. An instance the
+// platform has not brought back within the grace period is restarted, and
+// reported to the webhook, once per grace period until it recovers.
+func guardApp(cliConnection plugin.CliConnection, name string, options guardOptions) error {
+	fmt.Printf("Guarding %s, checking every %v; press Ctrl-C to stop\n", name, options.interval)
+	// down holds when each instance was first seen down, and handled when it
+	// was last restarted or reported.
+	down := map[int]time.Time{}
+	handled := map[int]time.Time{}
+	for {
+		app, err := cliConnection.GetApp(name)
+		if err != nil {
+			fmt.Println("Could not read the app's instances:", err)
+			time.Sleep(options.interval)
+			continue
+		}
+		if !strings.EqualFold(app.State, "started") {
+			down = map[int]time.Time{}
+			time.Sleep(options.interval)
+			continue
+		}
+		now := time.Now()
+		for i, instance := range app.Instances {
+			if instance.State == "RUNNING" || instance.State == "STARTING" {
+				if _, ok := down[i]; ok {
+					fmt.Printf("Instance #%d of %s recovered\n", i, name)
+					delete(down, i)
+					delete(handled, i)
+				}
+				continue
+			}
+			if _, ok := down[i]; !ok {
+				fmt.Printf("Instance #%d of %s is %s\n", i, name, instance.State)
+				down[i] = now
+			}
+			if now.Sub(down[i]) < options.grace || now.Sub(handled[i]) < options.grace {
+				continue
+			}
+			handled[i] = now
+			action := "reported"
+			if options.restart {
+				action = "restarted"
+				_, err = cliConnection.CliCommand("restart-app-instance", name, fmt.Sprint(i))
+				if err != nil {
+					action = "restart failed: " + err.Error()
+				}
+			}
+			message := fmt.Sprintf("Instance #%d of %s has been %s for %s; %s", i, name, instance.State, now.Sub(down[i]).Round(time.Second), action)
+			fmt.Println(message)
+			if options.webhook != "" {
+				err = postWebhook(options.webhook, map[string]interface{}{
+					"text":     message,
+					"app":      name,
+					"instance": i,
+					"state":    instance.State,
+					"action":   action,
+				})
+				if err != nil {
+					fmt.Println("Could not notify the webhook:", err)
+				}
+			}
+		}
+		time.Sleep(options.interval)
+	}
+}
+
+// postWebhook posts message as JSON. The text field makes it readable as a
+// Slack or Teams incoming webhook message.
+func postWebhook(url string, message map[string]interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("POST %s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var historyFile = filepath.Join(stateDir, "history.json")
+
+// historyLimit is how many deploys the history keeps, and historySample
+// how many recent successful ones the estimates average.
+const (
+	historyLimit  = 50
+	historySample = 10
+)
+
+// deployRecord is one deploy in the history, with how long each step took.
+type deployRecord struct {
+	App       string             `json:"app"`
+	StartedAt time.Time          `json:"started_at"`
+	Succeeded bool               `json:"succeeded"`
+	Seconds   float64            `json:"seconds"`
+	Steps     map[string]float64 `json:"steps"`
+}
+
+func loadHistory() ([]deployRecord, error) {
+	data, err := ioutil.ReadFile(historyFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var history []deployRecord
+	err = json.Unmarshal(data, &history)
+	return history, err
+}
+
+// recordDeploy appends the deploy to the history.
+func recordDeploy(app string, started time.Time, steps map[string]time.Duration, succeeded bool) error {
+	history, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	record := deployRecord{App: app, StartedAt: started.UTC(), Succeeded: succeeded, Seconds: time.Since(started).Seconds(), Steps: map[string]float64{}}
+	for step, duration := range steps {
+		record.Steps[step] = duration.Seconds()
+	}
+	history = append(history, record)
+	if len(history) > historyLimit {
+		history = history[len(history)-historyLimit:]
+	}
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(stateDir, 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(historyFile, data, 0644)
+}
+
+// stepEstimates averages how long each step took in the app's recent
+// successful deploys.
+func stepEstimates(app string) map[string]time.Duration {
+	history, err := loadHistory()
+	if err != nil {
+		return nil
+	}
+	totals := map[string]float64{}
+	counts := map[string]int{}
+	sampled := 0
+	for i := len(history) - 1; i >= 0 && sampled < historySample; i-- {
+		if history[i].App != app || !history[i].Succeeded {
+			continue
+		}
+		sampled++
+		for step, seconds := range history[i].Steps {
+			totals[step] += seconds
+			counts[step]++
+		}
+	}
+	estimates := map[string]time.Duration{}
+	for step, total := range totals {
+		estimates[step] = time.Duration(total / float64(counts[step]) * float64(time.Second))
+	}
+	return estimates
+}
+
+// progress describes how far through the pipeline the deploy is at the
+// start of step number index, by the estimates, or "" without any.
+func (d *deployment) progress(index int) string {
+	if len(d.estimates) == 0 {
+		return ""
+	}
+	var done, left time.Duration
+	for i, step := range deploySteps {
+		if i < index {
+			done += d.estimates[step.name]
+		} else if !d.isCompleted(step.name) {
+			left += d.estimates[step.name]
+		}
+	}
+	if done+left == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d%%, about %s left", int(100*done/(done+left)), left.Round(time.Second))
+}
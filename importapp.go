@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+	"gopkg.in/yaml.v3"
+)
+
+// importApp writes .treeline-cf.yml and the Sails config for an app that was
+// deployed without the plugin, so it can be managed by it from now on.
+func importApp(cliConnection plugin.CliConnection, args []string) {
+	flags := flag.NewFlagSet("import-app", flag.ExitOnError)
+	force := flags.Bool("force", false, "overwrite an existing "+configFile)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("Usage: cf treeline import-app APP [--force]")
+		exitFailed("usage")
+	}
+	if _, err := os.Stat(configFile); err == nil && !*force {
+		fmt.Printf("%s already exists, rerun with --force to replace it\n", configFile)
+		exitFailed("exists")
+	}
+
+	config, err := configFromApp(cliConnection, flags.Arg(0))
+	if err != nil {
+		fail(err)
+	}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		fail(err)
+	}
+	header := fmt.Sprintf("# Imported from the %s app by 'cf treeline import-app'\n", config.App)
+	err = writeGenerated(configFile, append([]byte(header), data...))
+	if err != nil {
+		fmt.Println("Error writing configuration", err)
+		exitFailed(errorClass(err))
+	}
+	fmt.Println("Updated", configFile)
+
+	writeDevelopmentConfig(config)
+}
+
+func configFromApp(cliConnection plugin.CliConnection, name string) (*Config, error) {
+	app, err := cliConnection.GetApp(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", name, err)
+	}
+	config := &Config{
+		App:       name,
+		Memory:    fmt.Sprintf("%dM", app.Memory),
+		Instances: app.InstanceCount,
+		Buildpack: app.BuildpackUrl,
+		Env:       map[string]string{},
+		Services:  []ServiceConfig{},
+	}
+	for key, value := range app.EnvironmentVars {
+		if !strings.HasPrefix(key, "TREELINE_CF_") {
+			config.Env[key] = fmt.Sprint(value)
+		}
+	}
+	for _, route := range app.Routes {
+		config.Routes = append(config.Routes, RouteConfig{
+			Hostname: route.Host,
+			Domain:   route.Domain.Name,
+			Path:     route.Path,
+		})
+	}
+	for _, bound := range app.Services {
+		service, err := cliConnection.GetService(bound.Name)
+		if err != nil {
+			return nil, fmt.Errorf("could not read service %s: %v", bound.Name, err)
+		}
+		imported := ServiceConfig{Name: bound.Name}
+		if !service.IsUserProvided {
+			imported.Service = service.ServiceOffering.Name
+			imported.Plan = service.ServicePlan.Name
+		}
+		config.Services = append(config.Services, imported)
+	}
+	return config, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"code.cloudfoundry.org/cli/plugin"
+	"gopkg.in/yaml.v3"
+)
+
+// cfSession is the CLI connection of the running command, used to resolve
+// ${cf.*} references in the config.
+var cfSession plugin.CliConnection
+
+var cfReference = regexp.MustCompile(`\$\{cf\.([a-z_]+)\}`)
+
+// cfValues looks up what ${cf.NAME} can refer to.
+var cfValues = map[string]func(cliConnection plugin.CliConnection) (string, error){
+	"space": func(cliConnection plugin.CliConnection) (string, error) {
+		space, err := cliConnection.GetCurrentSpace()
+		return space.Name, err
+	},
+	"org": func(cliConnection plugin.CliConnection) (string, error) {
+		org, err := cliConnection.GetCurrentOrg()
+		return org.Name, err
+	},
+	"user": func(cliConnection plugin.CliConnection) (string, error) {
+		return cliConnection.Username()
+	},
+}
+
+// interpolate replaces ${cf.space}, ${cf.org} and ${cf.user} in every
+// scalar of the config, so one file can serve several spaces. Values in
+// known, such as the org and space the config targets, are used instead of
+// the session's.
+func interpolate(node *yaml.Node, known map[string]string) error {
+	resolved := map[string]string{}
+	resolving := map[string]bool{}
+	var expand func(value string) (string, error)
+	resolve := func(name, reference string) (string, error) {
+		if value, ok := resolved[name]; ok {
+			return value, nil
+		}
+		if value := known[name]; value != "" {
+			if resolving[name] {
+				return "", fmt.Errorf("%s refers to itself in %s", reference, configFile)
+			}
+			resolving[name] = true
+			value, err := expand(value)
+			if err != nil {
+				return "", err
+			}
+			resolved[name] = value
+			return value, nil
+		}
+		lookup, ok := cfValues[name]
+		if !ok {
+			return "", fmt.Errorf("unknown reference %s in %s; use ${cf.space}, ${cf.org} or ${cf.user}", reference, configFile)
+		}
+		if cfSession == nil {
+			return "", fmt.Errorf("%s refers to %s, which needs a cf session", configFile, reference)
+		}
+		value, err := lookup(cfSession)
+		if err == nil && value == "" {
+			err = fmt.Errorf("not set; target one with 'cf target' or log in")
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not resolve %s: %v", reference, err)
+		}
+		resolved[name] = value
+		return value, nil
+	}
+	expand = func(value string) (string, error) {
+		var err error
+		value = cfReference.ReplaceAllStringFunc(value, func(reference string) string {
+			if err != nil {
+				return reference
+			}
+			resolvedValue, resolveErr := resolve(cfReference.FindStringSubmatch(reference)[1], reference)
+			if resolveErr != nil {
+				err = resolveErr
+				return reference
+			}
+			return resolvedValue
+		})
+		return value, err
+	}
+	var walk func(node *yaml.Node) error
+	walk = func(node *yaml.Node) error {
+		if node.Kind == yaml.ScalarNode && cfReference.MatchString(node.Value) {
+			value, err := expand(node.Value)
+			if err != nil {
+				return err
+			}
+			node.Value = value
+		}
+		for _, child := range node.Content {
+			err := walk(child)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(node)
+}
+
+// targetedSpace reads the org and space the config will target, from the
+// target of a multi-target deploy or the top level, before the config is
+// decoded. ${cf.org} and ${cf.space} then name where the app goes rather
+// than what cf happens to target when the command starts.
+func targetedSpace(doc *yaml.Node) map[string]string {
+	type space struct {
+		Org   string `yaml:"org"`
+		Space string `yaml:"space"`
+	}
+	var targeted struct {
+		space   `yaml:",inline"`
+		Targets map[string]space `yaml:"targets"`
+	}
+	if len(doc.Content) > 0 {
+		// Mistakes in the file are reported when the whole config is
+		// decoded.
+		doc.Decode(&targeted)
+	}
+	if target, ok := targeted.Targets[os.Getenv(targetEnv)]; ok {
+		targeted.space = target
+	}
+	return map[string]string{"org": targeted.Org, "space": targeted.Space}
+}
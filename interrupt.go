@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// recordPreviousVersion remembers which droplet the app was running before
+// the deploy, so an interrupted deploy can put it back.
+func (d *deployment) recordPreviousVersion() error {
+	app, err := findApp(d.cliConnection, d.name)
+	if err != nil || app == nil {
+		return err
+	}
+	d.appGUID = app.GUID
+	if app.State == "STARTED" {
+		d.previousDroplet = currentDroplet(d.cliConnection, app.GUID)
+	}
+	return nil
+}
+
+// trapInterrupts handles Ctrl-C and SIGTERM for the rest of the deploy. The
+// returned function stops trapping.
+func (d *deployment) trapInterrupts() func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			d.interrupted(sig)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// restoreOnInterrupt runs restore if Ctrl-C or SIGTERM ends a command that
+// is not a deploy before the returned function is called.
+func restoreOnInterrupt(description string, restore func() error) func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Printf("\nReceived %v, cleaning up\n", sig)
+			err := restore()
+			if err != nil {
+				fmt.Printf("Could not %s: %v\n", description, err)
+			}
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func (d *deployment) interrupted(sig os.Signal) {
+	d.mutex.Lock()
+	current := d.current
+	completed := map[string]bool{}
+	for _, step := range d.completed {
+		completed[step] = true
+	}
+	d.mutex.Unlock()
+
+	if current == "" {
+		current = "setup"
+	}
+	fmt.Printf("\nReceived %v during %s, cleaning up\n", sig, current)
+	events.record("interrupted", map[string]interface{}{"signal": sig.String(), "step": current})
+
+	// push --no-start stops the app, so unless the new version got as far as
+	// starting, bring the old droplet back up.
+	if d.previousDroplet != "" && (completed["push"] || current == "push") && !completed["start"] {
+		fmt.Println("Restarting the previous version of", d.name)
+		err := d.restartPreviousVersion()
+		if err != nil {
+			fmt.Printf("Could not restart the previous version, run 'cf start %s': %v\n", d.name, err)
+		}
+	}
+
+	d.cleanup()
+
+	fmt.Println("Deploy interrupted. Steps left incomplete:")
+	for _, step := range deploySteps {
+		switch {
+		case completed[step.name]:
+		case step.name == current:
+			fmt.Printf("  %s (interrupted)\n", step.name)
+		default:
+			fmt.Printf("  %s\n", step.name)
+		}
+	}
+	events.close()
+	os.Exit(130)
+}
+
+func (d *deployment) restartPreviousVersion() error {
+	relationship := map[string]interface{}{
+		"data": map[string]string{"guid": d.previousDroplet},
+	}
+	err := cfCurl(d.cliConnection, "PATCH", "/v3/apps/"+d.appGUID+"/relationships/current_droplet", relationship, nil)
+	if err != nil {
+		return err
+	}
+	return cfCurl(d.cliConnection, "POST", "/v3/apps/"+d.appGUID+"/actions/start", nil, nil)
+}
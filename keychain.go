@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// keychainService groups the plugin's entries in the OS keychain.
+const keychainService = "cf-treeline"
+
+// credentials are the secrets 'cf treeline auth' manages, with the
+// environment variable that takes precedence over the stored value.
+var credentials = map[string]string{
+	"github":  "GITHUB_TOKEN",
+	"npm":     "NPM_TOKEN",
+	"env-key": envKeyEnv,
+	// treeline holds Treeline's login file, restored while treeline runs.
+	"treeline": "",
+}
+
+// knownCredential also accepts target-NAME, the secret for a deploy target.
+func knownCredential(name string) bool {
+	_, ok := credentials[name]
+	return ok || strings.HasPrefix(name, "target-")
+}
+
+// credential returns the environment variable if set, and otherwise the
+// value stored in the keychain, or "".
+func credential(name, envVar string) string {
+	if value := os.Getenv(envVar); envVar != "" && value != "" {
+		return value
+	}
+	value, err := keychainGet(name)
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+func auth(args []string) {
+	if len(args) == 0 || (args[0] != "set" && args[0] != "remove" && args[0] != "list") || (args[0] != "list" && len(args) != 2) {
+		fmt.Println("Usage: cf treeline auth set NAME")
+		fmt.Println("       cf treeline auth remove NAME")
+		fmt.Println("       cf treeline auth list")
+		exitFailed("usage")
+	}
+	var err error
+	switch args[0] {
+	case "list":
+		var names []string
+		for name := range credentials {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			status := "not stored"
+			if _, err := keychainGet(name); err == nil {
+				status = "stored"
+			}
+			fmt.Printf("%-10s %s\n", name, status)
+		}
+	case "set":
+		err = storeCredential(args[1])
+	case "remove":
+		if !knownCredential(args[1]) {
+			err = fmt.Errorf("unknown credential %q", args[1])
+		} else {
+			err = keychainDelete(args[1])
+		}
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func storeCredential(name string) error {
+	if !knownCredential(name) {
+		return fmt.Errorf("unknown credential %q, run 'cf treeline auth list' for the names", name)
+	}
+	if name == "treeline" {
+		data, err := ioutil.ReadFile(treelineSecretFile())
+		if err != nil {
+			return fmt.Errorf("log in with 'treeline login' first: %v", err)
+		}
+		err = keychainSet(name, string(data))
+		if err != nil {
+			return err
+		}
+		fmt.Println("Moved", treelineSecretFile(), "into the keychain")
+		return os.Remove(treelineSecretFile())
+	}
+	fmt.Printf("%s: ", name)
+	value, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fmt.Errorf("nothing stored")
+	}
+	return keychainSet(name, value)
+}
+
+// treelineSecretFile is where the treeline CLI keeps its login.
+func treelineSecretFile() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".treeline.secret.json")
+}
+
+// restoreTreelineLogin writes Treeline's login back from the keychain for
+// as long as treeline runs. The returned function removes it again, unless
+// treeline replaced it with a new login.
+func restoreTreelineLogin() func() {
+	file := treelineSecretFile()
+	if _, err := os.Stat(file); err == nil {
+		return func() {}
+	}
+	secret, err := keychainGet("treeline")
+	if err != nil {
+		return func() {}
+	}
+	if ioutil.WriteFile(file, []byte(secret), 0600) != nil {
+		return func() {}
+	}
+	return func() {
+		data, err := ioutil.ReadFile(file)
+		if err == nil && string(data) == secret {
+			os.Remove(file)
+		}
+	}
+}
+
+func keychainGet(name string) (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		out, err := command("security", "find-generic-password", "-s", keychainService, "-a", name, "-w").Output()
+		return strings.TrimSuffix(string(out), "\n"), err
+	case "windows":
+		return dpapiGet(name)
+	default:
+		out, err := command("secret-tool", "lookup", "service", keychainService, "account", name).Output()
+		if err == nil && len(out) == 0 {
+			err = fmt.Errorf("%s is not stored", name)
+		}
+		return string(out), err
+	}
+}
+
+func keychainSet(name, secret string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		// With -w last and no value, security prompts for the secret, and
+		// again to confirm it, so it is never on the command line.
+		add := command("security", "add-generic-password", "-U", "-s", keychainService, "-a", name, "-w")
+		add.Stdin = strings.NewReader(secret + "\n" + secret + "\n")
+		return add.Run()
+	case "windows":
+		return dpapiSet(name, secret)
+	default:
+		store := command("secret-tool", "store", "--label", keychainService+" "+name, "service", keychainService, "account", name)
+		store.Stdin = strings.NewReader(secret)
+		return store.Run()
+	}
+}
+
+func keychainDelete(name string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return command("security", "delete-generic-password", "-s", keychainService, "-a", name).Run()
+	case "windows":
+		return os.Remove(dpapiFile(name))
+	default:
+		return command("secret-tool", "clear", "service", keychainService, "account", name).Run()
+	}
+}
+
+// Windows has no keychain command line tool, so secrets are encrypted with
+// DPAPI for the current user through PowerShell and kept in files.
+func dpapiFile(name string) string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".treeline-cf", "credentials", name)
+}
+
+func dpapiSet(name, secret string) error {
+	encrypt := command("powershell", "-NoProfile", "-Command",
+		"[Console]::In.ReadToEnd() | ConvertTo-SecureString -AsPlainText -Force | ConvertFrom-SecureString")
+	encrypt.Stdin = strings.NewReader(secret)
+	out, err := encrypt.Output()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(dpapiFile(name)), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dpapiFile(name), out, 0600)
+}
+
+func dpapiGet(name string) (string, error) {
+	data, err := ioutil.ReadFile(dpapiFile(name))
+	if err != nil {
+		return "", err
+	}
+	decrypt := command("powershell", "-NoProfile", "-Command",
+		"$s = [Console]::In.ReadToEnd().Trim() | ConvertTo-SecureString; "+
+			"[Runtime.InteropServices.Marshal]::PtrToStringBSTR([Runtime.InteropServices.Marshal]::SecureStringToBSTR($s))")
+	decrypt.Stdin = strings.NewReader(string(data))
+	out, err := decrypt.Output()
+	return strings.TrimRight(string(out), "\r\n"), err
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// korifi caches whether the targeted foundation runs on Kubernetes with
+// Korifi, which only serves the v3 API. The cf CLI's plugin calls such as
+// GetApp and GetServices read the v2 API, so they are avoided there.
+var korifi *bool
+
+// onKorifi reads the cf_on_k8s flag Korifi sets in the API root.
+func onKorifi(cliConnection plugin.CliConnection) bool {
+	if korifi != nil {
+		return *korifi
+	}
+	var root struct {
+		CFOnK8s bool `json:"cf_on_k8s"`
+	}
+	detected := cfCurl(cliConnection, "GET", "/", nil, &root) == nil && root.CFOnK8s
+	korifi = &detected
+	if detected {
+		fmt.Println("This foundation runs Korifi; using its v3 API")
+	}
+	return detected
+}
+
+// instanceStat is one instance of the app's web process.
+type instanceStat struct {
+	State    string
+	MemUsage int64
+	MemQuota int64
+}
+
+// webInstances returns the state of every requested instance of the app's
+// web process, from the v3 stats on Korifi and the cf CLI elsewhere.
+func webInstances(cliConnection plugin.CliConnection, name, guid string) ([]instanceStat, error) {
+	var instances []instanceStat
+	if !onKorifi(cliConnection) {
+		app, err := cliConnection.GetApp(name)
+		if err != nil {
+			return nil, err
+		}
+		// A crashed instance reports no quota, so the app's limit is used.
+		quota := app.Memory * 1024 * 1024
+		for _, instance := range app.Instances {
+			instances = append(instances, instanceStat{instance.State, instance.MemUsage, quota})
+		}
+		// Instances is empty until the app has been scheduled.
+		for len(instances) < app.InstanceCount {
+			instances = append(instances, instanceStat{State: "STARTING", MemQuota: quota})
+		}
+		return instances, nil
+	}
+	var stats struct {
+		Resources []struct {
+			State string `json:"state"`
+			Usage struct {
+				Mem int64 `json:"mem"`
+			} `json:"usage"`
+			MemQuota int64 `json:"mem_quota"`
+		} `json:"resources"`
+	}
+	err := cfCurl(cliConnection, "GET", "/v3/apps/"+guid+"/processes/web/stats", nil, &stats)
+	if err != nil {
+		return nil, err
+	}
+	for _, stat := range stats.Resources {
+		instances = append(instances, instanceStat{stat.State, stat.Usage.Mem, stat.MemQuota})
+	}
+	return instances, nil
+}
+
+// ensureKorifiServices binds the services to the app through the v3 API.
+// Korifi has no classic marketplace to create cleardb or rediscloud from,
+// so each instance has to exist already, usually made with 'cf cups'.
+func ensureKorifiServices(cliConnection plugin.CliConnection, name string, services []ServiceConfig) error {
+	if len(services) == 0 {
+		return nil
+	}
+	app, err := findApp(cliConnection, name)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("app %s not found", name)
+	}
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, service := range services {
+		names = append(names, service.Name)
+	}
+	var instances struct {
+		Resources []struct {
+			GUID string `json:"guid"`
+			Name string `json:"name"`
+		} `json:"resources"`
+	}
+	query := url.Values{"names": {strings.Join(names, ",")}, "space_guids": {space.Guid}}
+	err = cfCurl(cliConnection, "GET", "/v3/service_instances?"+query.Encode(), nil, &instances)
+	if err != nil {
+		return err
+	}
+	guids := map[string]string{}
+	for _, instance := range instances.Resources {
+		guids[instance.Name] = instance.GUID
+	}
+	var bindings struct {
+		Resources []struct {
+			Relationships struct {
+				ServiceInstance struct {
+					Data struct {
+						GUID string `json:"guid"`
+					} `json:"data"`
+				} `json:"service_instance"`
+			} `json:"relationships"`
+		} `json:"resources"`
+	}
+	err = cfCurl(cliConnection, "GET", "/v3/service_credential_bindings?type=app&app_guids="+app.GUID, nil, &bindings)
+	if err != nil {
+		return err
+	}
+	bound := map[string]bool{}
+	for _, binding := range bindings.Resources {
+		bound[binding.Relationships.ServiceInstance.Data.GUID] = true
+	}
+
+	for _, service := range services {
+		guid, ok := guids[service.Name]
+		if !ok {
+			return fmt.Errorf("service %s does not exist; Korifi has no %s offering to create it from, so create it with 'cf cups %s -p CREDENTIALS'", service.Name, service.Service, service.Name)
+		}
+		if bound[guid] {
+			continue
+		}
+		args, err := service.bindArgs(name)
+		if err != nil {
+			return err
+		}
+		_, err = cliConnection.CliCommand(args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// managedLabel marks every app and service instance the plugin creates.
+const managedLabel = "managed-by"
+
+const managedBy = "treeline-cli"
+
+type v3Metadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// resourceLabels are stamped on what a deploy creates: who manages it, the
+// project it belongs to, the environment and the commit deployed.
+func resourceLabels(config *Config) map[string]string {
+	labels := map[string]string{
+		managedLabel: managedBy,
+		"project":    config.App,
+		"env":        config.Environment,
+	}
+	if labels["env"] == "" {
+		labels["env"] = config.Env["NODE_ENV"]
+	}
+	if labels["env"] == "" {
+		labels["env"] = "development"
+	}
+	out, err := command("git", "rev-parse", "HEAD").Output()
+	if err == nil {
+		labels["git-sha"] = strings.TrimSpace(string(out))
+	}
+	return labels
+}
+
+func labelApp(cliConnection plugin.CliConnection, name string, labels map[string]string) error {
+	app, err := findApp(cliConnection, name)
+	if err != nil || app == nil {
+		return err
+	}
+	return cfCurl(cliConnection, "PATCH", "/v3/apps/"+app.GUID, map[string]v3Metadata{"metadata": {Labels: labels}}, nil)
+}
+
+// labelServiceInstance stamps labels on the instance, keeping the owner and
+// project it was labeled with before.
+func labelServiceInstance(cliConnection plugin.CliConnection, name string, labels map[string]string) error {
+	instance, err := findServiceInstance(cliConnection, name)
+	if err != nil || instance == nil {
+		return err
+	}
+	wanted := map[string]string{}
+	for key, value := range labels {
+		if current := instance.Metadata.Labels[key]; current != "" && (key == managedLabel || key == "project") {
+			continue
+		}
+		wanted[key] = value
+	}
+	return cfCurl(cliConnection, "PATCH", "/v3/service_instances/"+instance.GUID, map[string]v3Metadata{"metadata": {Labels: wanted}}, nil)
+}
+
+// label stamps the app with resourceLabels; services labels the instances
+// the deploy created.
+func (d *deployment) label() error {
+	return labelApp(d.cliConnection, d.name, resourceLabels(d.config))
+}
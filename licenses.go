@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type LicensesConfig struct {
+	// Allow lists the SPDX identifiers that may be deployed. Every license
+	// is allowed when it is empty.
+	Allow []string `yaml:"allow,omitempty"`
+}
+
+type packageLicense struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	License string `json:"license"`
+	Path    string `json:"path"`
+	Allowed bool   `json:"allowed"`
+}
+
+func licenses(config *Config, args []string) {
+	flags := flag.NewFlagSet("licenses", flag.ExitOnError)
+	format := flags.String("format", "text", "report format: text, csv or json")
+	output := flags.String("output", "", "write the report to `FILE` instead of the terminal")
+	flags.Parse(args)
+
+	packages, err := installedLicenses("node_modules")
+	if err != nil {
+		fmt.Println("Could not read node_modules, run 'npm install' first:", err)
+		exitFailed(errorClass(err))
+	}
+	disallowed := 0
+	for i := range packages {
+		packages[i].Allowed = licenseAllowed(packages[i].License, config.Licenses.Allow)
+		if !packages[i].Allowed {
+			disallowed++
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(userPath(*output))
+		if err != nil {
+			fail(err)
+		}
+		defer file.Close()
+		out = file
+	}
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(packages)
+	case "csv":
+		writer := csv.NewWriter(out)
+		writer.Write([]string{"name", "version", "license", "allowed", "path"})
+		for _, pkg := range packages {
+			writer.Write([]string{pkg.Name, pkg.Version, pkg.License, fmt.Sprint(pkg.Allowed), pkg.Path})
+		}
+		writer.Flush()
+		err = writer.Error()
+	default:
+		writeLicenseSummary(out, packages)
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	if disallowed > 0 {
+		fmt.Printf("%d packages use licenses that are not in licenses.allow\n", disallowed)
+		exitFailed("check-failed")
+	}
+}
+
+func writeLicenseSummary(out io.Writer, packages []packageLicense) {
+	byLicense := map[string][]packageLicense{}
+	for _, pkg := range packages {
+		byLicense[pkg.License] = append(byLicense[pkg.License], pkg)
+	}
+	var names []string
+	for license := range byLicense {
+		names = append(names, license)
+	}
+	sort.Strings(names)
+	for _, license := range names {
+		fmt.Fprintf(out, "%-30s %d\n", license, len(byLicense[license]))
+		for _, pkg := range byLicense[license] {
+			if !pkg.Allowed {
+				fmt.Fprintf(out, "  not allowed: %s@%s\n", pkg.Name, pkg.Version)
+			}
+		}
+	}
+}
+
+// installedLicenses reads the license of every package under dir, including
+// scoped and nested packages. Each name@version is reported once.
+func installedLicenses(dir string) ([]packageLicense, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var packages []packageLicense
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() != "package.json" {
+			return err
+		}
+		// Only a package's own manifest, node_modules/<name>/package.json or
+		// node_modules/@scope/<name>/package.json, not test fixtures.
+		parent := filepath.Base(filepath.Dir(filepath.Dir(path)))
+		if parent != "node_modules" && !strings.HasPrefix(parent, "@") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		var manifest struct {
+			Name     string          `json:"name"`
+			Version  string          `json:"version"`
+			License  json.RawMessage `json:"license"`
+			Licenses []struct {
+				Type string `json:"type"`
+			} `json:"licenses"`
+		}
+		if json.Unmarshal(data, &manifest) != nil || manifest.Name == "" {
+			return nil
+		}
+		key := manifest.Name + "@" + manifest.Version
+		if seen[key] {
+			return nil
+		}
+		seen[key] = true
+		packages = append(packages, packageLicense{
+			Name:    manifest.Name,
+			Version: manifest.Version,
+			License: licenseName(manifest.License, manifest.Licenses),
+			Path:    filepath.ToSlash(filepath.Dir(path)),
+		})
+		return nil
+	})
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Name+"@"+packages[i].Version < packages[j].Name+"@"+packages[j].Version
+	})
+	return packages, err
+}
+
+// licenseName handles the string, {"type": ...} and deprecated licenses
+// array forms of package.json licenses.
+func licenseName(license json.RawMessage, legacy []struct {
+	Type string `json:"type"`
+}) string {
+	var name string
+	if json.Unmarshal(license, &name) == nil && name != "" {
+		return name
+	}
+	var typed struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(license, &typed) == nil && typed.Type != "" {
+		return typed.Type
+	}
+	var types []string
+	for _, l := range legacy {
+		types = append(types, l.Type)
+	}
+	if len(types) > 0 {
+		return "(" + strings.Join(types, " OR ") + ")"
+	}
+	return "UNKNOWN"
+}
+
+// licenseAllowed accepts an SPDX expression when any OR alternative has all
+// of its AND terms in allow.
+func licenseAllowed(license string, allow []string) bool {
+	if len(allow) == 0 {
+		return true
+	}
+	allowed := map[string]bool{}
+	for _, id := range allow {
+		allowed[strings.ToUpper(id)] = true
+	}
+	expression := strings.NewReplacer("(", "", ")", "").Replace(license)
+	for _, alternative := range strings.Split(expression, " OR ") {
+		all := true
+		for _, term := range strings.Split(alternative, " AND ") {
+			all = all && allowed[strings.ToUpper(strings.TrimSpace(term))]
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+	"gopkg.in/yaml.v3"
+)
+
+// localConfigFile holds one developer's overrides of .treeline-cf.yml. It
+// is git-ignored and merged over the shared file.
+const localConfigFile = ".treeline-cf.local.yml"
+
+// readLocalConfig returns the parsed local overrides, or nil when there are
+// none.
+func readLocalConfig() (*yaml.Node, error) {
+	data, err := ioutil.ReadFile(localConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc := &yaml.Node{}
+	err = yaml.Unmarshal(data, doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", localConfigFile, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	// git check-ignore exits 1 for files that are not ignored.
+	err = command("git", "check-ignore", "-q", localConfigFile).Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		fmt.Printf("Warning: %s is not git-ignored; add it to .gitignore so it is not committed or pushed\n", localConfigFile)
+	}
+	return doc.Content[0], nil
+}
+
+// mergeConfig merges override into base: mappings key by key, anything else
+// replaced whole, so a local services list replaces the shared one.
+func mergeConfig(base, override *yaml.Node) {
+	if base.Kind != yaml.MappingNode || override.Kind != yaml.MappingNode {
+		*base = *override
+		return
+	}
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		key, value := override.Content[i], override.Content[i+1]
+		merged := false
+		for j := 0; j+1 < len(base.Content); j += 2 {
+			if base.Content[j].Value == key.Value {
+				mergeConfig(base.Content[j+1], value)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			base.Content = append(base.Content, key, value)
+		}
+	}
+}
+
+// targetSpace switches the cf CLI to the configured org and space when they
+// are not already targeted.
+func (c *Config) targetSpace(cliConnection plugin.CliConnection) error {
+	if c.Org == "" && c.Space == "" {
+		return nil
+	}
+	args := []string{"target"}
+	if c.Org != "" {
+		org, err := cliConnection.GetCurrentOrg()
+		if err != nil || !strings.EqualFold(org.Name, c.Org) {
+			args = append(args, "-o", c.Org)
+		}
+	}
+	if c.Space != "" {
+		space, err := cliConnection.GetCurrentSpace()
+		// Targeting another org leaves no space targeted.
+		if err != nil || len(args) > 1 || !strings.EqualFold(space.Name, c.Space) {
+			args = append(args, "-s", c.Space)
+		}
+	}
+	if len(args) == 1 {
+		return nil
+	}
+	fmt.Printf("Targeting %s\n", strings.Join(args[1:], " "))
+	_, err := cliConnection.CliCommand(args...)
+	return err
+}
+
+// gitignore adds entry to .gitignore when it is not listed yet.
+func gitignore(entry string) error {
+	data, err := ioutil.ReadFile(".gitignore")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == entry || strings.TrimSpace(line) == "/"+entry {
+			return nil
+		}
+	}
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		data = append(data, '\n')
+	}
+	data = append(data, entry+"\n"...)
+	return ioutil.WriteFile(".gitignore", data, 0644)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// A deploy lock is a user-provided service instance named after the app.
+// Service instance names are unique within a space, so only one deploy can
+// create it; the credentials record who holds it.
+type deployLock struct {
+	Holder string `json:"holder"`
+	Since  string `json:"since"`
+	ID     string `json:"id"`
+}
+
+func lockName(name string) string {
+	return name + "-deploy-lock"
+}
+
+func acquireDeployLock(cliConnection plugin.CliConnection, name string, steal bool) error {
+	if steal {
+		existing, err := currentDeployLock(cliConnection, name)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			fmt.Printf("Stealing deploy lock held by %s since %s\n", existing.Holder, existing.Since)
+			err = releaseDeployLock(cliConnection, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	lock := deployLock{
+		Holder: lockHolder(cliConnection),
+		Since:  time.Now().UTC().Format(time.RFC3339),
+		ID:     fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano()),
+	}
+	credentials, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	// Newer CLIs report success when the instance already exists, so the
+	// lock is only ours if the stored ID matches.
+	_, createErr := cliConnection.CliCommandWithoutTerminalOutput("create-user-provided-service", lockName(name), "-p", string(credentials))
+	existing, err := currentDeployLock(cliConnection, name)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return fmt.Errorf("could not create deploy lock %s: %v", lockName(name), createErr)
+	}
+	if existing.ID != lock.ID {
+		return fmt.Errorf("%s is being deployed by %s since %s; rerun with --steal-lock if that deploy is no longer running", name, existing.Holder, existing.Since)
+	}
+	return nil
+}
+
+func releaseDeployLock(cliConnection plugin.CliConnection, name string) error {
+	_, err := cliConnection.CliCommandWithoutTerminalOutput("delete-service", lockName(name), "-f")
+	return err
+}
+
+// currentDeployLock returns nil when nobody holds the lock.
+func currentDeployLock(cliConnection plugin.CliConnection, name string) (*deployLock, error) {
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil {
+		return nil, err
+	}
+	var instances struct {
+		Resources []struct {
+			GUID string `json:"guid"`
+		} `json:"resources"`
+	}
+	path := "/v3/service_instances?type=user-provided&names=" + url.QueryEscape(lockName(name)) + "&space_guids=" + space.Guid
+	err = cfCurl(cliConnection, "GET", path, nil, &instances)
+	if err != nil {
+		return nil, err
+	}
+	if len(instances.Resources) == 0 {
+		return nil, nil
+	}
+	lock := &deployLock{}
+	err = cfCurl(cliConnection, "GET", "/v3/service_instances/"+instances.Resources[0].GUID+"/credentials", nil, lock)
+	if err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+func lockHolder(cliConnection plugin.CliConnection) string {
+	user, err := cliConnection.Username()
+	if err != nil || user == "" {
+		user = os.Getenv("USER")
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return user
+	}
+	return user + "@" + host
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// cfLogLine matches the lines cf logs prints, like
+// "2024-05-01T10:00:00.00+0000 [APP/PROC/WEB/0] OUT listening".
+var cfLogLine = regexp.MustCompile(`^\s*(\S+)\s+\[([^\]]+)\]\s+(OUT|ERR)\s?(.*)$`)
+
+const cfLogTime = "2006-01-02T15:04:05.00-0700"
+
+type logEntry struct {
+	Time    string `json:"time"`
+	Source  string `json:"source"`
+	Stream  string `json:"stream"`
+	Message string `json:"message"`
+}
+
+type logFilter struct {
+	grep   *regexp.Regexp
+	since  time.Time
+	source string
+}
+
+func parseLogLine(line string) (logEntry, bool) {
+	match := cfLogLine.FindStringSubmatch(line)
+	if match == nil {
+		return logEntry{}, false
+	}
+	return logEntry{Time: match[1], Source: match[2], Stream: match[3], Message: match[4]}, true
+}
+
+// matches applies the filter. --source matches the start of the source, so
+// APP matches APP/PROC/WEB/0 and RTR matches every router instance.
+func (f logFilter) matches(entry logEntry) bool {
+	if f.source != "" && !strings.HasPrefix(strings.ToUpper(entry.Source), strings.ToUpper(f.source)) {
+		return false
+	}
+	if !f.since.IsZero() {
+		if at, err := time.Parse(cfLogTime, entry.Time); err == nil && at.Before(f.since) {
+			return false
+		}
+	}
+	return f.grep == nil || f.grep.MatchString(entry.Message)
+}
+
+func logs(cliConnection plugin.CliConnection, config *Config, args []string) {
+	flags := flag.NewFlagSet("logs", flag.ExitOnError)
+	grep := flags.String("grep", "", "only show lines whose message matches the regular expression `PATTERN`")
+	since := flags.Duration("since", 0, "only show lines from the last `DURATION`, such as 1h")
+	source := flags.String("source", "", "only show lines from `SOURCE`, such as APP, RTR, STG or CELL")
+	follow := flags.Bool("follow", false, "keep streaming new lines after the recent ones")
+	asJSON := flags.Bool("json", false, "print one JSON object per line")
+	flags.Parse(args)
+
+	name := flags.Arg(0)
+	if name == "" {
+		name = config.App
+	}
+
+	filter := logFilter{source: *source}
+	if *grep != "" {
+		pattern, err := regexp.Compile(*grep)
+		if err != nil {
+			fmt.Println("Invalid --grep pattern:", err)
+			exitFailed(errorClass(err))
+		}
+		filter.grep = pattern
+	}
+	if *since > 0 {
+		filter.since = time.Now().Add(-*since)
+	}
+	info, _ := os.Stdout.Stat()
+	highlight := !*asJSON && filter.grep != nil && info != nil && info.Mode()&os.ModeCharDevice != 0
+
+	show := func(line string) {
+		entry, ok := parseLogLine(line)
+		if !ok || !filter.matches(entry) {
+			return
+		}
+		switch {
+		case *asJSON:
+			data, _ := json.Marshal(entry)
+			fmt.Println(string(data))
+		case highlight:
+			fmt.Println(strings.TrimSpace(strings.Replace(line, entry.Message, filter.grep.ReplaceAllString(entry.Message, "\x1b[1;31m$0\x1b[0m"), 1)))
+		default:
+			fmt.Println(strings.TrimSpace(line))
+		}
+	}
+
+	recent, err := recentLogs(cliConnection, name)
+	if err != nil {
+		fmt.Println("Could not read recent logs:", err)
+		exitFailed(errorClass(err))
+	}
+	for _, line := range recent {
+		show(line)
+	}
+	if !*follow {
+		return
+	}
+
+	// cf logs only streams through the cf binary; plugin commands return
+	// their output once the command ends.
+	stream := command("cf", "logs", name)
+	stream.Stderr = os.Stderr
+	out, err := stream.StdoutPipe()
+	if err == nil {
+		err = stream.Start()
+	}
+	if err != nil {
+		fmt.Println("Could not stream logs:", err)
+		exitFailed(errorClass(err))
+	}
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		show(scanner.Text())
+	}
+	stream.Wait()
+}
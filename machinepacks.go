@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// packRequirement is what a machinepack needs from the deployed app beyond
+// its npm package.
+type packRequirement struct {
+	env     []string
+	service *ServiceConfig
+}
+
+var packRequirements = map[string]packRequirement{
+	"machinepack-s3":      {env: []string{"S3_BUCKET", "S3_ACCESS_KEY_ID", "S3_SECRET_ACCESS_KEY"}},
+	"machinepack-mailgun": {env: []string{"MAILGUN_API_KEY", "MAILGUN_DOMAIN"}},
+	"machinepack-stripe":  {env: []string{"STRIPE_SECRET_KEY"}},
+	"machinepack-twilio":  {env: []string{"TWILIO_ACCOUNT_SID", "TWILIO_AUTH_TOKEN"}},
+	"machinepack-redis":   {service: &ServiceConfig{Name: "hackday-rediscloud", Type: "redis", BindingName: "cache"}},
+	"machinepack-mysql":   {service: &ServiceConfig{Name: "hackday-cleardb", Type: "mysql", BindingName: "db"}},
+}
+
+func machinepacks(config *Config, args []string) {
+	if len(args) < 1 || (args[0] != "browse" && args[0] != "install") {
+		fmt.Println("Usage: cf treeline mp browse [QUERY]")
+		fmt.Println("       cf treeline mp install PACK")
+		exitFailed("usage")
+	}
+
+	if args[0] == "browse" {
+		runTreeline(append([]string{"mp"}, args...))
+		return
+	}
+
+	if len(args) != 2 {
+		fmt.Println("Usage: cf treeline mp install PACK")
+		exitFailed("usage")
+	}
+	pack := args[1]
+	if !strings.HasPrefix(pack, "machinepack-") {
+		pack = "machinepack-" + pack
+	}
+	runTreeline([]string{"mp", "install", pack})
+
+	npmInstall := command("npm", "install", pack, "--save")
+	npmInstall.Stdout = os.Stdout
+	npmInstall.Stderr = os.Stderr
+	err := npmInstall.Run()
+	if err != nil {
+		fmt.Println("Error installing npm packages", err)
+		exitFailed(errorClass(err))
+	}
+
+	requirement, ok := packRequirements[pack]
+	if !ok {
+		return
+	}
+	err = addPackRequirement(config, pack, requirement)
+	if err != nil {
+		fmt.Println("Could not update", configFile, err)
+		exitFailed(errorClass(err))
+	}
+}
+
+// addPackRequirement prompts for the env vars the pack reads and records
+// them, and any service it needs, in .treeline-cf.yml.
+func addPackRequirement(config *Config, pack string, requirement packRequirement) error {
+	values := map[string]string{}
+	input := bufio.NewReader(os.Stdin)
+	for _, key := range requirement.env {
+		if _, ok := config.Env[key]; ok {
+			continue
+		}
+		fmt.Printf("%s needs %s (leave blank to set it in %s later): ", pack, key, configFile)
+		value, _ := input.ReadString('\n')
+		values[key] = strings.TrimSpace(value)
+	}
+	addService := requirement.service != nil
+	for _, service := range config.Services {
+		if addService && (service.Type == requirement.service.Type || service.Name == requirement.service.Name) {
+			addService = false
+		}
+	}
+	if len(values) == 0 && !addService {
+		return nil
+	}
+
+	err := editConfig(func(root *yaml.Node) error {
+		env := mappingEntry(root, "env", yaml.MappingNode)
+		for _, key := range requirement.env {
+			if value, ok := values[key]; ok {
+				entry := mappingEntry(env, key, yaml.ScalarNode)
+				entry.Value = value
+			}
+		}
+		if addService {
+			services := mappingEntry(root, "services", yaml.SequenceNode)
+			node := &yaml.Node{}
+			err := node.Encode(requirement.service)
+			if err != nil {
+				return err
+			}
+			services.Content = append(services.Content, node)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if addService {
+		fmt.Printf("Added service %s to %s for %s\n", requirement.service.Name, configFile, pack)
+	}
+	if len(values) > 0 {
+		fmt.Printf("Added the env vars %s needs to %s\n", pack, configFile)
+	}
+	return nil
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 
-	"github.com/cloudfoundry/cli/plugin"
+	"code.cloudfoundry.org/cli/plugin"
+	"github.com/SocalNick/cf-treeline-cli/treelinecf"
 )
 
 /*
@@ -31,64 +35,272 @@ type TreelineCli struct{}
 *	1 should the plugin exits nonzero.
  */
 func (c *TreelineCli) Run(cliConnection plugin.CliConnection, args []string) {
+	args = parseGlobalFlags(args)
+	cfSession = cliConnection
+
 	// Ensure that we called the command treeline
 	if args[0] == "treeline" {
-		_, err := exec.LookPath("treeline")
+		// new creates the project where it is run, and completion runs
+		// for every keystroke.
+		if len(args) < 2 || (args[1] != "new" && args[1] != "completion") {
+			err := useProjectRoot()
+			if err != nil {
+				fmt.Println("Could not change to the project root", err)
+				exitFailed(errorClass(err))
+			}
+		}
+		// The config is loaded once and handed to the commands; new creates
+		// the project it is loaded from later.
+		var config *Config
+		var configErr error
+		if len(args) < 2 || args[1] != "new" {
+			config, configErr = loadConfig()
+		}
+		// needConfig is the config for commands that cannot run without it.
+		needConfig := func() *Config {
+			if configErr != nil {
+				fail(configErr)
+			}
+			return config
+		}
+
+		err := ensureTreeline(config)
 		if err != nil {
-			fmt.Println("Please install treeline using 'npm install -g treeline'")
-			os.Exit(1)
+			fail(err)
 		}
 
-		if args[1] == "config-pws" {
-			writeDevelopmentConfig()
-			if _, err := os.Stat(".cfignore"); os.IsNotExist(err) {
-				err := os.Symlink(".gitignore", ".cfignore")
+		if config != nil && len(args) > 1 {
+			expanded, err := expandAlias(config, args[1:])
+			if err != nil {
+				fail(err)
+			}
+			args = append(args[:1], expanded...)
+		}
+		subcommand := ""
+		if len(args) > 1 {
+			subcommand = args[1]
+		}
+		if subcommand != "completion" {
+			checkForUpdate(config)
+			if config != nil {
+				err = checkTreelineVersion(config)
 				if err != nil {
-					fmt.Println("Could not link .cfignore to .gitignore", err)
-					os.Exit(1)
+					fail(err)
 				}
 			}
-			npmInstalls()
-			os.Exit(0)
 		}
-
-		if args[1] == "deploy" {
-			_, err = cliConnection.CliCommand("push", "hackday-nc", "--no-start")
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+		// Shells run completion __complete on every Tab, which must not
+		// wait on a telemetry report.
+		if subcommand != "completion" || len(args) < 3 || args[2] != "__complete" {
+			startTelemetry(subcommand)
+		}
+		switch subcommand {
+		case "config-pws":
+			flags := flag.NewFlagSet("config-pws", flag.ExitOnError)
+			queue := flags.String("queue", "", "also set up a job queue using `LIBRARY` (bull or kue) and a worker app")
+			scaffold := flags.Bool("scaffold", false, "create missing Sails project directories without asking")
+			flags.Parse(args[2:])
+			config := needConfig()
+			if !config.nodeProject() {
+				err = fmt.Errorf("config-pws generates Sails config, but %s sets type: generic", configFile)
+			}
+			if err == nil {
+				err = config.checkProjectType()
+			}
+			if err == nil {
+				err = checkSailsProject(*scaffold)
 			}
-			_, err = cliConnection.CliCommand("set-env", "hackday-nc", "NODE_ENV", "development")
 			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+				fail(err)
 			}
+			configPws(config)
+			if *queue != "" {
+				err = configureQueue(config, *queue)
+				if err != nil {
+					fmt.Println("Could not set up the job queue", err)
+					exitFailed(errorClass(err))
+				}
+			}
+			succeed()
+		case "deploy":
+			deploy(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "diagnose":
+			diagnose(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "routes":
+			routes(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "diff":
+			diff(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "apply":
+			apply(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "import-app":
+			importApp(cliConnection, args[2:])
+			succeed()
+		case "licenses":
+			licenses(needConfig(), args[2:])
+			succeed()
+		case "mp":
+			machinepacks(needConfig(), args[2:])
+			succeed()
+		case "new":
+			newProject(cliConnection, args[2:])
+			succeed()
+		case "space":
+			space(cliConnection, args[2:])
+			succeed()
+		case "env":
+			envCommand(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "auth":
+			auth(args[2:])
+			succeed()
+		case "droplet":
+			droplet(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "snapshot":
+			snapshotCommand(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "ssh":
+			ssh(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "console":
+			console(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "logs":
+			logs(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "watch":
+			watch(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "guard":
+			guard(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "sleep":
+			sleepCommand(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "wake":
+			wake(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "certs":
+			certs(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "cf":
+			cfPassthrough(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "diff-files":
+			diffFiles(needConfig(), args[2:])
+			succeed()
+		case "freeze":
+			freeze(cliConnection, args[2:])
+			succeed()
+		case "failover":
+			failover(needConfig(), args[2:])
+			succeed()
+		case "migrate-stack":
+			migrateStack(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "telemetry":
+			telemetryCommand(args[2:])
+			succeed()
+		case "completion":
+			completion(cliConnection, config, args[2:])
+			succeed()
+		case "ui":
+			ui(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "serve":
+			serve(cliConnection, needConfig(), args[2:])
+			succeed()
+		case "extensions":
+			extensionsCommand()
+			succeed()
+		}
 
-			createServices(cliConnection)
-
-			_, err = cliConnection.CliCommand("start", "hackday-nc")
+		if extension := findExtension(subcommand); extension != "" {
+			err := runExtension(cliConnection, needConfig(), extension, args[2:])
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				events.record("extension-failed", map[string]interface{}{"extension": subcommand, "exit_code": exitErr.ExitCode()})
+				os.Exit(exitErr.ExitCode())
+			}
 			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+				fail(err)
 			}
-
-			os.Exit(0)
+			succeed()
 		}
+		runTreeline(args[1:])
+		sendTelemetry("ok")
+	}
+}
 
-		cmd := exec.Command("treeline", args[1:]...)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-
-		err = cmd.Start()
-		if err != nil {
-			fmt.Println("Error starting command", err)
-			os.Exit(1)
-		}
-		err = cmd.Wait()
+// configPws generates the Cloud Foundry ready Sails config and installs the
+// adapters it uses.
+func configPws(config *Config) {
+	writeDevelopmentConfig(config)
+	err := writeUploadsConfig(config)
+	if err == nil && config.Tracing.enabled() {
+		err = writeTracingHook()
+	}
+	if err != nil {
+		fmt.Println("Error writing configuration", err)
+		exitFailed(errorClass(err))
+	}
+	err = gitignore(localConfigFile)
+	if err != nil {
+		fmt.Println("Could not update .gitignore", err)
+		exitFailed(errorClass(err))
+	}
+	err = writeCfignore()
+	if err != nil {
+		fmt.Println("Could not write .cfignore", err)
+		exitFailed(errorClass(err))
+	}
+	restoreNpmrc, err := writeNpmrc(config.Npm)
+	if err != nil {
+		fmt.Println("Could not write .npmrc", err)
+		exitFailed(errorClass(err))
+	}
+	stopTrap := restoreOnInterrupt("restore .npmrc", restoreNpmrc)
+	packages := append(sailsPackages(servicesForSails(config)), uploadPackages(config)...)
+	if offline {
+		err = requireVendored(packages)
 		if err != nil {
-			fmt.Println("Error running command", err)
-			os.Exit(1)
+			stopTrap()
+			restoreNpmrc()
+			fail(err)
 		}
+	} else {
+		npmInstalls(packages)
+	}
+	stopTrap()
+	err = restoreNpmrc()
+	if err != nil {
+		fmt.Println("Could not restore .npmrc", err)
+	}
+}
+
+func runTreeline(args []string) {
+	removeLogin := restoreTreelineLogin()
+	defer removeLogin()
+
+	cmd := command("treeline", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+
+	err := cmd.Start()
+	if err != nil {
+		removeLogin()
+		fmt.Println("Error starting command", err)
+		exitFailed(errorClass(err))
+	}
+	err = cmd.Wait()
+	if err != nil {
+		removeLogin()
+		fmt.Println("Error running command", err)
+		exitFailed(errorClass(err))
 	}
 }
 
@@ -108,12 +320,8 @@ func (c *TreelineCli) Run(cliConnection plugin.CliConnection, args []string) {
  */
 func (c *TreelineCli) GetMetadata() plugin.PluginMetadata {
 	return plugin.PluginMetadata{
-		Name: "TreelineCli",
-		Version: plugin.VersionType{
-			Major: 1,
-			Minor: 0,
-			Build: 0,
-		},
+		Name:    "TreelineCli",
+		Version: pluginVersion,
 		MinCliVersion: plugin.VersionType{
 			Major: 6,
 			Minor: 7,
@@ -127,7 +335,57 @@ func (c *TreelineCli) GetMetadata() plugin.PluginMetadata {
 				// UsageDetails is optional
 				// It is used to show help of usage of each command
 				UsageDetails: plugin.Usage{
-					Usage: "treeline\n   cf treeline",
+					Usage: "treeline\n" +
+						"   cf treeline new NAME [--skip-link] [--deploy] [--preset PLATFORM]\n" +
+						"   cf treeline config-pws [--queue bull|kue] [--scaffold]\n" +
+						"   cf treeline deploy [OPTIONS]\n" +
+						"   cf treeline diagnose [APP]\n" +
+						"   cf treeline routes split APP_A APP_B --domain DOMAIN [OPTIONS]\n" +
+						"   cf treeline routes show [APP...]\n" +
+						"   cf treeline routes check\n" +
+						"   cf treeline diff [--apply]\n" +
+						"   cf treeline apply [--env NAME] [--yes] [--plan [--json]]\n" +
+						"   cf treeline import-app APP [--force]\n" +
+						"   cf treeline licenses [--format text|csv|json] [--output FILE]\n" +
+						"   cf treeline mp browse [QUERY]\n" +
+						"   cf treeline mp install PACK\n" +
+						"   cf treeline space status\n" +
+						"   cf treeline space cleanup [--days N] [--yes]\n" +
+						"   cf treeline env push|pull [--env NAME]\n" +
+						"   cf treeline env show [--env NAME] [--reveal] [APP]\n" +
+						"   cf treeline env keygen\n" +
+						"   cf treeline auth set|remove NAME\n" +
+						"   cf treeline auth list\n" +
+						"   cf treeline droplet save [-o FILE] [APP]\n" +
+						"   cf treeline droplet load FILE [APP]\n" +
+						"   cf treeline snapshot save [--app APP] [--no-droplet] NAME\n" +
+						"   cf treeline snapshot restore [--app APP] NAME\n" +
+						"   cf treeline ssh [-i INDEX] [--repl node|sails] [COMMAND...]\n" +
+						"   cf treeline console [-i INDEX]\n" +
+						"   cf treeline logs [OPTIONS] [APP]\n" +
+						"   cf treeline watch [OPTIONS] [APP]\n" +
+						"   cf treeline guard [OPTIONS] [APP]\n" +
+						"   cf treeline sleep [--after DURATION] [--interval DURATION] [--once] [APP...]\n" +
+						"   cf treeline wake [APP...]\n" +
+						"   cf treeline certs check [--days N] [APP]\n" +
+						"   cf treeline cf [--env NAME] -- COMMAND [ARGS...]\n" +
+						"   cf treeline diff-files [APP]\n" +
+						"   cf treeline freeze on --reason REASON\n" +
+						"   cf treeline freeze off|status\n" +
+						"   cf treeline failover --to TARGET [--from TARGET] [--yes]\n" +
+						"   cf treeline migrate-stack [--stack STACK] [APP]\n" +
+						"   cf treeline completion bash|zsh|fish\n" +
+						"   cf treeline telemetry on|off|status [--endpoint URL]\n" +
+						"   cf treeline ui [APP]\n" +
+						"   cf treeline serve [--port PORT] [--token TOKEN]\n" +
+						"   cf treeline extensions\n" +
+						"   cf treeline EXTENSION [ARGS...]\n" +
+						"   cf treeline ALIAS [ARGS...]\n" +
+						"   cf treeline TREELINE_ARGS...\n\n" +
+						"   Run 'cf treeline COMMAND -h' to list a command's options.\n" +
+						"   Add --offline to any command to skip steps that need internet access.\n" +
+						"   Add --install-missing to install the treeline CLI with npm when it is missing.\n" +
+						"   Add --project-dir DIR to run against the app in DIR inside a larger repository.",
 				},
 			},
 		},
@@ -154,10 +412,20 @@ func main() {
 	// ensuring the plugin environment is bootstrapped.
 }
 
-func npmInstalls() {
-	packages := []string{"connect-redis@1.4.5", "sails-mysql", "socket.io-redis"}
+// sailsPackages are the adapters the generated development config uses.
+func sailsPackages(services sailsServices) []string {
+	database := "sails-mysql"
+	if services.Postgres != "" {
+		database = "sails-postgresql"
+	}
+	// redis 3.1 is the first client with the two argument AUTH Redis ACLs
+	// use.
+	return []string{"connect-redis@1.4.5", database, "redis@3.1.2", "socket.io-redis"}
+}
+
+func npmInstalls(packages []string) {
 	for _, value := range packages {
-		npmSetup := exec.Command("npm", "install", value, "--save", "--save-exact")
+		npmSetup := command("npm", "install", value, "--save", "--save-exact")
 		npmSetup.Stdout = os.Stdout
 		err := npmSetup.Run()
 		if err != nil {
@@ -166,197 +434,95 @@ func npmInstalls() {
 	}
 }
 
-func createServices(cliConnection plugin.CliConnection) {
-	services, err := cliConnection.GetServices()
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// createServices binds the services to the app, creating the missing
+// instances, and returns the names of the instances it created.
+func createServices(cliConnection plugin.CliConnection, name string, services []ServiceConfig) ([]string, error) {
+	if onKorifi(cliConnection) {
+		return nil, ensureKorifiServices(cliConnection, name, services)
 	}
-	redisFound, redisBound, sqlFound, sqlBound := false, false, false, false
+	var wanted []treelinecf.Service
 	for _, service := range services {
-		if service.Name == "hackday-rediscloud" {
-			redisFound = true
-			for _, app := range service.ApplicationNames {
-				if app == "hackday-nc" {
-					redisBound = true
-				}
-			}
-		}
-		if service.Name == "hackday-cleardb" {
-			sqlFound = true
-			for _, app := range service.ApplicationNames {
-				if app == "hackday-nc" {
-					sqlBound = true
-				}
-			}
-		}
+		wanted = append(wanted, service.service())
 	}
-	if !redisFound {
-		_, err = cliConnection.CliCommand("cs", "rediscloud", "30mb", "hackday-rediscloud")
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+	planner := treelinecf.ServicePlanner{Conn: cliConnection}
+	changes, err := planner.Plan(name, wanted)
+	if err != nil {
+		return nil, err
 	}
-	if !redisBound {
-		_, err = cliConnection.CliCommand("bs", "hackday-nc", "hackday-rediscloud")
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+	var created []string
+	for _, change := range changes {
+		if change.Action == "create" {
+			created = append(created, change.Service.Name)
 		}
 	}
-	if !sqlFound {
-		_, err = cliConnection.CliCommand("cs", "cleardb", "turtle", "hackday-cleardb")
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+	return created, planner.Apply(name, changes)
+}
+
+// sailsServices are the VCAP_SERVICES labels the generated development
+// config reads credentials from.
+type sailsServices = treelinecf.SailsServices
+
+func servicesForSails(config *Config) sailsServices {
+	services := sailsServices{MySQL: "cleardb", Redis: "rediscloud"}
+	for _, service := range config.Services {
+		parameters := ""
+		if len(service.Parameters) > 0 {
+			data, _ := json.Marshal(service.Parameters)
+			parameters = string(data)
 		}
-	}
-	if !sqlBound {
-		_, err = cliConnection.CliCommand("bs", "hackday-nc", "hackday-cleardb")
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		switch {
+		case service.Type == "postgres" || strings.Contains(service.Service, "postgres") || service.Service == "elephantsql":
+			services.Postgres = service.Service
+			services.PostgresName = service.Name
+			services.PostgresBinding = service.BindingName
+			services.PostgresParameters = parameters
+			services.Pool = config.Database.pool(service)
+		case service.Type == "mysql" || strings.Contains(service.Service, "mysql") || service.Service == "cleardb" || service.Service == "mariadb":
+			services.MySQL = service.Service
+			services.MySQLName = service.Name
+			services.MySQLBinding = service.BindingName
+			services.MySQLParameters = parameters
+			if database, ok := service.Parameters["database"].(string); ok {
+				services.MySQLDatabase = database
+			}
+		case service.Type == "redis" || strings.Contains(service.Service, "redis"):
+			services.Redis = service.Service
+			services.RedisName = service.Name
+			services.RedisBinding = service.BindingName
+			services.RedisParameters = parameters
 		}
 	}
+	return services
 }
 
-func writeDevelopmentConfig() {
-	developmentConfig := []byte(`
-/**
- * Development environment settings
- */
-
-if (process.env.VCAP_SERVICES) {
-  vcapServices = JSON.parse(process.env.VCAP_SERVICES);
-
-  module.exports = {
-
-    /***************************************************************************
-     * Set the default database connection for models in the development       *
-     * environment (see config/connections.js and config/models.js )           *
-     ***************************************************************************/
-
-    models: {
-      connection: 'sailsMySql',
-      migrate: 'alter'
-    },
-    connections: {
-      sailsMySql: {
-        adapter: 'sails-mysql',
-        host      : vcapServices.cleardb[0].credentials.hostname,
-        port      : 3306,
-        user      : vcapServices.cleardb[0].credentials.username,
-        password  : vcapServices.cleardb[0].credentials.password,
-        database  : vcapServices.cleardb[0].credentials.name
-      }
-    },
-
-    /***************************************************************************
-     * Session configuration                                                   *
-     ***************************************************************************/
-
-    session: {
-      adapter: 'redis',
-      host: vcapServices.rediscloud[0].credentials.hostname,
-      port: vcapServices.rediscloud[0].credentials.port,
-      pass: vcapServices.rediscloud[0].credentials.password,
-      prefix: 'sess:',
-      // ttl: <redis session TTL in seconds>,
-      // db: 0,
-    },
-
-    /***************************************************************************
-     * WebSocket Configuration                                                 *
-     ***************************************************************************/
-
-    sockets: {
-      adapter: 'socket.io-redis',
-      host: vcapServices.rediscloud[0].credentials.hostname,
-      port: vcapServices.rediscloud[0].credentials.port,
-      pass: vcapServices.rediscloud[0].credentials.password,
-      // db: 'sails',
-    },
-
-    /***************************************************************************
-     * Set the port in the development environment to 80                       *
-     ***************************************************************************/
-
-    port: process.env.PORT,
-
-    /***************************************************************************
-     * Set the log level in development environment to "silent"                *
-     ***************************************************************************/
-
-    log: {
-       level: "verbose"
-    }
-
-  };
-}
-`)
-	err := ioutil.WriteFile("config/env/development.js", developmentConfig, 0644)
+func writeDevelopmentConfig(config *Config) {
+	writer := treelinecf.ConfigWriter{Mode: generatedMode}
+	for _, file := range []string{treelinecf.DevelopmentFile, treelinecf.VCAPFile, treelinecf.LocalFile} {
+		noteUnmanaged(filepath.FromSlash(file))
+	}
+	err := writer.WriteDevelopment(servicesForSails(config))
 	if err != nil {
 		fmt.Println("Error writing configuration", err)
-		os.Exit(1)
+		exitFailed(errorClass(err))
 	}
-	fmt.Println("Updated config/env/development.js")
-
-	localConfig := []byte(`
-/**
- * Local environment settings
- */
-
-module.exports = {
-
-  /***************************************************************************
-   * Set the default database connection for models in the local             *
-   * environment (see config/connections.js and config/models.js )           *
-   ***************************************************************************/
-
-  models: {
-    connection: 'localDiskDb',
-  },
-  connections: {
-    localDiskDb: {
-      adapter: 'sails-disk',
-    }
-  },
+	fmt.Println("Updated", treelinecf.DevelopmentFile)
 
-  /***************************************************************************
-   * Session configuration                                                   *
-   ***************************************************************************/
-
-  session: {
-  },
-
-  /***************************************************************************
-   * WebSocket Configuration                                                 *
-   ***************************************************************************/
-
-  sockets: {
-  },
-
-  /***************************************************************************
-   * Set the port in the development environment to 80                       *
-   ***************************************************************************/
-
-  port: process.env.PORT || 1337,
-
-  /***************************************************************************
-   * Set the log level in development environment to "silent"                *
-   ***************************************************************************/
-
-  log: {
-     level: "verbose"
-  }
+	err = writer.WriteVCAP()
+	if err != nil {
+		fmt.Println("Error writing configuration", err)
+		exitFailed(errorClass(err))
+	}
+	fmt.Println("Updated", treelinecf.VCAPFile)
 
-};
-`)
-	err = ioutil.WriteFile("config/local.js", localConfig, 0644)
+	err = writer.WriteLocal()
 	if err != nil {
 		fmt.Println("Error writing configuration", err)
-		os.Exit(1)
+		exitFailed(errorClass(err))
+	}
+	fmt.Println("Updated", treelinecf.LocalFile)
+
+	err = checkJavaScript(treelinecf.DevelopmentFile, treelinecf.VCAPFile, treelinecf.LocalFile)
+	if err != nil {
+		fail(err)
 	}
-	fmt.Println("Updated config/local.js")
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// fileManifest records the hash of every file a deploy uploaded, so
+// diff-files can show what the next one would change.
+type fileManifest struct {
+	App        string            `json:"app"`
+	DeployedAt time.Time         `json:"deployed_at"`
+	Files      map[string]string `json:"files"`
+}
+
+func manifestFile(app string) string {
+	return filepath.Join(stateDir, "manifests", app+".json")
+}
+
+// hashAppFiles returns the SHA-1 of each file cf push would upload from root.
+func hashAppFiles(root string) (map[string]string, error) {
+	files := map[string]string{}
+	err := walkAppFiles(root, func(path string, info os.FileInfo) error {
+		sum, err := fileSHA1(filepath.Join(root, filepath.FromSlash(path)))
+		files[path] = sum
+		return err
+	})
+	return files, err
+}
+
+func fileSHA1(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha1.New()
+	_, err = io.Copy(hash, f)
+	return hex.EncodeToString(hash.Sum(nil)), err
+}
+
+func saveFileManifest(app, root string) error {
+	files, err := hashAppFiles(root)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(fileManifest{App: app, DeployedAt: time.Now().UTC(), Files: files}, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(manifestFile(app)), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(manifestFile(app), data, 0644)
+}
+
+func loadFileManifest(app string) (*fileManifest, error) {
+	data, err := ioutil.ReadFile(manifestFile(app))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no file manifest for %s; it is saved by 'cf treeline deploy'", app)
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest := &fileManifest{}
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", manifestFile(app), err)
+	}
+	return manifest, nil
+}
+
+func diffFiles(config *Config, args []string) {
+	flags := flag.NewFlagSet("diff-files", flag.ExitOnError)
+	flags.Parse(args)
+
+	name := config.App
+	if flags.NArg() > 0 {
+		name = flags.Arg(0)
+	}
+	manifest, err := loadFileManifest(name)
+	if err != nil {
+		fail(err)
+	}
+	local, err := hashAppFiles(".")
+	if err != nil {
+		fail(err)
+	}
+
+	var lines []string
+	for path, hash := range local {
+		deployed, ok := manifest.Files[path]
+		switch {
+		case !ok:
+			lines = append(lines, "A "+path)
+		case deployed != hash:
+			lines = append(lines, "M "+path)
+		}
+	}
+	for path := range manifest.Files {
+		if _, ok := local[path]; !ok {
+			lines = append(lines, "D "+path)
+		}
+	}
+	if len(lines) == 0 {
+		fmt.Printf("No changes since %s was deployed %s\n", name, manifest.DeployedAt.Local().Format(time.RFC1123))
+		return
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+	fmt.Printf("Changes since %s was deployed %s:\n", name, manifest.DeployedAt.Local().Format(time.RFC1123))
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type MetricsConfig struct {
+	// Pushgateway is the base URL of a Prometheus Pushgateway.
+	Pushgateway string `yaml:"pushgateway,omitempty"`
+	// StatsD is the host:port of a StatsD server, written to over UDP.
+	StatsD string `yaml:"statsd,omitempty"`
+	// Prefix is prepended to every metric name and defaults to treeline_cf.
+	Prefix string `yaml:"prefix,omitempty"`
+}
+
+func (c MetricsConfig) enabled() bool {
+	return c.Pushgateway != "" || c.StatsD != ""
+}
+
+type deployMetrics struct {
+	app          string
+	phases       map[string]time.Duration
+	total        time.Duration
+	succeeded    bool
+	packageBytes int64
+}
+
+func pushMetrics(config MetricsConfig, metrics deployMetrics) {
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = "treeline_cf"
+	}
+	if config.Pushgateway != "" {
+		err := pushToGateway(config.Pushgateway, prefix, metrics)
+		if err != nil {
+			fmt.Println("Could not push metrics to the Pushgateway:", err)
+		}
+	}
+	if config.StatsD != "" {
+		err := sendToStatsD(config.StatsD, prefix, metrics)
+		if err != nil {
+			fmt.Println("Could not send metrics to StatsD:", err)
+		}
+	}
+}
+
+func pushToGateway(gateway, prefix string, metrics deployMetrics) error {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "# TYPE %s_deploy_phase_seconds gauge\n", prefix)
+	for _, phase := range sortedPhases(metrics.phases) {
+		fmt.Fprintf(&body, "%s_deploy_phase_seconds{phase=%q} %f\n", prefix, phase, metrics.phases[phase].Seconds())
+	}
+	fmt.Fprintf(&body, "# TYPE %s_deploy_duration_seconds gauge\n", prefix)
+	fmt.Fprintf(&body, "%s_deploy_duration_seconds %f\n", prefix, metrics.total.Seconds())
+	fmt.Fprintf(&body, "# TYPE %s_deploy_success gauge\n", prefix)
+	fmt.Fprintf(&body, "%s_deploy_success %d\n", prefix, boolToInt(metrics.succeeded))
+	fmt.Fprintf(&body, "# TYPE %s_deploy_package_bytes gauge\n", prefix)
+	fmt.Fprintf(&body, "%s_deploy_package_bytes %d\n", prefix, metrics.packageBytes)
+	fmt.Fprintf(&body, "# TYPE %s_deploy_timestamp_seconds gauge\n", prefix)
+	fmt.Fprintf(&body, "%s_deploy_timestamp_seconds %d\n", prefix, time.Now().Unix())
+
+	target := fmt.Sprintf("%s/metrics/job/%s/app/%s", strings.TrimRight(gateway, "/"), url.PathEscape(prefix), url.PathEscape(metrics.app))
+	req, err := http.NewRequest("PUT", target, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("PUT %s: %s", target, resp.Status)
+	}
+	return nil
+}
+
+func sendToStatsD(address, prefix string, metrics deployMetrics) error {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	name := prefix + "." + strings.Replace(metrics.app, ".", "_", -1) + ".deploy"
+	var lines []string
+	for _, phase := range sortedPhases(metrics.phases) {
+		lines = append(lines, fmt.Sprintf("%s.phase.%s:%d|ms", name, phase, metrics.phases[phase]/time.Millisecond))
+	}
+	lines = append(lines, fmt.Sprintf("%s.duration:%d|ms", name, metrics.total/time.Millisecond))
+	if metrics.succeeded {
+		lines = append(lines, name+".success:1|c")
+	} else {
+		lines = append(lines, name+".failure:1|c")
+	}
+	lines = append(lines, fmt.Sprintf("%s.package_bytes:%d|g", name, metrics.packageBytes))
+
+	for _, line := range lines {
+		_, err = conn.Write([]byte(line))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedPhases(phases map[string]time.Duration) []string {
+	var names []string
+	for name := range phases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const monitorInterval = 5 * time.Second
+
+// waitForInstances blocks until every requested instance is RUNNING, so a
+// successful deploy means the app is serving at full capacity.
+func (d *deployment) waitForInstances() error {
+	started := time.Now().UTC()
+	deadline := started.Add(d.readyTimeout)
+	v3, err := findApp(d.cliConnection, d.name)
+	if err != nil || v3 == nil {
+		return fmt.Errorf("could not find %s: %v", d.name, err)
+	}
+	last := ""
+	for {
+		instances, err := webInstances(d.cliConnection, d.name, v3.GUID)
+		if err != nil {
+			return err
+		}
+		running := 0
+		var states []string
+		for _, instance := range instances {
+			if instance.State == "RUNNING" {
+				running++
+			}
+			states = append(states, instance.State)
+		}
+		report := fmt.Sprint(states)
+		if report != last {
+			fmt.Printf("%d of %d instances running\n", running, len(instances))
+			for i, instance := range instances {
+				fmt.Printf("  #%d %s\n", i, instance.State)
+			}
+			last = report
+		}
+		if len(instances) > 0 && running == len(instances) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("only %d of %d instances were running after %v", running, len(instances), d.readyTimeout)
+		}
+		err = d.crashLoop(v3.GUID, started)
+		if err != nil {
+			return err
+		}
+		time.Sleep(monitorInterval)
+	}
+}
+
+// monitor watches the started app for d.monitorWindow and fails the deploy if
+// instances are killed for running out of memory or crash in a loop.
+func (d *deployment) monitor() error {
+	if d.monitorWindow <= 0 {
+		return nil
+	}
+	app, err := findApp(d.cliConnection, d.name)
+	if err != nil || app == nil {
+		return fmt.Errorf("could not find %s to monitor: %v", d.name, err)
+	}
+
+	fmt.Printf("Monitoring %s for %v\n", d.name, d.monitorWindow)
+	since := time.Now().UTC()
+	deadline := since.Add(d.monitorWindow)
+	warned := map[int]bool{}
+	for time.Now().Before(deadline) {
+		time.Sleep(monitorInterval)
+
+		instances, err := webInstances(d.cliConnection, d.name, app.GUID)
+		if err != nil {
+			fmt.Println("Could not read instance usage:", err)
+			continue
+		}
+		for i, instance := range instances {
+			if instance.MemQuota > 0 && instance.MemUsage*100/instance.MemQuota >= 90 && !warned[i] {
+				fmt.Printf("Instance #%d is using %d%% of its memory limit\n", i, instance.MemUsage*100/instance.MemQuota)
+				warned[i] = true
+			}
+		}
+
+		crashes, err := crashEventsSince(d, app.GUID, since)
+		if err != nil {
+			fmt.Println("Could not read app events:", err)
+			continue
+		}
+		for _, problem := range diagnoseLines(d.name, crashes) {
+			if problem.failure.name == "out of memory" {
+				var memoryMB int64
+				if len(instances) > 0 {
+					memoryMB = instances[0].MemQuota / (1024 * 1024)
+				}
+				return d.outOfMemory(memoryMB)
+			}
+		}
+		err = d.crashLoop(app.GUID, since)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func crashEventsSince(d *deployment, appGUID string, since time.Time) ([]string, error) {
+	appEvents, err := recentEvents(d.cliConnection, appGUID)
+	if err != nil {
+		return nil, err
+	}
+	var crashes []string
+	for _, event := range appEvents {
+		created, err := time.Parse(time.RFC3339, event.CreatedAt)
+		if err != nil || created.Before(since) || event.Type != "audit.app.process.crash" {
+			continue
+		}
+		crashes = append(crashes, event.describe())
+	}
+	return crashes, nil
+}
+
+// outOfMemory suggests doubling the memory limit, or applies it with
+// --auto-fix.
+func (d *deployment) outOfMemory(memoryMB int64) error {
+	suggested := memoryMB * 2
+	if suggested == 0 {
+		suggested = 1024
+	}
+	fmt.Printf("Instances of %s were killed for exceeding their %dM memory limit\n", d.name, memoryMB)
+	if !d.autoFix {
+		return fmt.Errorf("out of memory: run 'cf scale %s -m %dM' or redeploy with --auto-fix", d.name, suggested)
+	}
+	fmt.Printf("Scaling %s to %dM\n", d.name, suggested)
+	_, err := d.cliConnection.CliCommand("scale", d.name, "-m", fmt.Sprintf("%dM", suggested), "-f")
+	return err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+)
+
+// NetworkConfig sets up container to container traffic between the web app
+// ("web") and its workers, which never has to leave the foundation.
+type NetworkConfig struct {
+	// InternalDomain defaults to apps.internal.
+	InternalDomain string `yaml:"internal_domain,omitempty"`
+	// Internal apps get the route APP.INTERNAL_DOMAIN.
+	Internal []string        `yaml:"internal,omitempty"`
+	Policies []NetworkPolicy `yaml:"policies,omitempty"`
+}
+
+// NetworkPolicy allows From to connect to To on Port.
+type NetworkPolicy struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	// Port defaults to 8080, the port Cloud Foundry apps listen on.
+	Port int `yaml:"port,omitempty"`
+	// Protocol is tcp, the default, or udp.
+	Protocol string `yaml:"protocol,omitempty"`
+}
+
+type networkPolicyEnd struct {
+	ID       string                 `json:"id"`
+	Protocol string                 `json:"protocol,omitempty"`
+	Ports    map[string]interface{} `json:"ports,omitempty"`
+}
+
+// networkApp resolves "web" to the app being deployed; other names are
+// worker apps.
+func (d *deployment) networkApp(name string) string {
+	if name == "web" {
+		return d.name
+	}
+	return name
+}
+
+func (c NetworkConfig) internalDomain() string {
+	if c.InternalDomain == "" {
+		return "apps.internal"
+	}
+	return c.InternalDomain
+}
+
+// network maps the internal routes and adds the network policies. The
+// policy API ignores policies that already exist.
+func (d *deployment) network() error {
+	config := d.config.Network
+	if d.noRoute {
+		return nil
+	}
+	domain := config.internalDomain()
+	for _, name := range config.Internal {
+		app := d.networkApp(name)
+		_, err := d.cliConnection.CliCommand("map-route", app, domain, "--hostname", app)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, policy := range config.Policies {
+		from, err := findApp(d.cliConnection, d.networkApp(policy.From))
+		if err != nil {
+			return err
+		}
+		to, err := findApp(d.cliConnection, d.networkApp(policy.To))
+		if err != nil {
+			return err
+		}
+		if from == nil || to == nil {
+			return fmt.Errorf("network policy %s to %s: both apps have to be deployed", policy.From, policy.To)
+		}
+		port, protocol := policy.Port, policy.Protocol
+		if port == 0 {
+			port = 8080
+		}
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		body := map[string]interface{}{
+			"policies": []map[string]networkPolicyEnd{{
+				"source": {ID: from.GUID},
+				"destination": {
+					ID:       to.GUID,
+					Protocol: protocol,
+					Ports:    map[string]interface{}{"start": port, "end": port},
+				},
+			}},
+		}
+		err = cfCurl(d.cliConnection, "POST", "/networking/v1/external/policies", body, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s can reach %s on %s port %d\n", from.Name, to.Name, protocol, port)
+	}
+	return nil
+}
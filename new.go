@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"code.cloudfoundry.org/cli/plugin"
+	"gopkg.in/yaml.v3"
+)
+
+// newProject goes from nothing to a Sails app linked to Treeline and ready
+// to deploy to Cloud Foundry, optionally deploying it too.
+func newProject(cliConnection plugin.CliConnection, args []string) {
+	flags := flag.NewFlagSet("new", flag.ExitOnError)
+	skipLink := flags.Bool("skip-link", false, "do not run 'treeline link'")
+	deployAfter := flags.Bool("deploy", false, "deploy the app once it is created")
+	presetName := flags.String("preset", "", "pick services, buildpack and size for `PLATFORM`: "+presetNames())
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("Usage: cf treeline new NAME [--skip-link] [--deploy] [--preset PLATFORM]")
+		exitFailed("usage")
+	}
+	if *presetName != "" {
+		if _, err := findPreset(*presetName); err != nil {
+			fail(err)
+		}
+	}
+	name := flags.Arg(0)
+	if _, err := os.Stat(name); err == nil {
+		fmt.Println(name, "already exists")
+		exitFailed("exists")
+	}
+
+	runInteractive("sails", "new", name)
+	err := os.Chdir(name)
+	if err != nil {
+		fail(err)
+	}
+	if !*skipLink {
+		runTreeline([]string{"link"})
+	}
+
+	err = editConfig(func(root *yaml.Node) error {
+		mappingEntry(root, "app", yaml.ScalarNode).Value = name
+		if *presetName != "" {
+			mappingEntry(root, "preset", yaml.ScalarNode).Value = *presetName
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Error writing configuration", err)
+		exitFailed(errorClass(err))
+	}
+	fmt.Println("Updated", configFile)
+	config, err := loadConfig()
+	if err != nil {
+		fail(err)
+	}
+	configPws(config)
+
+	runInteractive("git", "init")
+	runInteractive("git", "add", "-A")
+	runInteractive("git", "commit", "-m", "New Sails app for Treeline on Cloud Foundry")
+
+	if *deployAfter {
+		deploy(cliConnection, config, nil)
+	} else {
+		fmt.Printf("Created %s. Deploy it with 'cd %s && cf treeline deploy'\n", name, name)
+	}
+}
+
+// runInteractive runs a command attached to the terminal, exiting if it
+// fails.
+func runInteractive(name string, args ...string) {
+	cmd := command(name, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		fmt.Printf("Error running %s: %v\n", name, err)
+		exitFailed(errorClass(err))
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// nodeEnv is NODE_ENV from the config's env, development by default, as
+// the generated Sails config lives in config/env/development.js.
+func (c *Config) nodeEnv() string {
+	if value, ok := c.Env["NODE_ENV"]; ok {
+		return value
+	}
+	return "development"
+}
+
+// nodeEnvVars are the variables deploy sets on Node apps and their workers.
+// NPM_CONFIG_PRODUCTION decides whether staging installs devDependencies,
+// whatever NODE_ENV says.
+func (c *Config) nodeEnvVars() map[string]string {
+	env := map[string]string{"NODE_ENV": c.nodeEnv()}
+	if c.PruneDevDependencies != nil {
+		env["NPM_CONFIG_PRODUCTION"] = strconv.FormatBool(*c.PruneDevDependencies)
+	}
+	return env
+}
+
+// checkDevDependencies warns when staging will install devDependencies
+// because NODE_ENV is not production and pruning is not configured.
+func checkDevDependencies(config *Config) {
+	if config.PruneDevDependencies != nil || config.nodeEnv() == "production" {
+		return
+	}
+	data, err := ioutil.ReadFile("package.json")
+	if err != nil {
+		return
+	}
+	var manifest struct {
+		DevDependencies map[string]string `json:"devDependencies"`
+	}
+	if json.Unmarshal(data, &manifest) != nil || len(manifest.DevDependencies) == 0 {
+		return
+	}
+	fmt.Printf("Warning: NODE_ENV is %s, so staging installs the %d devDependencies too, which slows staging and grows the droplet.\n", config.nodeEnv(), len(manifest.DevDependencies))
+	fmt.Printf("Set prune_dev_dependencies: true in %s, for the app or an environment, to leave them out while keeping NODE_ENV.\n", configFile)
+}
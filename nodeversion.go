@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// nodeBuildpackManifest lists the Node versions a nodejs-buildpack release
+// can stage. {ref} is the branch or tag.
+const nodeBuildpackManifest = "https://raw.githubusercontent.com/cloudfoundry/nodejs-buildpack/{ref}/manifest.yml"
+
+// checkNodeVersions fails early when package.json's engines.node, the
+// local Node and the buildpack's Node versions cannot agree, which would
+// otherwise surface as a staging failure.
+func checkNodeVersions(config *Config) error {
+	data, err := ioutil.ReadFile("package.json")
+	if err != nil {
+		return nil
+	}
+	var manifest struct {
+		Engines struct {
+			Node string `json:"node"`
+		} `json:"engines"`
+	}
+	if json.Unmarshal(data, &manifest) != nil {
+		return nil
+	}
+
+	local := ""
+	out, err := command("node", "--version").Output()
+	if err == nil {
+		local = strings.TrimPrefix(strings.TrimSpace(string(out)), "v")
+	}
+	if manifest.Engines.Node == "" {
+		if local != "" {
+			fmt.Printf("package.json has no engines.node, so the buildpack picks its default Node rather than %s you run locally. Add \"engines\": {\"node\": \"%s\"} to pin it.\n", local, local)
+		}
+		return nil
+	}
+	constraint, err := semver.NewConstraint(manifest.Engines.Node)
+	if err != nil {
+		return fmt.Errorf("engines.node %q in package.json is not a valid range: %v", manifest.Engines.Node, err)
+	}
+
+	if local != "" {
+		version, err := semver.NewVersion(local)
+		if err == nil && !constraint.Check(version) {
+			return fmt.Errorf("local Node %s does not satisfy engines.node %q; switch with 'nvm install' or widen the range, so local installs match what is staged", local, manifest.Engines.Node)
+		}
+	}
+
+	if skipOffline("the buildpack Node version check") {
+		return nil
+	}
+	available, err := buildpackNodeVersions(config.finalBuildpack())
+	if err != nil {
+		fmt.Println("Could not read the buildpack's Node versions:", err)
+		return nil
+	}
+	if len(available) == 0 {
+		return nil
+	}
+	for _, version := range available {
+		if constraint.Check(version) {
+			return nil
+		}
+	}
+	var listed []string
+	for _, version := range available {
+		listed = append(listed, version.String())
+	}
+	return fmt.Errorf("engines.node %q matches none of the Node versions the buildpack offers (%s); change the range or set buildpack in %s to a release that has one", manifest.Engines.Node, strings.Join(listed, ", "), configFile)
+}
+
+// buildpackNodeVersions reads the Node versions from the manifest of the
+// configured nodejs-buildpack. Other buildpacks are not checked.
+func buildpackNodeVersions(buildpack string) ([]*semver.Version, error) {
+	ref := "master"
+	switch {
+	case buildpack == "" || buildpack == "nodejs_buildpack":
+	case strings.Contains(buildpack, "github.com/cloudfoundry/nodejs-buildpack"):
+		if hash := strings.Index(buildpack, "#"); hash >= 0 {
+			ref = buildpack[hash+1:]
+		}
+	default:
+		return nil, nil
+	}
+
+	resp, err := httpClient.Get(strings.Replace(nodeBuildpackManifest, "{ref}", ref, 1))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GET manifest.yml: %s", resp.Status)
+	}
+	var manifest struct {
+		Dependencies []struct {
+			Name    string `yaml:"name"`
+			Version string `yaml:"version"`
+		} `yaml:"dependencies"`
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	var versions []*semver.Version
+	for _, dependency := range manifest.Dependencies {
+		if dependency.Name != "node" {
+			continue
+		}
+		version, err := semver.NewVersion(dependency.Version)
+		if err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
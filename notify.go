@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// defaultNotifyAfter is how long a deploy runs before its end is worth a
+// desktop notification.
+const defaultNotifyAfter = time.Minute
+
+// notifyAfter is the configured threshold, or 0 when notifications are off
+// or there is no desktop to show them on.
+func (c *Config) notifyAfter() time.Duration {
+	if os.Getenv("CI") != "" || os.Getenv("TREELINE_CF_NO_NOTIFY") != "" {
+		return 0
+	}
+	switch c.NotifyAfter {
+	case "":
+		return defaultNotifyAfter
+	case "off", "0":
+		return 0
+	}
+	after, err := time.ParseDuration(c.NotifyAfter)
+	if err != nil {
+		fmt.Printf("Ignoring notify_after %q in %s: %v\n", c.NotifyAfter, configFile, err)
+		return defaultNotifyAfter
+	}
+	return after
+}
+
+// notifyDeployDone shows a desktop notification for a deploy that took
+// longer than the threshold.
+func notifyDeployDone(config *Config, name string, took time.Duration, err error) {
+	after := config.notifyAfter()
+	if after == 0 || took < after {
+		return
+	}
+	title := "Deployed " + name
+	message := fmt.Sprintf("Finished in %s", took.Round(time.Second))
+	if err != nil {
+		title = "Deploy of " + name + " failed"
+		message = err.Error()
+	}
+	notifyErr := desktopNotification(title, message)
+	if notifyErr != nil {
+		events.record("notification", map[string]interface{}{"error": notifyErr.Error()})
+	}
+}
+
+func desktopNotification(title, message string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(message), appleScriptString(title))
+		return command("osascript", "-e", script).Run()
+	case "windows":
+		script := `Add-Type -AssemblyName System.Windows.Forms
+$icon = New-Object System.Windows.Forms.NotifyIcon
+$icon.Icon = [System.Drawing.SystemIcons]::Information
+$icon.Visible = $true
+$icon.ShowBalloonTip(10000, $env:TREELINE_CF_TITLE, $env:TREELINE_CF_MESSAGE, 'Info')
+Start-Sleep -Seconds 5
+$icon.Dispose()`
+		cmd := command("powershell", "-NoProfile", "-Command", script)
+		cmd.Env = append(cmd.Env, "TREELINE_CF_TITLE="+title, "TREELINE_CF_MESSAGE="+message)
+		return cmd.Run()
+	default:
+		return command("notify-send", "--app-name=cf treeline", title, message).Run()
+	}
+}
+
+func appleScriptString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
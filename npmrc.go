@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+type NpmConfig struct {
+	// Registry replaces the public npm registry.
+	Registry string `yaml:"registry,omitempty"`
+	// Scopes maps package scopes such as @corp to their registry.
+	Scopes map[string]string `yaml:"scopes,omitempty"`
+	// TokenEnv names the environment variable holding the registry auth
+	// token, NPM_TOKEN by default. Only the reference is written to .npmrc;
+	// the value is set on the app while a deploy stages it and removed
+	// afterwards, so a restage outside a deploy has no token.
+	TokenEnv string `yaml:"token_env,omitempty"`
+}
+
+func (c NpmConfig) enabled() bool {
+	return c.Registry != "" || len(c.Scopes) > 0
+}
+
+func (c NpmConfig) tokenEnv() string {
+	if c.TokenEnv == "" {
+		return "NPM_TOKEN"
+	}
+	return c.TokenEnv
+}
+
+// token is the registry auth token from the environment or the keychain.
+func (c NpmConfig) token() string {
+	return credential("npm", c.tokenEnv())
+}
+
+const npmrcBackup = ".npmrc.treeline-cf-backup"
+
+// writeNpmrc writes an .npmrc for the configured registries, setting aside
+// any .npmrc the project already has. The returned function puts things
+// back the way they were.
+func writeNpmrc(config NpmConfig) (func() error, error) {
+	if !config.enabled() {
+		return func() error { return nil }, nil
+	}
+
+	var npmrc bytes.Buffer
+	registries := []string{}
+	if config.Registry != "" {
+		fmt.Fprintf(&npmrc, "registry=%s\n", config.Registry)
+		registries = append(registries, config.Registry)
+	}
+	var scopes []string
+	for scope := range config.Scopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	for _, scope := range scopes {
+		fmt.Fprintf(&npmrc, "%s:registry=%s\n", scope, config.Scopes[scope])
+		registries = append(registries, config.Scopes[scope])
+	}
+	if token := config.token(); token != "" {
+		// A token from the keychain has to be in the environment for npm
+		// to expand the reference.
+		os.Setenv(config.tokenEnv(), token)
+		for _, registry := range registries {
+			fmt.Fprintf(&npmrc, "%s:_authToken=${%s}\n", registryAuthPrefix(registry), config.tokenEnv())
+		}
+		fmt.Fprintln(&npmrc, "always-auth=true")
+	}
+
+	hadNpmrc := false
+	if _, err := os.Stat(".npmrc"); err == nil {
+		err = os.Rename(".npmrc", npmrcBackup)
+		if err != nil {
+			return nil, err
+		}
+		hadNpmrc = true
+	}
+	err := ioutil.WriteFile(".npmrc", npmrc.Bytes(), 0600)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		if hadNpmrc {
+			return os.Rename(npmrcBackup, ".npmrc")
+		}
+		return os.Remove(".npmrc")
+	}, nil
+}
+
+// registryAuthPrefix turns https://npm.example.com/repo/ into the
+// //npm.example.com/repo/ form npm keys credentials by.
+func registryAuthPrefix(registry string) string {
+	parsed, err := url.Parse(registry)
+	if err != nil || parsed.Host == "" {
+		return "//" + strings.TrimPrefix(registry, "//")
+	}
+	path := parsed.Path
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return "//" + parsed.Host + path
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// offline skips every optional step that needs the internet, for air-gapped
+// machines that can still reach Cloud Foundry. It is set by --offline or
+// TREELINE_CF_OFFLINE.
+var offline = os.Getenv("TREELINE_CF_OFFLINE") != ""
+
+// parseGlobalFlags removes the flags every command accepts from args.
+func parseGlobalFlags(args []string) []string {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--project-dir" && i+1 < len(args) {
+			projectDir = args[i+1]
+			i++
+			continue
+		}
+		if strings.HasPrefix(arg, "--project-dir=") {
+			projectDir = strings.TrimPrefix(arg, "--project-dir=")
+			continue
+		}
+		if arg == "--offline" {
+			offline = true
+			continue
+		}
+		if arg == "--install-missing" {
+			installMissing = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest
+}
+
+// skipOffline reports whether step should be skipped because the plugin is
+// offline, saying so when it is.
+func skipOffline(step string) bool {
+	if offline {
+		fmt.Printf("Offline, skipping %s\n", step)
+		events.record("offline-skipped", map[string]interface{}{"step": step})
+	}
+	return offline
+}
+
+// requireVendored checks that every dependency in package.json, plus extra
+// packages ("name" or "name@version"), is already in node_modules, since
+// installing them would need the registry.
+func requireVendored(extra []string) error {
+	names := []string{}
+	data, err := ioutil.ReadFile("package.json")
+	if err == nil {
+		var manifest struct {
+			Dependencies map[string]string `json:"dependencies"`
+		}
+		err = json.Unmarshal(data, &manifest)
+		if err != nil {
+			return fmt.Errorf("could not parse package.json: %v", err)
+		}
+		for name := range manifest.Dependencies {
+			names = append(names, name)
+		}
+	}
+	for _, pkg := range extra {
+		if at := strings.LastIndex(pkg, "@"); at > 0 {
+			pkg = pkg[:at]
+		}
+		names = append(names, pkg)
+	}
+	sort.Strings(names)
+
+	var missing []string
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if _, err := os.Stat(filepath.Join("node_modules", name, "package.json")); err != nil {
+			missing = append(missing, "npm install "+name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("offline, but these need network access because the packages are not vendored in node_modules:\n  %s", strings.Join(missing, "\n  "))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// cfPassthrough runs a cf command from a runbook against the project: the
+// configured org and space are targeted first, and {app}, {org} and {space}
+// in its arguments are replaced.
+func cfPassthrough(cliConnection plugin.CliConnection, config *Config, args []string) {
+	flags := flag.NewFlagSet("cf", flag.ExitOnError)
+	env := flags.String("env", "", "use the environment `NAME` from the config's environments")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Println("Usage: cf treeline cf [--env NAME] -- COMMAND [ARGS...]")
+		exitFailed("usage")
+	}
+
+	err := config.useEnvironment(*env)
+	if err == nil {
+		err = config.targetSpace(cliConnection)
+	}
+	if err != nil {
+		fail(err)
+	}
+	org, err := cliConnection.GetCurrentOrg()
+	if err != nil {
+		fail(err)
+	}
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil {
+		fail(err)
+	}
+	placeholders := strings.NewReplacer("{app}", config.App, "{org}", org.Name, "{space}", space.Name)
+	var cfArgs []string
+	for _, arg := range flags.Args() {
+		cfArgs = append(cfArgs, placeholders.Replace(arg))
+	}
+
+	fmt.Printf("Running cf %s in %s/%s\n", strings.Join(cfArgs, " "), org.Name, space.Name)
+	events.record("cf", map[string]interface{}{"args": cfArgs})
+	// The cf binary is run rather than CliCommand, so commands like ssh get
+	// the terminal.
+	cmd := command("cf", cfArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		fmt.Println("Could not run cf:", err)
+		exitFailed(errorClass(err))
+	}
+}
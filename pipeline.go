@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+)
+
+// The deploy pipeline is deploySteps, run in order. Code built into the
+// plugin can extend it from an init function, for example a compliance
+// check in its own file:
+//
+//	func init() {
+//		registerStep(deployStep{"compliance", checkCompliance}, "push")
+//		beforeStep("start", requireChangeTicket)
+//	}
+
+// A stepHook runs before or after a step. An error fails the step.
+type stepHook func(d *deployment, step string) error
+
+// allSteps registers a hook around every step.
+const allSteps = "*"
+
+var beforeHooks = map[string][]stepHook{}
+var afterHooks = map[string][]stepHook{}
+
+// registerStep inserts step into the pipeline after the step named after,
+// or first when after is "". It panics on an unknown or duplicate name,
+// which is a programming error found the first time the plugin runs.
+func registerStep(step deployStep, after string) {
+	position := -1
+	for i, existing := range deploySteps {
+		if existing.name == step.name {
+			panic(fmt.Sprintf("deploy step %q is already registered", step.name))
+		}
+		if existing.name == after {
+			position = i
+		}
+	}
+	if after != "" && position < 0 {
+		panic(fmt.Sprintf("cannot register %q after unknown deploy step %q", step.name, after))
+	}
+	steps := append([]deployStep{}, deploySteps[:position+1]...)
+	steps = append(steps, step)
+	deploySteps = append(steps, deploySteps[position+1:]...)
+}
+
+// beforeStep registers hook to run before the named step, or every step
+// with allSteps.
+func beforeStep(step string, hook stepHook) {
+	beforeHooks[step] = append(beforeHooks[step], hook)
+}
+
+// afterStep registers hook to run after the named step succeeds, or every
+// step with allSteps.
+func afterStep(step string, hook stepHook) {
+	afterHooks[step] = append(afterHooks[step], hook)
+}
+
+// runStep runs the step between its hooks.
+func (d *deployment) runStep(step deployStep) error {
+	for _, hook := range append(append([]stepHook{}, beforeHooks[allSteps]...), beforeHooks[step.name]...) {
+		err := hook(d, step.name)
+		if err != nil {
+			return err
+		}
+	}
+	err := step.run(d)
+	if err != nil {
+		return err
+	}
+	for _, hook := range append(append([]stepHook{}, afterHooks[step.name]...), afterHooks[allSteps]...) {
+		err = hook(d, step.name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// A plan lists the changes a deploy or apply makes to the live app, for
+// review tooling with --plan --json.
+type plan struct {
+	App         string       `json:"app"`
+	Environment string       `json:"environment,omitempty"`
+	Exists      bool         `json:"exists"`
+	Changes     []planChange `json:"changes"`
+}
+
+type planChange struct {
+	Action   string `json:"action"`
+	Resource string `json:"resource"`
+	Name     string `json:"name,omitempty"`
+	Detail   string `json:"detail"`
+	// Command is the cf command that makes the change, empty when only a
+	// redeploy can.
+	Command []string `json:"command,omitempty"`
+}
+
+// newPlan hides the values set-env commands would set, as env show does.
+func newPlan(config *Config, exists bool, drifts []drift) plan {
+	p := plan{App: config.App, Environment: config.Environment, Exists: exists, Changes: []planChange{}}
+	for _, d := range drifts {
+		command := d.fix
+		if len(command) > 3 && command[0] == "set-env" {
+			command = append([]string(nil), command...)
+			command[3] = envValue(command[2], command[3], false)
+		}
+		p.Changes = append(p.Changes, planChange{d.action, d.resource, d.name, d.detail, command})
+	}
+	return p
+}
+
+// printPlan lists the drifts and counts them like terraform does.
+func printPlan(drifts []drift) {
+	counts := map[string]int{}
+	for _, d := range drifts {
+		fmt.Println(d.description())
+		counts[d.action]++
+	}
+	fmt.Printf("\nPlan: %d to add, %d to change, %d to remove.\n", counts["create"], counts["update"], counts["delete"])
+}
+
+func printPlanJSON(p plan) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(p)
+}
+
+// deployPlan is what deploying config as the app name changes besides the
+// code. It only has what the deploy steps change, everything when the app is
+// new: deploy binds the declared services and maps the declared routes but
+// leaves the others for 'cf treeline diff --apply' to remove.
+func deployPlan(cliConnection plugin.CliConnection, config *Config, name string) (plan, []drift, error) {
+	target := *config
+	target.App = name
+	app, err := findApp(cliConnection, name)
+	if err != nil {
+		return plan{}, nil, err
+	}
+	if app == nil {
+		drifts := newAppDrifts(cliConnection, &target)
+		return newPlan(&target, false, drifts), drifts, nil
+	}
+	if onKorifi(cliConnection) {
+		// configDrift reads the app through the v2 API.
+		drifts := []drift{{"update", "app", name, "will be redeployed with the config; Korifi has no v2 API to compare its settings with", nil}}
+		return newPlan(&target, true, drifts), drifts, nil
+	}
+	all, err := configDrift(cliConnection, &target)
+	if err != nil {
+		return plan{}, nil, err
+	}
+	var drifts []drift
+	for _, d := range all {
+		if d.action == "delete" && (d.resource == "binding" || d.resource == "route") {
+			continue
+		}
+		drifts = append(drifts, d)
+	}
+	return newPlan(&target, true, drifts), drifts, nil
+}
+
+// newAppDrifts is what the first deploy of config.App creates.
+func newAppDrifts(cliConnection plugin.CliConnection, config *Config) []drift {
+	name := config.App
+	drifts := []drift{{"create", "app", name, "will be created", nil}}
+	d := newDeployment(cliConnection, config, name)
+	if config.Assets.enabled() {
+		// Without a CDN the URL is the bucket's, known once it exists.
+		d.assetsURL = config.Assets.CDNURL
+		if d.assetsURL == "" {
+			drifts = append(drifts, drift{"create", "env", "ASSETS_URL", "will be set to the assets bucket", nil})
+		}
+	}
+	env := d.desiredEnv()
+	for _, key := range sortedKeys(env) {
+		drifts = append(drifts, drift{"create", "env", key, "will be set", []string{"set-env", name, key, env[key]}})
+	}
+	for _, service := range config.Services {
+		drifts = append(drifts, drift{"create", "binding", service.Name, "will be bound", nil})
+	}
+	for _, route := range config.Routes {
+		drifts = append(drifts, drift{"create", "route", route.String(), "will be mapped", route.mapArgs(name)})
+	}
+	return drifts
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// preset holds what suits one kind of platform: the marketplace offerings
+// for each service type, the buildpack and the app's size. Anything the
+// config or service-catalog.yml sets wins over the preset.
+type preset struct {
+	buildpack string
+	stack     string
+	memory    string
+	instances int
+	catalog   map[string]catalogEntry
+}
+
+// presets are chosen with 'cf treeline new --preset' or preset: in the
+// config. An empty catalog entry makes the type a user-provided service,
+// for platforms without a matching offering.
+var presets = map[string]preset{
+	"pws-free": {
+		buildpack: "nodejs_buildpack",
+		memory:    "512M",
+		catalog: map[string]catalogEntry{
+			"mysql":    {Service: "cleardb", Plan: "spark"},
+			"postgres": {Service: "elephantsql", Plan: "turtle"},
+			"redis":    {Service: "rediscloud", Plan: "30mb"},
+			"email":    {Service: "sendgrid", Plan: "free"},
+		},
+	},
+	"pws-paid": {
+		buildpack: "nodejs_buildpack",
+		memory:    "1G",
+		instances: 2,
+		catalog: map[string]catalogEntry{
+			"mysql":    {Service: "cleardb", Plan: "boost"},
+			"postgres": {Service: "elephantsql", Plan: "panda"},
+			"redis":    {Service: "rediscloud", Plan: "100mb"},
+			"email":    {Service: "sendgrid", Plan: "bronze"},
+		},
+	},
+	// Korifi stages with Cloud Native Buildpacks and has no marketplace of
+	// its own, so services are created with 'cf cups' and only bound.
+	"korifi": {
+		buildpack: "paketo-buildpacks/nodejs",
+		memory:    "1G",
+		catalog: map[string]catalogEntry{
+			"mysql":    {},
+			"postgres": {},
+			"redis":    {},
+			"email":    {},
+		},
+	},
+	"tanzu": {
+		buildpack: "nodejs_buildpack",
+		stack:     "cflinuxfs4",
+		memory:    "1G",
+		instances: 2,
+		catalog: map[string]catalogEntry{
+			"mysql":    {Service: "p.mysql", Plan: "db-small"},
+			"postgres": {Service: "postgres", Plan: "on-demand-postgres-small"},
+			"redis":    {Service: "p.redis", Plan: "cache-small"},
+			"email":    {},
+		},
+	},
+}
+
+func presetNames() string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func findPreset(name string) (preset, error) {
+	chosen, ok := presets[name]
+	if !ok {
+		return preset{}, fmt.Errorf("unknown preset %q, use one of %s", name, presetNames())
+	}
+	return chosen, nil
+}
+
+// usePreset fills in what the config leaves unset from its preset.
+func (c *Config) usePreset() error {
+	if c.Preset == "" {
+		return nil
+	}
+	chosen, err := findPreset(c.Preset)
+	if err != nil {
+		return fmt.Errorf("%s: %v", configFile, err)
+	}
+	if c.Buildpack == "" && len(c.Buildpacks) == 0 {
+		c.Buildpack = chosen.buildpack
+	}
+	if c.Stack == "" {
+		c.Stack = chosen.stack
+	}
+	if c.Memory == "" {
+		c.Memory = chosen.memory
+	}
+	if c.Instances == 0 {
+		c.Instances = chosen.instances
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// projectMarkers recognise the projects Cloud Foundry has buildpacks for,
+// in the order they are tried, with the buildpack to suggest.
+var projectMarkers = []struct {
+	kind      string
+	files     []string
+	buildpack string
+}{
+	{"go", []string{"go.mod", "Godeps/Godeps.json"}, "go_buildpack"},
+	{"python", []string{"requirements.txt", "Pipfile", "setup.py"}, "python_buildpack"},
+	{"ruby", []string{"Gemfile"}, "ruby_buildpack"},
+	{"java", []string{"pom.xml", "build.gradle"}, "java_buildpack"},
+	{"php", []string{"composer.json"}, "php_buildpack"},
+	{"staticfile", []string{"Staticfile", "index.html"}, "staticfile_buildpack"},
+}
+
+// detectProject returns sails, node or the kind of the other marker found,
+// or "" when nothing is recognised.
+func detectProject() (kind, buildpack string) {
+	if data, err := ioutil.ReadFile("package.json"); err == nil {
+		var manifest struct {
+			Dependencies map[string]string `json:"dependencies"`
+		}
+		if json.Unmarshal(data, &manifest) == nil && manifest.Dependencies["sails"] != "" {
+			return "sails", "nodejs_buildpack"
+		}
+		return "node", "nodejs_buildpack"
+	}
+	for _, marker := range projectMarkers {
+		for _, file := range marker.files {
+			if _, err := os.Stat(filepath.FromSlash(file)); err == nil {
+				return marker.kind, marker.buildpack
+			}
+		}
+	}
+	return "", ""
+}
+
+// checkProjectType refuses to treat a project as a Node app when it is not
+// one, unless the config opts into the generic deploy with type: generic.
+func (c *Config) checkProjectType() error {
+	switch c.Type {
+	case "generic":
+		return nil
+	case "", "sails", "node":
+	default:
+		return fmt.Errorf("unknown type %q in %s, use sails, node or generic", c.Type, configFile)
+	}
+	kind, buildpack := detectProject()
+	switch kind {
+	case "sails", "node":
+		return nil
+	case "":
+		return fmt.Errorf("no package.json here, so this is not a Node or Sails app; to deploy it anyway set type: generic and a buildpack in %s", configFile)
+	}
+	return fmt.Errorf("this looks like a %s project, not a Node or Sails app; to deploy it with %s set type: generic and buildpack: %s in %s", kind, buildpack, buildpack, configFile)
+}
+
+// nodeProject reports whether Node specific steps such as npm installs,
+// audits and NODE_ENV apply.
+func (c *Config) nodeProject() bool {
+	return c.Type != "generic"
+}
+
+// sailsLayout is the minimal Sails project config-pws writes into, with what
+// to scaffold for each missing path; directories end in a slash.
+var sailsLayout = []struct {
+	path     string
+	scaffold []byte
+}{
+	{"app.js", []byte("process.chdir(__dirname);\nrequire('sails').lift(require('rc')('sails'));\n")},
+	{"api/controllers/", nil},
+	{"api/models/", nil},
+	{"config/env/", nil},
+}
+
+// checkSailsProject aborts when the directory is not a Sails project, and
+// offers to scaffold the paths missing from one, without asking when
+// scaffold is set.
+func checkSailsProject(scaffold bool) error {
+	if kind, _ := detectProject(); kind != "sails" {
+		return fmt.Errorf("this doesn't look like a Sails project: there is no package.json with a sails dependency in %s; run config-pws from the project root, or create one with 'cf treeline new'", currentDir())
+	}
+	var missing []string
+	absent := map[string]bool{}
+	for _, entry := range sailsLayout {
+		if _, err := os.Stat(filepath.FromSlash(entry.path)); os.IsNotExist(err) {
+			missing = append(missing, entry.path)
+			absent[entry.path] = true
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	fmt.Println("This Sails project is missing", strings.Join(missing, ", "))
+	if !scaffold {
+		fmt.Print("Create them? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+			return fmt.Errorf("this doesn't look like a complete Sails project, missing %s; rerun with --scaffold to create them", strings.Join(missing, ", "))
+		}
+	}
+	for _, entry := range sailsLayout {
+		if !absent[entry.path] {
+			continue
+		}
+		var err error
+		if strings.HasSuffix(entry.path, "/") {
+			err = os.MkdirAll(filepath.FromSlash(entry.path), 0755)
+		} else {
+			err = writeGenerated(filepath.FromSlash(entry.path), entry.scaffold)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("Created", entry.path)
+	}
+	return nil
+}
+
+func currentDir() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "the current directory"
+	}
+	return dir
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyConfig overrides the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment
+// variables for the plugin and the npm and treeline processes it runs.
+type ProxyConfig struct {
+	HTTPS   string `yaml:"https,omitempty"`
+	HTTP    string `yaml:"http,omitempty"`
+	NoProxy string `yaml:"no_proxy,omitempty"`
+}
+
+var proxySettings = httpproxy.FromEnvironment()
+
+var httpClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return proxySettings.ProxyFunc()(req.URL)
+		},
+	},
+}
+
+// useProxy applies the configured proxy on top of the environment's.
+func useProxy(config ProxyConfig) {
+	if config.HTTPS != "" {
+		proxySettings.HTTPSProxy = config.HTTPS
+	}
+	if config.HTTP != "" {
+		proxySettings.HTTPProxy = config.HTTP
+	}
+	if config.NoProxy != "" {
+		proxySettings.NoProxy = config.NoProxy
+	}
+}
+
+// command is exec.Command with the proxy passed on in the variables both
+// npm and other tools read, running the configured node, npm or treeline
+// when name is one of them.
+func command(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(toolPath(name), args...)
+	cmd.Env = append(append(os.Environ(), proxyEnv()...), toolPathEnv()...)
+	return cmd
+}
+
+func proxyEnv() []string {
+	var env []string
+	if proxySettings.HTTPSProxy != "" {
+		env = append(env,
+			"HTTPS_PROXY="+proxySettings.HTTPSProxy,
+			"https_proxy="+proxySettings.HTTPSProxy,
+			"npm_config_https_proxy="+proxySettings.HTTPSProxy)
+	}
+	if proxySettings.HTTPProxy != "" {
+		env = append(env,
+			"HTTP_PROXY="+proxySettings.HTTPProxy,
+			"http_proxy="+proxySettings.HTTPProxy,
+			"npm_config_proxy="+proxySettings.HTTPProxy)
+	}
+	if proxySettings.NoProxy != "" {
+		env = append(env,
+			"NO_PROXY="+proxySettings.NoProxy,
+			"no_proxy="+proxySettings.NoProxy,
+			"npm_config_noproxy="+proxySettings.NoProxy)
+	}
+	return env
+}
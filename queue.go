@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/SocalNick/cf-treeline-cli/treelinecf"
+	"gopkg.in/yaml.v3"
+)
+
+// WorkerConfig is a second app pushed from the same code to process
+// background jobs. It gets the web app's services and env.
+type WorkerConfig struct {
+	Name      string `yaml:"name"`
+	Command   string `yaml:"command"`
+	Instances int    `yaml:"instances,omitempty"`
+	Memory    string `yaml:"memory,omitempty"`
+	// DependsOn names the workers, or the app, deployed before this one.
+	DependsOn []string `yaml:"depends_on,omitempty"`
+}
+
+// defaultWorkerParallelism is how many workers deploy at once unless the
+// config's worker_parallelism says otherwise.
+const defaultWorkerParallelism = 4
+
+func (c *Config) workerParallelism() int {
+	if c.WorkerParallelism > 0 {
+		return c.WorkerParallelism
+	}
+	return defaultWorkerParallelism
+}
+
+var queueLibraries = map[string]string{
+	"bull": "bull",
+	"kue":  "kue",
+}
+
+var queueTemplate = template.Must(template.New("queue").Parse(`/**
+ * Job queue connection, generated by 'cf treeline config-pws --queue {{.Library}}'
+ */
+
+var redis = { host: '127.0.0.1', port: 6379 };
+
+if (process.env.VCAP_SERVICES) {
+  var credentials = require('../lib/vcap').credentials({{.Redis}});
+  redis = {
+    host: credentials.hostname,
+    port: credentials.port,
+    password: credentials.password
+  };
+}
+
+module.exports.queue = {
+  library: '{{.Library}}',
+  name: 'default',
+  redis: redis
+};
+`))
+
+var workerTemplates = map[string]string{
+	"bull": `/**
+ * Example worker, deployed as its own app by 'cf treeline deploy'.
+ * Add jobs from Sails with:
+ *   new Queue(sails.config.queue.name, { redis: sails.config.queue.redis }).add({ ... })
+ */
+
+var Queue = require('bull');
+var config = require('./config/queue').queue;
+
+var queue = new Queue(config.name, { redis: config.redis });
+
+queue.process(function (job, done) {
+  console.log('Processing job', job.id, JSON.stringify(job.data));
+  done();
+});
+
+console.log('Worker waiting for jobs on', config.name);
+`,
+	"kue": `/**
+ * Example worker, deployed as its own app by 'cf treeline deploy'.
+ * Add jobs from Sails with:
+ *   require('kue').createQueue({ redis: sails.config.queue.redis }).create(sails.config.queue.name, { ... }).save()
+ */
+
+var kue = require('kue');
+var config = require('./config/queue').queue;
+
+var queue = kue.createQueue({
+  redis: { host: config.redis.host, port: config.redis.port, auth: config.redis.password }
+});
+
+queue.process(config.name, function (job, done) {
+  console.log('Processing job', job.id, JSON.stringify(job.data));
+  done();
+});
+
+console.log('Worker waiting for jobs on', config.name);
+`,
+}
+
+// configureQueue generates the queue connection config and an example
+// worker, installs the queue library and adds the worker app to the config.
+func configureQueue(config *Config, library string) error {
+	pkg, ok := queueLibraries[library]
+	if !ok {
+		return fmt.Errorf("unknown queue library %q, use bull or kue", library)
+	}
+
+	services := servicesForSails(config)
+	var queueConfig bytes.Buffer
+	err := queueTemplate.Execute(&queueConfig, map[string]string{
+		"Library": library,
+		"Redis":   treelinecf.VCAPLookup(services.RedisBinding, services.RedisName, services.Redis, "redis"),
+	})
+	if err != nil {
+		return err
+	}
+	err = writeGenerated("config/queue.js", queueConfig.Bytes())
+	if err != nil {
+		return err
+	}
+	fmt.Println("Updated config/queue.js")
+
+	if _, err := os.Stat("worker.js"); os.IsNotExist(err) {
+		err = writeGenerated("worker.js", []byte(workerTemplates[library]))
+		if err != nil {
+			return err
+		}
+		fmt.Println("Created worker.js")
+	}
+
+	if !offline {
+		npmSetup := command("npm", "install", pkg, "--save")
+		npmSetup.Stdout = os.Stdout
+		err = npmSetup.Run()
+		if err != nil {
+			fmt.Println("Error installing npm packages", err)
+		}
+	}
+
+	worker := config.App + "-worker"
+	for _, existing := range config.Workers {
+		if existing.Name == worker {
+			return nil
+		}
+	}
+	err = editConfig(func(root *yaml.Node) error {
+		workers := mappingEntry(root, "workers", yaml.SequenceNode)
+		node := &yaml.Node{}
+		err := node.Encode(WorkerConfig{Name: worker, Command: "node worker.js", Instances: 1})
+		workers.Content = append(workers.Content, node)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added the %s worker app to %s\n", worker, configFile)
+	return nil
+}
+
+// deployWorkers pushes the worker apps with the web app's services and env,
+// up to parallel at once, each after the workers it depends on.
+func (d *deployment) deployWorkers() error {
+	workers := d.config.Workers
+	err := checkWorkerDependencies(d.config.App, workers)
+	if err != nil {
+		return err
+	}
+	done := map[string]chan struct{}{}
+	for _, worker := range workers {
+		done[worker.Name] = make(chan struct{})
+	}
+	slots := make(chan struct{}, d.config.workerParallelism())
+	errs := make([]error, len(workers))
+	var mutex, cli sync.Mutex
+	failed := map[string]bool{}
+	var wg sync.WaitGroup
+	for i, worker := range workers {
+		wg.Add(1)
+		go func(i int, worker WorkerConfig) {
+			defer wg.Done()
+			defer close(done[worker.Name])
+			for _, dependency := range worker.DependsOn {
+				if dependency == d.config.App {
+					continue
+				}
+				<-done[dependency]
+				mutex.Lock()
+				dependencyFailed := failed[dependency]
+				mutex.Unlock()
+				if dependencyFailed {
+					errs[i] = fmt.Errorf("worker %s not deployed, as %s failed", worker.Name, dependency)
+					mutex.Lock()
+					failed[worker.Name] = true
+					mutex.Unlock()
+					return
+				}
+			}
+			slots <- struct{}{}
+			err := d.deployWorker(worker, &cli)
+			<-slots
+			if err != nil {
+				errs[i] = fmt.Errorf("worker %s: %v", worker.Name, err)
+				mutex.Lock()
+				failed[worker.Name] = true
+				mutex.Unlock()
+			}
+		}(i, worker)
+	}
+	wg.Wait()
+	var messages []string
+	for _, err := range errs {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return fmt.Errorf("%s", strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// checkWorkerDependencies rejects dependencies on unknown apps and cycles,
+// which would leave deployWorkers waiting forever.
+func checkWorkerDependencies(app string, workers []WorkerConfig) error {
+	byName := map[string]WorkerConfig{}
+	for _, worker := range workers {
+		byName[worker.Name] = worker
+	}
+	for _, worker := range workers {
+		for _, dependency := range worker.DependsOn {
+			if _, ok := byName[dependency]; !ok && dependency != app {
+				return fmt.Errorf("worker %s depends on %s, which is not a worker or the app", worker.Name, dependency)
+			}
+		}
+	}
+	// visiting holds the workers on the current path, visited those
+	// already checked.
+	visiting := map[string]bool{}
+	visited := map[string]bool{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		if visiting[name] {
+			return fmt.Errorf("workers depend on each other in a cycle: %s", strings.Join(append(path, name), " -> "))
+		}
+		if visited[name] {
+			return nil
+		}
+		visiting[name] = true
+		for _, dependency := range byName[name].DependsOn {
+			if dependency == app {
+				continue
+			}
+			err := visit(dependency, append(path, name))
+			if err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		visited[name] = true
+		return nil
+	}
+	for _, worker := range workers {
+		err := visit(worker.Name, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deployWorker pushes one worker app. Workers do not listen on a port, so
+// they get no route and a process health check. The push and restart run in
+// cf processes of their own so workers deploy in parallel, while the calls
+// through the plugin connection, which takes one at a time, hold cli.
+func (d *deployment) deployWorker(worker WorkerConfig, cli *sync.Mutex) error {
+	fmt.Println("Deploying worker", worker.Name)
+	app := treelinecf.App{
+		Name:        worker.Name,
+		Path:        d.path,
+		Memory:      worker.Memory,
+		Instances:   worker.Instances,
+		Buildpacks:  d.config.buildpacks(),
+		Stack:       d.config.Stack,
+		Command:     worker.Command,
+		HealthCheck: "process",
+		NoRoute:     true,
+	}
+	err := runCf(app.PushArgs()...)
+	if err != nil {
+		return err
+	}
+	cli.Lock()
+	err = d.applyEnv(worker.Name, d.desiredEnv())
+	if err == nil {
+		_, err = createServices(d.cliConnection, worker.Name, d.config.Services)
+	}
+	if err == nil {
+		err = labelApp(d.cliConnection, worker.Name, resourceLabels(d.config))
+	}
+	cli.Unlock()
+	if err != nil {
+		return err
+	}
+	return runCf("restart", worker.Name)
+}
+
+// runCf runs a cf command in its own process and records it in the event
+// log as loggedConnection would.
+func runCf(args ...string) error {
+	cmd := command("cf", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	recordCommand(args, nil, err)
+	return err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// stagingEnv matches the variables buildpacks read while staging, so
+// changing them needs a restage rather than a restart.
+var stagingEnv = regexp.MustCompile(`^(NODE_ENV|NODE_VERSION|NODE_MODULES_CACHE|NPM_CONFIG_|YARN_|BP_|APT_)`)
+
+// pendingRestart collects the changes to an app that only take effect when
+// it restarts, or for staging variables, restages.
+type pendingRestart struct {
+	restart bool
+	restage bool
+	reasons []string
+}
+
+func (p *pendingRestart) envChanged(key string) {
+	p.restart = true
+	if stagingEnv.MatchString(key) {
+		p.restage = true
+		p.reasons = append(p.reasons, key+" is read while staging")
+	}
+}
+
+func (p *pendingRestart) bindingChanged(service string) {
+	p.restart = true
+	p.reasons = append(p.reasons, "the binding to "+service+" changed")
+}
+
+// command is the cf command that makes the changes take effect, or "" when
+// none is needed.
+func (p *pendingRestart) command() string {
+	switch {
+	case p.restage:
+		return "restage"
+	case p.restart:
+		return "restart"
+	}
+	return ""
+}
+
+// apply restarts or restages the app when something changed and it is
+// running; a stopped app picks the changes up when it starts.
+func (p *pendingRestart) apply(cliConnection plugin.CliConnection, name string) error {
+	command := p.command()
+	if command == "" {
+		return nil
+	}
+	app, err := findApp(cliConnection, name)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("app %s not found", name)
+	}
+	if !strings.EqualFold(app.State, "started") {
+		fmt.Printf("%s is stopped, so the changes take effect when it starts\n", name)
+		return nil
+	}
+	if p.restage {
+		fmt.Printf("Restaging %s, as %s\n", name, strings.Join(p.reasons, " and "))
+	} else {
+		fmt.Printf("Restarting %s for the changes to take effect; none of them needs a restage\n", name)
+	}
+	_, err = cliConnection.CliCommand(command, name)
+	return err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// invocationDir is where the plugin was run from, before it moved to the
+// project root.
+var invocationDir string
+
+// projectDir is the app's directory inside a larger repository, relative to
+// where the plugin is run. It is set by --project-dir or
+// TREELINE_CF_PROJECT_DIR and replaces the search for the project root.
+var projectDir = os.Getenv("TREELINE_CF_PROJECT_DIR")
+
+// findProjectRoot walks up from dir to the nearest directory holding
+// .treeline-cf.yml, or failing that package.json, without leaving the git
+// repository dir is in. It returns "" when there is none.
+func findProjectRoot(dir string) string {
+	packageDir := ""
+	for {
+		if _, err := os.Stat(filepath.Join(dir, configFile)); err == nil {
+			return dir
+		}
+		if _, err := os.Stat(filepath.Join(dir, "package.json")); err == nil && packageDir == "" {
+			packageDir = dir
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return packageDir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return packageDir
+		}
+		dir = parent
+	}
+}
+
+// useProjectRoot changes to the project root, so commands run from
+// anywhere inside the project read its config and push all of it.
+func useProjectRoot() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	invocationDir = dir
+	if projectDir != "" {
+		info, err := os.Stat(userPath(projectDir))
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("--project-dir %s is not a directory", projectDir)
+		}
+		return os.Chdir(userPath(projectDir))
+	}
+	root := findProjectRoot(dir)
+	if root == "" || root == dir {
+		return nil
+	}
+	fmt.Println("Using the project in", root)
+	return os.Chdir(root)
+}
+
+// userPath resolves a relative path the user passed against the directory
+// they ran the plugin in rather than the project root.
+func userPath(path string) string {
+	if path == "" || filepath.IsAbs(path) || invocationDir == "" {
+		return path
+	}
+	return filepath.Join(invocationDir, path)
+}
+
+// gitRoot returns the root of the git repository the current directory is
+// in, or "".
+func gitRoot() string {
+	out, err := command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return ""
+	}
+	return filepath.Clean(strings.TrimSpace(string(out)))
+}
+
+// writeCfignore gives a project without a .cfignore one that follows its
+// .gitignore. cf push only reads the .cfignore of the directory it pushes,
+// so for a project in a subdirectory of its repository the repository's
+// .gitignore is copied in as well, instead of linking to the project's.
+func writeCfignore() error {
+	if _, err := os.Lstat(".cfignore"); !os.IsNotExist(err) {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	root := gitRoot()
+	rootIgnore, err := ioutil.ReadFile(filepath.Join(root, ".gitignore"))
+	if root == "" || root == filepath.Clean(dir) || err != nil {
+		return os.Symlink(".gitignore", ".cfignore")
+	}
+	local, err := ioutil.ReadFile(".gitignore")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	rel, _ := filepath.Rel(dir, root)
+	var cfignore strings.Builder
+	fmt.Fprintf(&cfignore, "# From %s, written by 'cf treeline config-pws'\n", filepath.ToSlash(filepath.Join(rel, ".gitignore")))
+	cfignore.Write(rootIgnore)
+	if len(local) > 0 {
+		fmt.Fprintln(&cfignore, "\n# From .gitignore")
+		cfignore.Write(local)
+	}
+	return writeGenerated(".cfignore", []byte(cfignore.String()))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+type v3Domain struct {
+	GUID          string `json:"guid"`
+	Name          string `json:"name"`
+	Internal      bool   `json:"internal"`
+	Relationships struct {
+		Organization struct {
+			Data *struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		} `json:"organization"`
+	} `json:"relationships"`
+}
+
+func (d v3Domain) shared() bool {
+	return d.Relationships.Organization.Data == nil
+}
+
+// orgDomains returns the domains the targeted org can use, shared ones
+// included.
+func orgDomains(cliConnection plugin.CliConnection) ([]v3Domain, error) {
+	org, err := cliConnection.GetCurrentOrg()
+	if err != nil {
+		return nil, err
+	}
+	var domains struct {
+		Resources []v3Domain `json:"resources"`
+	}
+	path := "/v3/organizations/" + org.Guid + "/domains?per_page=5000"
+	if onKorifi(cliConnection) {
+		// Korifi only lists domains as a whole; they are all shared.
+		path = "/v3/domains?per_page=5000"
+	}
+	err = cfCurl(cliConnection, "GET", path, nil, &domains)
+	return domains.Resources, err
+}
+
+// checkRoute makes sure the route can be mapped to the app before cf tries:
+// its domain is in the org, created as a private domain when the route asks
+// for it, and no other space holds the route. DNS that does not point at
+// the foundation yet is only reported, as the route can be mapped first.
+func checkRoute(cliConnection plugin.CliConnection, app string, route RouteConfig) error {
+	domains, err := orgDomains(cliConnection)
+	if err != nil {
+		return err
+	}
+	var domain *v3Domain
+	var sharedDomain string
+	for i, candidate := range domains {
+		if candidate.Name == route.Domain {
+			domain = &domains[i]
+		}
+		if sharedDomain == "" && candidate.shared() && !candidate.Internal {
+			sharedDomain = candidate.Name
+		}
+	}
+	if domain == nil {
+		if onKorifi(cliConnection) {
+			return fmt.Errorf("domain %s does not exist; on Korifi the cluster admin creates domains, with their TLS secret, as CFDomain resources", route.Domain)
+		}
+		if !route.CreateDomain {
+			return fmt.Errorf("domain %s is not in the org; create it with 'cf create-private-domain' or set create_domain: true on the route", route.Domain)
+		}
+		org, err := cliConnection.GetCurrentOrg()
+		if err != nil {
+			return err
+		}
+		_, err = cliConnection.CliCommand("create-private-domain", org.Name, route.Domain)
+		if err != nil {
+			return fmt.Errorf("could not create the private domain %s: %v", route.Domain, err)
+		}
+		return checkDNS(route, app, sharedDomain)
+	}
+
+	query := url.Values{"domain_guids": {domain.GUID}, "hosts": {route.Hostname}, "paths": {route.Path}}
+	var routes struct {
+		Resources []struct {
+			Relationships struct {
+				Space struct {
+					Data struct {
+						GUID string `json:"guid"`
+					} `json:"data"`
+				} `json:"space"`
+			} `json:"relationships"`
+		} `json:"resources"`
+	}
+	err = cfCurl(cliConnection, "GET", "/v3/routes?"+query.Encode(), nil, &routes)
+	if err != nil {
+		return err
+	}
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil {
+		return err
+	}
+	for _, existing := range routes.Resources {
+		if existing.Relationships.Space.Data.GUID != space.Guid {
+			return fmt.Errorf("route %s is taken by another space", route)
+		}
+	}
+	// Korifi routes through the cluster's Gateway, which the wildcard DNS
+	// of the apps domain may not name.
+	if domain.shared() || onKorifi(cliConnection) {
+		return nil
+	}
+	return checkDNS(route, app, sharedDomain)
+}
+
+// checkDNS warns when the route's hostname does not resolve to the
+// foundation's router, which serves every name in the shared domain, and
+// says which record to create.
+func checkDNS(route RouteConfig, app, sharedDomain string) error {
+	if sharedDomain == "" || skipOffline("the DNS check of "+route.String()) {
+		return nil
+	}
+	host := route.Domain
+	if route.Hostname == "*" {
+		host = "treeline-cf-check." + route.Domain
+	} else if route.Hostname != "" {
+		host = route.Hostname + "." + route.Domain
+	}
+	target := app + "." + sharedDomain
+
+	if cname, err := net.LookupCNAME(host); err == nil && strings.HasSuffix(strings.TrimSuffix(cname, "."), "."+sharedDomain) {
+		return nil
+	}
+	addresses, err := net.LookupHost(host)
+	if err == nil {
+		routerAddresses, _ := net.LookupHost(target)
+		for _, address := range addresses {
+			for _, routerAddress := range routerAddresses {
+				if address == routerAddress {
+					return nil
+				}
+			}
+		}
+	}
+
+	name := host
+	if route.Hostname == "*" {
+		name = "*." + route.Domain
+	}
+	if route.Hostname == "" {
+		// A zone apex cannot hold a CNAME.
+		routerAddresses, _ := net.LookupHost(target)
+		fmt.Printf("Warning: %s does not point at Cloud Foundry. Create an ALIAS record to %s, or A records to %s, at your DNS provider\n", name, target, strings.Join(routerAddresses, ", "))
+		return nil
+	}
+	fmt.Printf("Warning: %s does not point at Cloud Foundry. Create this record at your DNS provider:\n  %s. CNAME %s.\n", name, name, target)
+	return nil
+}
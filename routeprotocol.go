@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+type v3Destination struct {
+	GUID string `json:"guid"`
+	App  struct {
+		GUID string `json:"guid"`
+	} `json:"app"`
+	Protocol string `json:"protocol"`
+}
+
+// findRoute returns the GUID of the route in the targeted space, or "".
+func findRoute(cliConnection plugin.CliConnection, route RouteConfig) (string, error) {
+	var domains struct {
+		Resources []struct {
+			GUID string `json:"guid"`
+		} `json:"resources"`
+	}
+	err := cfCurl(cliConnection, "GET", "/v3/domains?names="+url.QueryEscape(route.Domain), nil, &domains)
+	if err != nil {
+		return "", err
+	}
+	if len(domains.Resources) == 0 {
+		return "", fmt.Errorf("domain %s not found", route.Domain)
+	}
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"domain_guids": {domains.Resources[0].GUID},
+		"space_guids":  {space.Guid},
+		"hosts":        {route.Hostname},
+		"paths":        {route.Path},
+	}
+	var routes struct {
+		Resources []struct {
+			GUID string `json:"guid"`
+		} `json:"resources"`
+	}
+	err = cfCurl(cliConnection, "GET", "/v3/routes?"+query.Encode(), nil, &routes)
+	if err != nil || len(routes.Resources) == 0 {
+		return "", err
+	}
+	return routes.Resources[0].GUID, nil
+}
+
+// setRouteProtocol makes the route send the app's traffic over protocol,
+// http1 or http2. Foundations older than CAPI 3.85 reject this.
+func setRouteProtocol(cliConnection plugin.CliConnection, name string, route RouteConfig) error {
+	if route.Protocol != "http1" && route.Protocol != "http2" {
+		return fmt.Errorf("route %s has protocol %q, use http1 or http2", route, route.Protocol)
+	}
+	app, err := findApp(cliConnection, name)
+	if err != nil || app == nil {
+		return err
+	}
+	routeGUID, err := findRoute(cliConnection, route)
+	if err != nil {
+		return err
+	}
+	if routeGUID == "" {
+		return fmt.Errorf("route %s not found", route)
+	}
+	var destinations struct {
+		Destinations []v3Destination `json:"destinations"`
+	}
+	err = cfCurl(cliConnection, "GET", "/v3/routes/"+routeGUID+"/destinations", nil, &destinations)
+	if err != nil {
+		return err
+	}
+	for _, destination := range destinations.Destinations {
+		if destination.App.GUID != app.GUID || destination.Protocol == route.Protocol {
+			continue
+		}
+		err = cfCurl(cliConnection, "PATCH", "/v3/routes/"+routeGUID+"/destinations/"+destination.GUID, map[string]string{"protocol": route.Protocol}, nil)
+		if err != nil {
+			return fmt.Errorf("could not set %s to %s, the foundation may not support it: %v", route, route.Protocol, err)
+		}
+		fmt.Printf("%s now reaches %s over %s\n", route, name, route.Protocol)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+func routes(cliConnection plugin.CliConnection, config *Config, args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: cf treeline routes split APP_A APP_B --domain DOMAIN [--hostname HOST] [--weight PERCENT]")
+		fmt.Println("       cf treeline routes show [APP...]")
+		fmt.Println("       cf treeline routes check")
+		exitFailed("usage")
+	}
+	var err error
+	switch args[0] {
+	case "split":
+		err = splitRoute(cliConnection, config, args[1:])
+	case "show":
+		err = showRoutes(cliConnection, args[1:])
+	case "check":
+		err = checkRoutes(cliConnection, config)
+	default:
+		err = fmt.Errorf("unknown routes command %q", args[0])
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+// checkRoutes runs the checks deploy makes before mapping the configured
+// routes, without mapping them.
+func checkRoutes(cliConnection plugin.CliConnection, config *Config) error {
+	for _, route := range config.Routes {
+		err := checkRoute(cliConnection, config.App, route)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Checked", route)
+	}
+	return nil
+}
+
+// splitRoute maps two apps onto one route. The router balances requests
+// across instances, so the traffic ratio is set through instance counts.
+func splitRoute(cliConnection plugin.CliConnection, config *Config, args []string) error {
+	flags := flag.NewFlagSet("routes split", flag.ExitOnError)
+	domain := flags.String("domain", "", "domain of the shared route")
+	hostname := flags.String("hostname", "", "hostname of the shared route, defaults to APP_A")
+	path := flags.String("path", "", "path of the shared route")
+	weight := flags.Int("weight", 50, "percent of traffic for APP_A")
+	total := flags.Int("instances", 0, "instances to split between the apps, defaults to their current total")
+	flags.Parse(args)
+	if flags.NArg() != 2 || *domain == "" {
+		return fmt.Errorf("Usage: cf treeline routes split APP_A APP_B --domain DOMAIN [--hostname HOST] [--weight PERCENT]")
+	}
+	if *weight < 1 || *weight > 99 {
+		return fmt.Errorf("--weight must be between 1 and 99")
+	}
+	apps := flags.Args()
+	if *hostname == "" {
+		*hostname = apps[0]
+	}
+
+	if *total == 0 {
+		for _, name := range apps {
+			app, err := cliConnection.GetApp(name)
+			if err != nil {
+				return err
+			}
+			*total += app.InstanceCount
+		}
+	}
+	if *total < 2 {
+		*total = 2
+	}
+	instancesA := int(math.Round(float64(*total) * float64(*weight) / 100))
+	if instancesA < 1 {
+		instancesA = 1
+	}
+	if instancesA > *total-1 {
+		instancesA = *total - 1
+	}
+	counts := []int{instancesA, *total - instancesA}
+
+	err := checkSocketScaleOut(config, *total)
+	if err != nil {
+		return err
+	}
+
+	for i, name := range apps {
+		args := []string{"map-route", name, *domain, "--hostname", *hostname}
+		if *path != "" {
+			args = append(args, "--path", *path)
+		}
+		_, err := cliConnection.CliCommand(args...)
+		if err != nil {
+			return err
+		}
+		_, err = cliConnection.CliCommand("scale", name, "-i", fmt.Sprint(counts[i]))
+		if err != nil {
+			return err
+		}
+	}
+	for i, name := range apps {
+		fmt.Printf("%s.%s%s: %s gets %d of %d instances (%d%%)\n", *hostname, *domain, *path, name, counts[i], *total, 100*counts[i]/(*total))
+	}
+	return nil
+}
+
+// showRoutes lists each route in the space with the apps mapped to it and
+// the share of instances each app has.
+func showRoutes(cliConnection plugin.CliConnection, names []string) error {
+	apps, err := cliConnection.GetApps()
+	if err != nil {
+		return err
+	}
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	mapped := map[string][]string{}
+	instances := map[string]map[string]int{}
+	for _, app := range apps {
+		for _, route := range app.Routes {
+			url := route.Domain.Name
+			if route.Host != "" {
+				url = route.Host + "." + url
+			}
+			mapped[url] = append(mapped[url], app.Name)
+			if instances[url] == nil {
+				instances[url] = map[string]int{}
+			}
+			instances[url][app.Name] = app.TotalInstances
+		}
+	}
+
+	var urls []string
+	for url, apps := range mapped {
+		include := len(wanted) == 0
+		for _, app := range apps {
+			include = include || wanted[app]
+		}
+		if include {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		total := 0
+		for _, count := range instances[url] {
+			total += count
+		}
+		fmt.Println(url)
+		for _, app := range mapped[url] {
+			share := 0
+			if total > 0 {
+				share = 100 * instances[url][app] / total
+			}
+			fmt.Printf("  %-30s %3d instances  %3d%%\n", app, instances[url][app], share)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+var unsafeNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// sandboxSuffix turns the cf username, often an email address, into a
+// suffix that is valid in app, route and service names.
+func sandboxSuffix(cliConnection plugin.CliConnection) (string, error) {
+	username, err := cliConnection.Username()
+	if err != nil {
+		return "", err
+	}
+	if at := strings.Index(username, "@"); at > 0 {
+		username = username[:at]
+	}
+	suffix := strings.Trim(unsafeNameChars.ReplaceAllString(strings.ToLower(username), "-"), "-")
+	if suffix == "" {
+		return "", fmt.Errorf("could not make a sandbox name from the cf username %q", username)
+	}
+	if len(suffix) > 20 {
+		suffix = strings.TrimRight(suffix[:20], "-")
+	}
+	return suffix, nil
+}
+
+// sandbox renames the app, its routes, workers and the services it creates
+// with suffix so a developer gets a copy of the stack in a shared space.
+// User-provided services are only bound, so they keep their names.
+func (c *Config) sandbox(suffix string) {
+	suffix = "-" + suffix
+	c.App += suffix
+	for i, route := range c.Routes {
+		if route.Hostname == "" {
+			c.Routes[i].Hostname = strings.TrimPrefix(suffix, "-")
+		} else {
+			c.Routes[i].Hostname += suffix
+		}
+	}
+	services := make([]ServiceConfig, len(c.Services))
+	for i, service := range c.Services {
+		services[i] = service
+		if service.Service != "" {
+			services[i].Name += suffix
+		}
+	}
+	c.Services = services
+	if c.Assets.enabled() && c.Assets.Name != "" {
+		c.Assets.Name += suffix
+	}
+
+	workers := map[string]string{}
+	for i, worker := range c.Workers {
+		workers[worker.Name] = worker.Name + suffix
+		c.Workers[i].Name += suffix
+	}
+	dependencies := map[string]string{strings.TrimSuffix(c.App, suffix): c.App}
+	for name, renamed := range workers {
+		dependencies[name] = renamed
+	}
+	for _, worker := range c.Workers {
+		for i, dependency := range worker.DependsOn {
+			if renamed, ok := dependencies[dependency]; ok {
+				worker.DependsOn[i] = renamed
+			}
+		}
+	}
+	for i, name := range c.Network.Internal {
+		if renamed, ok := workers[name]; ok {
+			c.Network.Internal[i] = renamed
+		}
+	}
+	for i, policy := range c.Network.Policies {
+		if renamed, ok := workers[policy.From]; ok {
+			c.Network.Policies[i].From = renamed
+		}
+		if renamed, ok := workers[policy.To]; ok {
+			c.Network.Policies[i].To = renamed
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// SchemaCheckConfig runs a script before deploying that compares what the
+// new code's models expect with the live database, such as one diffing the
+// Waterline model definitions against the tables.
+type SchemaCheckConfig struct {
+	// Command is run locally. It exits non-zero, printing what is missing,
+	// when the code needs migrations that have not been applied.
+	Command string `yaml:"command,omitempty"`
+	// Tunnel opens an ssh tunnel through the running app to the database
+	// service Service, by default the first mysql or postgres one, and
+	// passes its address to Command as DATABASE_URL and DB_* variables.
+	Tunnel  bool   `yaml:"tunnel,omitempty"`
+	Service string `yaml:"service,omitempty"`
+	// Port is the local end of the tunnel, 15432 by default.
+	Port int `yaml:"port,omitempty"`
+	// Required fails the deploy instead of warning.
+	Required bool `yaml:"required,omitempty"`
+}
+
+func (c SchemaCheckConfig) enabled() bool {
+	return c.Command != ""
+}
+
+const defaultTunnelPort = 15432
+
+// checkSchema runs the schema check, returning an error only when it fails
+// and is required.
+func checkSchema(cliConnection plugin.CliConnection, config *Config, name string) error {
+	check := config.SchemaCheck
+	env := []string{"TREELINE_CF_APP=" + name}
+	if check.Tunnel {
+		app, err := findApp(cliConnection, name)
+		if err != nil {
+			return err
+		}
+		if app == nil {
+			fmt.Println("Skipping the schema check, as", name, "is not deployed yet")
+			return nil
+		}
+		tunnelEnv, closeTunnel, err := openDatabaseTunnel(cliConnection, config, name)
+		if err != nil {
+			return fmt.Errorf("could not open a tunnel for the schema check: %v", err)
+		}
+		defer closeTunnel()
+		env = append(env, tunnelEnv...)
+	}
+
+	fmt.Println("Checking the database schema with", check.Command)
+	fields := strings.Fields(check.Command)
+	cmd := command(fields[0], fields[1:]...)
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	message := fmt.Sprintf("the schema check failed (%v); the new code may need migrations that have not been applied", err)
+	if check.Required {
+		return fmt.Errorf("%s", message)
+	}
+	fmt.Println("Warning:", message)
+	return nil
+}
+
+// openDatabaseTunnel forwards a local port through the app's container to
+// the database, returning the variables that point the check at it.
+func openDatabaseTunnel(cliConnection plugin.CliConnection, config *Config, name string) ([]string, func(), error) {
+	check := config.SchemaCheck
+	service := check.Service
+	for _, candidate := range config.Services {
+		if service == "" && (candidate.Type == "mysql" || candidate.Type == "postgres" || candidate.Type == "postgresql") {
+			service = candidate.Name
+		}
+	}
+	if service == "" {
+		return nil, nil, fmt.Errorf("no mysql or postgres service; set schema_check.service")
+	}
+	credentials, err := serviceKeyCredentials(cliConnection, service, "treeline-cf-schema")
+	if err != nil {
+		return nil, nil, err
+	}
+	database := databaseURL(credentials)
+	if database == nil || database.Hostname() == "" {
+		return nil, nil, fmt.Errorf("the %s credentials have no database address", service)
+	}
+	remotePort := database.Port()
+	if remotePort == "" {
+		remotePort = "3306"
+		if strings.HasPrefix(database.Scheme, "postgres") {
+			remotePort = "5432"
+		}
+	}
+	port := check.Port
+	if port == 0 {
+		port = defaultTunnelPort
+	}
+
+	tunnel := command("cf", "ssh", name, "-N", "-L", fmt.Sprintf("%d:%s:%s", port, database.Hostname(), remotePort))
+	err = tunnel.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+	closeTunnel := func() {
+		tunnel.Process.Kill()
+		tunnel.Wait()
+	}
+	local := fmt.Sprintf("127.0.0.1:%d", port)
+	for start := time.Now(); ; time.Sleep(500 * time.Millisecond) {
+		conn, err := net.Dial("tcp", local)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Since(start) > 30*time.Second {
+			closeTunnel()
+			return nil, nil, fmt.Errorf("the tunnel to %s did not open: %v", service, err)
+		}
+	}
+
+	password, _ := database.User.Password()
+	env := []string{
+		"DB_HOST=127.0.0.1",
+		fmt.Sprintf("DB_PORT=%d", port),
+		"DB_USER=" + database.User.Username(),
+		"DB_PASSWORD=" + password,
+		"DB_NAME=" + strings.TrimPrefix(database.Path, "/"),
+	}
+	database.Host = local
+	env = append(env, "DATABASE_URL="+database.String())
+	return env, closeTunnel, nil
+}
+
+// databaseURL reads the uri brokers give, or builds one from the separate
+// fields ClearDB style brokers also provide.
+func databaseURL(credentials map[string]interface{}) *url.URL {
+	get := func(keys ...string) string {
+		for _, key := range keys {
+			if value, ok := credentials[key]; ok && fmt.Sprint(value) != "" {
+				return fmt.Sprint(value)
+			}
+		}
+		return ""
+	}
+	if uri := get("uri", "url", "jdbcUrl"); uri != "" {
+		parsed, err := url.Parse(strings.TrimPrefix(uri, "jdbc:"))
+		if err == nil {
+			return parsed
+		}
+	}
+	host := get("hostname", "host")
+	if host == "" {
+		return nil
+	}
+	if port := get("port"); port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return &url.URL{
+		Scheme: "mysql",
+		User:   url.UserPassword(get("username", "user"), get("password")),
+		Host:   host,
+		Path:   "/" + get("name", "database", "dbname"),
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// server is the local API 'cf treeline serve' exposes to editors and
+// dashboards. It only listens on localhost and every request needs the
+// token printed at startup.
+type server struct {
+	cliConnection plugin.CliConnection
+	app           string
+	token         string
+	// cli serializes calls through the plugin connection.
+	cli sync.Mutex
+	// deploying is held while a deploy runs; there is one at a time.
+	deploying sync.Mutex
+}
+
+func serve(cliConnection plugin.CliConnection, config *Config, args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	port := flags.Int("port", 8123, "the localhost port to listen on")
+	token := flags.String("token", os.Getenv("TREELINE_CF_SERVE_TOKEN"), "the bearer token clients send, random when empty")
+	flags.Parse(args)
+
+	s := &server{cliConnection: cliConnection, app: config.App, token: *token}
+	if s.token == "" {
+		random := make([]byte, 16)
+		rand.Read(random)
+		s.token = hex.EncodeToString(random)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.authorized(s.status))
+	mux.HandleFunc("/deploy", s.authorized(s.deploy))
+	mux.HandleFunc("/logs", s.authorized(s.logs))
+	address := fmt.Sprintf("127.0.0.1:%d", *port)
+	fmt.Printf("Serving %s on http://%s\n", config.App, address)
+	fmt.Printf("Send Authorization: Bearer %s\n", s.token)
+	err := http.ListenAndServe(address, mux)
+	if err != nil {
+		fail(err)
+	}
+}
+
+func (s *server) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+			http.Error(w, "missing or wrong bearer token", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+type appStatus struct {
+	App       string   `json:"app"`
+	State     string   `json:"state"`
+	Instances int      `json:"instances"`
+	Running   int      `json:"running"`
+	Memory    int64    `json:"memory_mb"`
+	Routes    []string `json:"routes"`
+	Services  []string `json:"services"`
+}
+
+// status answers GET /status with the app's state.
+func (s *server) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	s.cli.Lock()
+	app, err := s.cliConnection.GetApp(s.app)
+	s.cli.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	status := appStatus{App: app.Name, State: app.State, Instances: app.InstanceCount, Running: app.RunningInstances, Memory: app.Memory, Routes: []string{}, Services: []string{}}
+	for _, route := range app.Routes {
+		status.Routes = append(status.Routes, RouteConfig{Hostname: route.Host, Domain: route.Domain.Name, Path: route.Path}.String())
+	}
+	for _, service := range app.Services {
+		status.Services = append(status.Services, service.Name)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// deploy answers POST /deploy, with an optional {"args": [...]} of deploy
+// flags, by running the deploy and streaming its output as server-sent
+// events, ending with a "done" event holding the exit code.
+func (s *server) deploy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Args []string `json:"args"`
+	}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !s.deploying.TryLock() {
+		http.Error(w, "a deploy is already running", http.StatusConflict)
+		return
+	}
+	defer s.deploying.Unlock()
+
+	// deploy exits the process when it is done, so it runs as its own
+	// cf command.
+	cmd := command("cf", append([]string{"treeline", "deploy"}, body.Args...)...)
+	err := streamEvents(w, r, cmd)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// logs answers GET /logs by streaming the app's logs as server-sent events
+// until the client goes away.
+func (s *server) logs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	err := streamEvents(w, r, command("cf", "logs", s.app))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// maxEventLine is the longest output line streamEvents sends.
+const maxEventLine = 1024 * 1024
+
+// streamEvents runs cmd and sends each line it prints as a "line" event,
+// then a "done" event with its exit code. The command is killed when the
+// client disconnects.
+func streamEvents(w http.ResponseWriter, r *http.Request, cmd *exec.Cmd) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("streaming is not supported")
+	}
+	reader, writer := io.Pipe()
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-r.Context().Done()
+		cmd.Process.Kill()
+	}()
+	done := make(chan int, 1)
+	go func() {
+		err := cmd.Wait()
+		code := 0
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			code = exitErr.ExitCode()
+		} else if err != nil {
+			code = -1
+		}
+		writer.Close()
+		done <- code
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	scanner := bufio.NewScanner(reader)
+	// Deploy output can carry long lines, such as staging logs with JSON.
+	scanner.Buffer(make([]byte, 64*1024), maxEventLine)
+	for scanner.Scan() {
+		fmt.Fprintf(w, "event: line\ndata: %s\n\n", scanner.Text())
+		flusher.Flush()
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+		flusher.Flush()
+	}
+	// The command blocks on a full pipe once nothing reads it, and would
+	// never be waited for.
+	io.Copy(ioutil.Discard, reader)
+	fmt.Fprintf(w, "event: done\ndata: {\"exit_code\": %d}\n\n", <-done)
+	flusher.Flush()
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// SleepConfig stops review and sandbox apps nobody has used for a while, so
+// they stop counting against the space's memory quota.
+type SleepConfig struct {
+	// After is how long an app goes without a request before sleep stops
+	// it, such as 2h.
+	After string `yaml:"after,omitempty"`
+}
+
+// sleptAnnotation records when sleep stopped an app, so wake knows it.
+const sleptAnnotation = "treeline-cli/slept-at"
+
+func sleepCommand(cliConnection plugin.CliConnection, config *Config, args []string) {
+	flags := flag.NewFlagSet("sleep", flag.ExitOnError)
+	after := flags.Duration("after", 0, "stop apps without a request for `DURATION`, sleep.after or 2h by default")
+	interval := flags.Duration("interval", 5*time.Minute, "how often to check the apps' router logs")
+	once := flags.Bool("once", false, "check once and exit, for running from cron")
+	flags.Parse(args)
+
+	idle := *after
+	if idle == 0 {
+		idle = 2 * time.Hour
+		if config.Sleep.After != "" {
+			var err error
+			idle, err = time.ParseDuration(config.Sleep.After)
+			if err != nil {
+				fmt.Printf("Invalid sleep.after in %s: %v\n", configFile, err)
+				exitFailed(errorClass(err))
+			}
+		}
+	}
+
+	watched := flags.Args()
+	if len(watched) == 0 {
+		fmt.Printf("Stopping review and sandbox apps of %s after %v without a request\n", config.App, idle)
+	} else {
+		fmt.Printf("Stopping %s after %v without a request\n", strings.Join(watched, ", "), idle)
+	}
+	// lastActive starts at now for a watcher, which saw no requests before
+	// it started, and at the app's last update when run once.
+	started := time.Now()
+	lastActive := map[string]time.Time{}
+	for {
+		apps, err := sleepCandidates(cliConnection, config, watched)
+		if err != nil {
+			fail(err)
+		}
+		for _, app := range apps {
+			if !strings.EqualFold(app.State, "started") {
+				delete(lastActive, app.Name)
+				continue
+			}
+			if _, ok := lastActive[app.Name]; !ok {
+				lastActive[app.Name] = started
+				if updated, err := time.Parse(time.RFC3339, app.UpdatedAt); *once && err == nil {
+					lastActive[app.Name] = updated
+				}
+			}
+			if request, err := lastRequest(cliConnection, app.Name); err != nil {
+				fmt.Printf("Could not read the router logs of %s: %v\n", app.Name, err)
+				continue
+			} else if request.After(lastActive[app.Name]) {
+				lastActive[app.Name] = request
+			}
+			if time.Since(lastActive[app.Name]) < idle {
+				continue
+			}
+			err = sleepApp(cliConnection, app, time.Since(lastActive[app.Name]))
+			if err != nil {
+				fmt.Printf("Could not stop %s: %v\n", app.Name, err)
+			}
+			delete(lastActive, app.Name)
+		}
+		if *once {
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// sleepCandidates returns the named apps, or without names the managed
+// review apps and sandboxes of the project, listed again on every check so
+// new ones are picked up.
+func sleepCandidates(cliConnection plugin.CliConnection, config *Config, names []string) ([]v3App, error) {
+	var apps []v3App
+	if len(names) > 0 {
+		for _, name := range names {
+			app, err := findApp(cliConnection, name)
+			if err != nil {
+				return nil, err
+			}
+			if app == nil {
+				return nil, fmt.Errorf("app %s not found", name)
+			}
+			apps = append(apps, *app)
+		}
+		return apps, nil
+	}
+	managed, err := managedApps(cliConnection)
+	if err != nil {
+		return nil, err
+	}
+	for _, app := range managed {
+		if app.Name != config.App && (app.review() || strings.HasPrefix(app.Name, config.App+"-")) {
+			apps = append(apps, app.v3App)
+		}
+	}
+	return apps, nil
+}
+
+// lastRequest returns when the router last logged a request to the app in
+// its recent logs, or the zero time when there is none.
+func lastRequest(cliConnection plugin.CliConnection, name string) (time.Time, error) {
+	lines, err := recentLogs(cliConnection, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var last time.Time
+	for _, line := range lines {
+		entry, ok := parseLogLine(line)
+		if !ok || !strings.HasPrefix(entry.Source, "RTR") {
+			continue
+		}
+		if at, err := time.Parse(cfLogTime, entry.Time); err == nil && at.After(last) {
+			last = at
+		}
+	}
+	return last, nil
+}
+
+func sleepApp(cliConnection plugin.CliConnection, app v3App, idle time.Duration) error {
+	fmt.Printf("Stopping %s, idle for %v; run 'cf treeline wake %s' to start it again\n", app.Name, idle.Round(time.Minute), app.Name)
+	_, err := cliConnection.CliCommandWithoutTerminalOutput("stop", app.Name)
+	if err != nil {
+		return err
+	}
+	events.record("sleep", map[string]interface{}{"app": app.Name, "idle_seconds": int(idle.Seconds())})
+	return cfCurl(cliConnection, "PATCH", "/v3/apps/"+app.GUID, map[string]v3Metadata{
+		"metadata": {Annotations: map[string]string{sleptAnnotation: time.Now().UTC().Format(time.RFC3339)}},
+	}, nil)
+}
+
+func wake(cliConnection plugin.CliConnection, config *Config, args []string) {
+	names := args
+	if len(names) == 0 {
+		names = []string{config.App}
+	}
+	for _, name := range names {
+		err := wakeApp(cliConnection, name)
+		if err != nil {
+			fmt.Printf("Could not start %s: %v\n", name, err)
+			exitFailed(errorClass(err))
+		}
+	}
+}
+
+// wakeApp starts the app and clears the annotation sleep left on it.
+func wakeApp(cliConnection plugin.CliConnection, name string) error {
+	app, err := findApp(cliConnection, name)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("app %s not found", name)
+	}
+	if strings.EqualFold(app.State, "started") {
+		fmt.Printf("%s is already running\n", name)
+		return nil
+	}
+	if slept := app.Metadata.Annotations[sleptAnnotation]; slept != "" {
+		fmt.Printf("Waking %s, asleep since %s\n", name, slept)
+	}
+	_, err = cliConnection.CliCommand("start", name)
+	if err != nil {
+		return err
+	}
+	// A null value deletes the annotation.
+	return cfCurl(cliConnection, "PATCH", "/v3/apps/"+app.GUID, map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{sleptAnnotation: nil}},
+	}, nil)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+	"gopkg.in/yaml.v3"
+)
+
+// snapshotVersion is bumped when the snapshot format changes incompatibly.
+const snapshotVersion = 1
+
+var snapshotDir = filepath.Join(stateDir, "snapshots")
+
+// A snapshot is everything needed to recreate the app's Cloud Foundry
+// footprint in another space. It holds env vars and user-provided service
+// credentials, so it is written readable only by the owner.
+type snapshot struct {
+	Version   int       `yaml:"version"`
+	CreatedAt time.Time `yaml:"created_at"`
+	Org       string    `yaml:"org"`
+	Space     string    `yaml:"space"`
+	App       *Config   `yaml:"app"`
+	// Credentials of the user-provided services, by name.
+	UserProvided map[string]map[string]interface{} `yaml:"user_provided,omitempty"`
+	// Droplet is the file, next to the snapshot, the app's droplet was
+	// saved to.
+	Droplet string `yaml:"droplet,omitempty"`
+}
+
+func snapshotFile(name string) string {
+	return filepath.Join(snapshotDir, name+".yml")
+}
+
+func snapshotCommand(cliConnection plugin.CliConnection, config *Config, args []string) {
+	if len(args) == 0 || (args[0] != "save" && args[0] != "restore") {
+		fmt.Println("Usage: cf treeline snapshot save [--app APP] [--no-droplet] NAME")
+		fmt.Println("       cf treeline snapshot restore [--app APP] NAME")
+		exitFailed("usage")
+	}
+	flags := flag.NewFlagSet("snapshot "+args[0], flag.ExitOnError)
+	appName := flags.String("app", "", "the app to snapshot, or the name to restore it as")
+	noDroplet := flags.Bool("no-droplet", false, "leave the droplet out; restore then only sets up the app for a deploy")
+	flags.Parse(args[1:])
+	if flags.NArg() != 1 {
+		fmt.Printf("Usage: cf treeline snapshot %s [OPTIONS] NAME\n", args[0])
+		exitFailed("usage")
+	}
+
+	var err error
+	if args[0] == "save" {
+		name := *appName
+		if name == "" {
+			name = config.App
+		}
+		err = saveSnapshot(cliConnection, name, flags.Arg(0), !*noDroplet)
+	} else {
+		err = restoreSnapshot(cliConnection, flags.Arg(0), *appName)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func saveSnapshot(cliConnection plugin.CliConnection, app, name string, withDroplet bool) error {
+	config, err := configFromApp(cliConnection, app)
+	if err != nil {
+		return err
+	}
+	org, err := cliConnection.GetCurrentOrg()
+	if err != nil {
+		return err
+	}
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil {
+		return err
+	}
+	snap := snapshot{
+		Version:      snapshotVersion,
+		CreatedAt:    time.Now().UTC(),
+		Org:          org.Name,
+		Space:        space.Name,
+		App:          config,
+		UserProvided: map[string]map[string]interface{}{},
+	}
+	for _, service := range config.Services {
+		if service.Service != "" {
+			continue
+		}
+		instance, err := cliConnection.GetService(service.Name)
+		if err != nil {
+			return err
+		}
+		var credentials map[string]interface{}
+		err = cfCurl(cliConnection, "GET", "/v3/service_instances/"+instance.Guid+"/credentials", nil, &credentials)
+		if err != nil {
+			return fmt.Errorf("could not read the credentials of %s: %v", service.Name, err)
+		}
+		snap.UserProvided[service.Name] = credentials
+	}
+
+	err = os.MkdirAll(snapshotDir, 0700)
+	if err != nil {
+		return err
+	}
+	if withDroplet {
+		snap.Droplet = name + ".droplet.tgz"
+		_, err = saveDroplet(cliConnection, app, filepath.Join(snapshotDir, snap.Droplet))
+		if err != nil {
+			return err
+		}
+	}
+	data, err := yaml.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(snapshotFile(name), data, 0600)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Saved a snapshot of %s to %s; it holds secrets, so keep it safe\n", app, snapshotFile(name))
+	return nil
+}
+
+// restoreSnapshot recreates the services and the app in the targeted space,
+// then runs the saved droplet on it.
+func restoreSnapshot(cliConnection plugin.CliConnection, name, app string) error {
+	data, err := ioutil.ReadFile(snapshotFile(name))
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	err = yaml.Unmarshal(data, &snap)
+	if err != nil {
+		return fmt.Errorf("could not parse %s: %v", snapshotFile(name), err)
+	}
+	if snap.Version != snapshotVersion || snap.App == nil {
+		return fmt.Errorf("%s is a version %d snapshot, this plugin reads version %d", snapshotFile(name), snap.Version, snapshotVersion)
+	}
+	config := snap.App
+	if app == "" {
+		app = config.App
+	}
+	fmt.Printf("Restoring %s from %s/%s, saved %s\n", app, snap.Org, snap.Space, snap.CreatedAt.Local().Format(time.RFC1123))
+
+	existing, err := cliConnection.GetServices()
+	if err != nil {
+		return err
+	}
+	for _, service := range config.Services {
+		found := false
+		for _, instance := range existing {
+			found = found || instance.Name == service.Name
+		}
+		credentials, userProvided := snap.UserProvided[service.Name]
+		switch {
+		case found:
+			continue
+		case userProvided:
+			parameters, err := json.Marshal(credentials)
+			if err != nil {
+				return err
+			}
+			_, err = cliConnection.CliCommand("cups", service.Name, "-p", string(parameters))
+			if err != nil {
+				return err
+			}
+		default:
+			_, err = cliConnection.CliCommand("cs", service.Service, service.Plan, service.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	live, err := findApp(cliConnection, app)
+	if err != nil {
+		return err
+	}
+	if live == nil {
+		_, err = createApp(cliConnection, app)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedKeys(config.Env) {
+		_, err = cliConnection.CliCommandWithoutTerminalOutput("set-env", app, key, config.Env[key])
+		if err != nil {
+			return err
+		}
+	}
+	_, err = createServices(cliConnection, app, config.Services)
+	if err != nil {
+		return err
+	}
+	for _, route := range config.Routes {
+		_, err = cliConnection.CliCommand(route.mapArgs(app)...)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = cliConnection.CliCommand("scale", app, "-m", config.Memory, "-i", fmt.Sprint(config.Instances), "-f")
+	if err != nil {
+		return err
+	}
+
+	if snap.Droplet == "" {
+		fmt.Printf("%s is set up; the snapshot has no droplet, so deploy the code with 'cf treeline deploy'\n", app)
+		return nil
+	}
+	return loadDroplet(cliConnection, filepath.Join(snapshotDir, snap.Droplet), app)
+}
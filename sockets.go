@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var socketsAdapter = regexp.MustCompile(`(?s)sockets\s*:\s*\{[^}]*adapter\s*:\s*['"]socket\.io-redis['"]`)
+
+// checkSocketScaleOut refuses to run a Sails app on more than one instance
+// unless socket.io shares its state through Redis. Without it each instance
+// only knows its own sockets, so broadcasts and rooms silently miss clients
+// connected to the others.
+func checkSocketScaleOut(config *Config, instances int) error {
+	if instances <= 1 {
+		return nil
+	}
+	data, err := ioutil.ReadFile("package.json")
+	if err != nil {
+		return nil
+	}
+	var manifest struct {
+		Dependencies map[string]string `json:"dependencies"`
+	}
+	if json.Unmarshal(data, &manifest) != nil || manifest.Dependencies["sails"] == "" {
+		return nil
+	}
+
+	var problems []string
+	_, declared := manifest.Dependencies["socket.io-redis"]
+	if _, err := os.Stat(filepath.Join("node_modules", "socket.io-redis", "package.json")); !declared && err != nil {
+		problems = append(problems, "socket.io-redis is not installed; run 'npm install socket.io-redis --save'")
+	}
+
+	redis := servicesForSails(config).Redis
+	configured := false
+	for _, file := range []string{"config/env/development.js", "config/sockets.js"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		// The generated config builds the Redis clients from the service's
+		// credentials ahead of the sockets settings.
+		if socketsAdapter.Match(data) && strings.Contains(string(data), "'"+redis+"'") {
+			configured = true
+		}
+	}
+	if !configured {
+		problems = append(problems, fmt.Sprintf("the sockets adapter is not socket.io-redis on the %s service; run 'cf treeline config-pws' to regenerate config/env/development.js", redis))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("refusing to run %d instances: websockets only reach clients on the same instance unless they share Redis:\n  %s", instances, strings.Join(problems, "\n  "))
+}
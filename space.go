@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+var reviewAppName = regexp.MustCompile(`-pr-\d+$`)
+
+type managedApp struct {
+	v3App
+	services []string
+	// deployed is when the app's current droplet was staged. Scaling,
+	// restarts and env changes touch the app's updated_at, not this.
+	deployed string
+}
+
+func (a managedApp) review() bool {
+	return reviewAppName.MatchString(a.Name)
+}
+
+func (a managedApp) age() time.Duration {
+	deployed, err := time.Parse(time.RFC3339, a.deployed)
+	if err != nil {
+		return 0
+	}
+	return time.Since(deployed)
+}
+
+func space(cliConnection plugin.CliConnection, args []string) {
+	if len(args) == 0 || (args[0] != "status" && args[0] != "cleanup") {
+		fmt.Println("Usage: cf treeline space status")
+		fmt.Println("       cf treeline space cleanup [--days N] [--yes]")
+		exitFailed("usage")
+	}
+	var err error
+	if args[0] == "status" {
+		err = spaceStatus(cliConnection)
+	} else {
+		err = spaceCleanup(cliConnection, args[1:])
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+// managedApps returns the apps in the targeted space carrying the plugin's
+// managed-by label, with the services bound to them.
+func managedApps(cliConnection plugin.CliConnection) ([]managedApp, error) {
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil {
+		return nil, err
+	}
+	var apps []v3App
+	path := "/v3/apps?per_page=100&order_by=name&label_selector=" + managedLabel + "%3D" + managedBy + "&space_guids=" + space.Guid
+	for path != "" {
+		var page struct {
+			Pagination struct {
+				Next *struct {
+					Href string `json:"href"`
+				} `json:"next"`
+			} `json:"pagination"`
+			Resources []v3App `json:"resources"`
+		}
+		err = cfCurl(cliConnection, "GET", path, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, page.Resources...)
+		path = ""
+		if next := page.Pagination.Next; next != nil {
+			path = next.Href[strings.Index(next.Href, "/v3/"):]
+		}
+	}
+
+	instances, err := cliConnection.GetServices()
+	if err != nil {
+		return nil, err
+	}
+	bound := map[string][]string{}
+	for _, instance := range instances {
+		for _, app := range instance.ApplicationNames {
+			bound[app] = append(bound[app], instance.Name)
+		}
+	}
+
+	var managed []managedApp
+	for _, app := range apps {
+		var droplet struct {
+			CreatedAt string `json:"created_at"`
+		}
+		// An app that never staged has no droplet, and counts from its
+		// own last change.
+		deployed := app.UpdatedAt
+		if cfCurl(cliConnection, "GET", "/v3/apps/"+app.GUID+"/droplets/current", nil, &droplet) == nil && droplet.CreatedAt != "" {
+			deployed = droplet.CreatedAt
+		}
+		managed = append(managed, managedApp{app, bound[app.Name], deployed})
+	}
+	return managed, nil
+}
+
+func spaceStatus(cliConnection plugin.CliConnection) error {
+	apps, err := managedApps(cliConnection)
+	if err != nil {
+		return err
+	}
+	if len(apps) == 0 {
+		fmt.Println("No apps in this space were deployed with cf treeline")
+		return nil
+	}
+	services := map[string]bool{}
+	fmt.Printf("%-30s %-20s %-8s %-8s %s\n", "APP", "PROJECT", "STATE", "AGE", "SERVICES")
+	for _, app := range apps {
+		name := app.Name
+		if app.review() {
+			name += " (review)"
+		}
+		fmt.Printf("%-30s %-20s %-8s %-8s %s\n", name, app.Metadata.Labels["project"], app.State, fmt.Sprintf("%dd", int(app.age().Hours()/24)), strings.Join(app.services, ", "))
+		for _, service := range app.services {
+			services[service] = true
+		}
+	}
+	fmt.Printf("\n%d apps and %d services managed by cf treeline\n", len(apps), len(services))
+	return nil
+}
+
+// spaceCleanup deletes review apps that have not been deployed for days.
+// Their services are shared with the main app and kept.
+func spaceCleanup(cliConnection plugin.CliConnection, args []string) error {
+	flags := flag.NewFlagSet("space cleanup", flag.ExitOnError)
+	days := flags.Int("days", 7, "delete review apps not deployed for this many days")
+	yes := flags.Bool("yes", false, "delete without asking")
+	flags.Parse(args)
+
+	apps, err := managedApps(cliConnection)
+	if err != nil {
+		return err
+	}
+	var stale []managedApp
+	for _, app := range apps {
+		if app.review() && app.age() > time.Duration(*days)*24*time.Hour {
+			stale = append(stale, app)
+		}
+	}
+	if len(stale) == 0 {
+		fmt.Printf("No review apps older than %d days\n", *days)
+		return nil
+	}
+	for _, app := range stale {
+		fmt.Printf("%s, last deployed %d days ago\n", app.Name, int(app.age().Hours()/24))
+	}
+	if !*yes {
+		fmt.Printf("Delete these %d review apps and their routes? [y/N] ", len(stale))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+			return nil
+		}
+	}
+	for _, app := range stale {
+		_, err := cliConnection.CliCommand("delete", app.Name, "-r", "-f")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// nodeEnvSetup gives an ssh session the environment the app's start command
+// sees, such as the PATH to the buildpack's node and npm.
+const nodeEnvSetup = "cd /home/vcap/app; " +
+	"for f in /home/vcap/app/.profile.d/*.sh /home/vcap/profile.d/*.sh /home/vcap/app/.profile; do [ -f \"$f\" ] && . \"$f\"; done; " +
+	"export PATH=\"/home/vcap/app/node_modules/.bin:$PATH\""
+
+// sailsConsole lifts a second Sails in the container. It gets a free port,
+// as the app's own Sails already listens on $PORT.
+const sailsConsole = "env PORT=0 sails console"
+
+var repls = map[string]string{
+	"node":  "node",
+	"sails": sailsConsole,
+}
+
+func ssh(cliConnection plugin.CliConnection, config *Config, args []string) {
+	flags := flag.NewFlagSet("ssh", flag.ExitOnError)
+	instance := flags.Int("i", 0, "ssh into instance `INDEX`")
+	repl := flags.String("repl", "", "start a `REPL` (node or sails) instead of a shell")
+	flags.Parse(args)
+
+	remote := strings.Join(flags.Args(), " ")
+	if *repl != "" {
+		if repls[*repl] == "" {
+			fmt.Println("--repl must be node or sails")
+			exitFailed("usage")
+		}
+		remote = repls[*repl]
+	}
+	err := sshInto(config.App, *instance, remote, "-t")
+	if err != nil {
+		fail(err)
+	}
+}
+
+// console runs sails console in the app's container, so live models can be
+// used with the app's own service credentials.
+func console(cliConnection plugin.CliConnection, config *Config, args []string) {
+	flags := flag.NewFlagSet("console", flag.ExitOnError)
+	instance := flags.Int("i", 0, "run the console in instance `INDEX`")
+	flags.Parse(args)
+
+	// The REPL needs a terminal even when cf's stdin is not one, such as
+	// under some Windows terminals.
+	err := sshInto(config.App, *instance, sailsConsole, "--force-pseudo-tty")
+	if err != nil {
+		fail(err)
+	}
+}
+
+// sshInto runs remote in the app's instance with the Node environment set
+// up, or an interactive shell when remote is empty. tty is the cf ssh flag
+// asking for a terminal. It runs the cf binary itself, as plugin commands
+// cannot hand the terminal over to ssh.
+func sshInto(name string, instance int, remote, tty string) error {
+	script := nodeEnvSetup + "; exec " + remote
+	if remote == "" {
+		script = nodeEnvSetup + "; exec bash"
+	}
+	cmd := command("cf", "ssh", name, "-i", fmt.Sprint(instance), tty, "-c", script)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
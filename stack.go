@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+const latestStack = "cflinuxfs4"
+
+type v3Lifecycle struct {
+	Type string `json:"type"`
+	Data struct {
+		Buildpacks []string `json:"buildpacks"`
+		Stack      string   `json:"stack"`
+	} `json:"data"`
+}
+
+// checkStack verifies the foundation has the stack and that every named
+// buildpack has a version for it. Buildpacks given as URLs cannot be checked
+// and are left to staging.
+func checkStack(cliConnection plugin.CliConnection, stack string, buildpacks []string) error {
+	var stacks struct {
+		Resources []struct {
+			Name string `json:"name"`
+		} `json:"resources"`
+	}
+	err := cfCurl(cliConnection, "GET", "/v3/stacks?names="+url.QueryEscape(stack), nil, &stacks)
+	if err != nil {
+		return err
+	}
+	if len(stacks.Resources) == 0 {
+		return fmt.Errorf("this foundation has no %s stack; 'cf stacks' lists the ones it has", stack)
+	}
+
+	if len(buildpacks) == 0 {
+		buildpacks = []string{""}
+	}
+	for _, buildpack := range buildpacks {
+		if strings.Contains(buildpack, "://") {
+			fmt.Printf("Cannot check that %s supports %s, staging will tell\n", buildpack, stack)
+			continue
+		}
+		path := "/v3/buildpacks?stacks=" + url.QueryEscape(stack)
+		if buildpack != "" {
+			path += "&names=" + url.QueryEscape(buildpack)
+		}
+		var found struct {
+			Resources []struct {
+				Name    string `json:"name"`
+				Enabled bool   `json:"enabled"`
+			} `json:"resources"`
+		}
+		err = cfCurl(cliConnection, "GET", path, nil, &found)
+		if err != nil {
+			return err
+		}
+		enabled := false
+		for _, resource := range found.Resources {
+			enabled = enabled || resource.Enabled
+		}
+		if !enabled && buildpack == "" {
+			return fmt.Errorf("no enabled buildpack supports %s on this foundation", stack)
+		}
+		if !enabled {
+			return fmt.Errorf("buildpack %s has no enabled version for %s; ask your operator to install one, or set a buildpack URL that supports it", buildpack, stack)
+		}
+	}
+	return nil
+}
+
+// migrateStack moves the app to another stack and restages it from its
+// current package, so no local source is needed.
+func migrateStack(cliConnection plugin.CliConnection, config *Config, args []string) {
+	flags := flag.NewFlagSet("migrate-stack", flag.ExitOnError)
+	stack := flags.String("stack", latestStack, "the stack to move to")
+	flags.Parse(args)
+
+	name := config.App
+	if flags.NArg() > 0 {
+		name = flags.Arg(0)
+	}
+	err := moveToStack(cliConnection, name, *stack)
+	if err != nil {
+		fail(err)
+	}
+}
+
+func moveToStack(cliConnection plugin.CliConnection, name, stack string) error {
+	app, err := findApp(cliConnection, name)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("app %s not found", name)
+	}
+	var details struct {
+		Lifecycle v3Lifecycle `json:"lifecycle"`
+	}
+	err = cfCurl(cliConnection, "GET", "/v3/apps/"+app.GUID, nil, &details)
+	if err != nil {
+		return err
+	}
+	lifecycle := details.Lifecycle
+	if lifecycle.Type != "buildpack" {
+		return fmt.Errorf("%s is a %s app; only buildpack apps have a stack", name, lifecycle.Type)
+	}
+	if lifecycle.Data.Stack == stack {
+		fmt.Printf("%s already runs on %s\n", name, stack)
+		return nil
+	}
+	err = checkStack(cliConnection, stack, lifecycle.Data.Buildpacks)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Moving %s from %s to %s\n", name, lifecycle.Data.Stack, stack)
+	lifecycle.Data.Stack = stack
+	err = cfCurl(cliConnection, "PATCH", "/v3/apps/"+app.GUID, map[string]interface{}{"lifecycle": lifecycle}, nil)
+	if err != nil {
+		return err
+	}
+	_, err = cliConnection.CliCommand("restage", name)
+	if err != nil {
+		return fmt.Errorf("restaging on %s failed; move back with 'cf treeline migrate-stack --stack %s %s': %v", stack, details.Lifecycle.Data.Stack, name, err)
+	}
+	return nil
+}
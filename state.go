@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateDir holds what the plugin remembers about the project between runs.
+const stateDir = ".treeline-cf"
+
+var stateFile = filepath.Join(stateDir, "state.json")
+
+// deployState records the steps an unfinished deploy got through, so
+// `deploy --resume` can pick up after them.
+type deployState struct {
+	App       string    `json:"app"`
+	Completed []string  `json:"completed"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// loadDeployState returns nil when there is no unfinished deploy.
+func loadDeployState() (*deployState, error) {
+	data, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &deployState{}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func saveDeployState(state *deployState) error {
+	state.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(stateDir, 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(stateFile, data, 0644)
+}
+
+func clearDeployState() error {
+	err := os.Remove(stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
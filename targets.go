@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// targetEnv names the target a deploy runs against during a multi-target
+// deploy.
+const targetEnv = "TREELINE_CF_TARGET"
+
+// TargetConfig is a Cloud Foundry foundation to deploy to with
+// --targets. The password or client secret comes from
+// TREELINE_CF_TARGET_<NAME>_SECRET or 'cf treeline auth set target-<name>',
+// and is handed to 'cf auth' through CF_PASSWORD, which needs cf CLI 7.
+type TargetConfig struct {
+	API      string `yaml:"api"`
+	Org      string `yaml:"org"`
+	Space    string `yaml:"space"`
+	Username string `yaml:"username,omitempty"`
+	// ClientID logs in with client credentials instead of a user.
+	ClientID          string `yaml:"client_id,omitempty"`
+	SkipSSLValidation bool   `yaml:"skip_ssl_validation,omitempty"`
+	// URL is where the app answers on this foundation. SmokeTest is a path
+	// requested there after the deploy, "/" by default.
+	URL       string `yaml:"url,omitempty"`
+	SmokeTest string `yaml:"smoke_test,omitempty"`
+	// Primary is the target serving production, which failover moves
+	// the routes away from.
+	Primary bool `yaml:"primary,omitempty"`
+	// Services override the service and plan, or the type, of the
+	// top-level services on this foundation, keyed by service name or type.
+	Services map[string]ServiceConfig `yaml:"services,omitempty"`
+}
+
+// useTarget applies the org, space and overrides of the target a
+// multi-target deploy is running against, if any.
+func (c *Config) useTarget(name string) error {
+	if name == "" {
+		return nil
+	}
+	target, ok := c.Targets[name]
+	if !ok {
+		return fmt.Errorf("%s has no target %q", configFile, name)
+	}
+	c.Org, c.Space = target.Org, target.Space
+	for i, service := range c.Services {
+		override, ok := target.Services[service.Name]
+		if !ok && service.Type != "" {
+			override, ok = target.Services[service.Type]
+		}
+		if !ok {
+			continue
+		}
+		if override.Type != "" {
+			c.Services[i].Type = override.Type
+			c.Services[i].Service, c.Services[i].Plan = "", ""
+		}
+		if override.Service != "" {
+			c.Services[i].Service, c.Services[i].Plan = override.Service, override.Plan
+		}
+		if override.Parameters != nil {
+			c.Services[i].Parameters = override.Parameters
+		}
+	}
+	return nil
+}
+
+// checkTarget makes sure a deploy run against a target is logged in to
+// the target's API, org and space, so it never lands in the user's own.
+func (c *Config) checkTarget(cliConnection plugin.CliConnection, name string) error {
+	if name == "" {
+		return nil
+	}
+	target := c.Targets[name]
+	api, err := cliConnection.ApiEndpoint()
+	if err != nil {
+		return err
+	}
+	org, err := cliConnection.GetCurrentOrg()
+	if err != nil {
+		return err
+	}
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil {
+		return err
+	}
+	if strings.TrimRight(api, "/") != strings.TrimRight(target.API, "/") || !strings.EqualFold(org.Name, target.Org) || !strings.EqualFold(space.Name, target.Space) {
+		return fmt.Errorf("target %s is %s %s/%s, but cf is targeting %s %s/%s", name, target.API, target.Org, target.Space, api, org.Name, space.Name)
+	}
+	return nil
+}
+
+type targetResult struct {
+	name     string
+	err      error
+	smoke    string
+	duration time.Duration
+}
+
+func (t TargetConfig) secret(name string) string {
+	envVar := "TREELINE_CF_TARGET_" + strings.ToUpper(strings.Replace(name, "-", "_", -1)) + "_SECRET"
+	return credential("target-"+name, envVar)
+}
+
+// cfHome is the CF_HOME a target's session is kept in, so deploying to it
+// leaves the user's own login alone.
+func cfHome(name string) string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".treeline-cf", "targets", name)
+}
+
+// cf runs a cf command in the target's session.
+func (t TargetConfig) cf(name string, args ...string) *exec.Cmd {
+	cmd := command("cf", args...)
+	pluginHome := os.Getenv("CF_PLUGIN_HOME")
+	if pluginHome == "" {
+		pluginHome = os.Getenv("CF_HOME")
+	}
+	if pluginHome == "" {
+		pluginHome, _ = os.UserHomeDir()
+	}
+	cmd.Env = append(cmd.Env, "CF_HOME="+cfHome(name), "CF_PLUGIN_HOME="+pluginHome, targetEnv+"="+name)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+func (t TargetConfig) login(name string) error {
+	if t.API == "" || t.Org == "" || t.Space == "" {
+		return fmt.Errorf("targets.%s in %s needs api, org and space", name, configFile)
+	}
+	secret := t.secret(name)
+	if secret == "" {
+		return fmt.Errorf("no secret for %s; set TREELINE_CF_TARGET_%s_SECRET or run 'cf treeline auth set target-%s'", name, strings.ToUpper(strings.Replace(name, "-", "_", -1)), name)
+	}
+	err := os.MkdirAll(cfHome(name), 0700)
+	if err != nil {
+		return err
+	}
+	api := []string{"api", t.API}
+	if t.SkipSSLValidation {
+		api = append(api, "--skip-ssl-validation")
+	}
+	auth := t.cf(name, "auth")
+	if t.ClientID != "" {
+		auth = t.cf(name, "auth", "--client-credentials")
+		auth.Env = append(auth.Env, "CF_USERNAME="+t.ClientID, "CF_PASSWORD="+secret)
+	} else {
+		auth.Env = append(auth.Env, "CF_USERNAME="+t.Username, "CF_PASSWORD="+secret)
+	}
+	for _, cmd := range []*exec.Cmd{t.cf(name, api...), auth, t.cf(name, "target", "-o", t.Org, "-s", t.Space)} {
+		err = cmd.Run()
+		if err != nil {
+			return fmt.Errorf("logging in to %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// smokeTest requests the smoke test path on the target's URL.
+func (t TargetConfig) smokeTest() string {
+	if t.URL == "" {
+		return "skipped, no url"
+	}
+	path := t.SmokeTest
+	if path == "" {
+		path = "/"
+	}
+	resp, err := httpClient.Get(strings.TrimRight(t.URL, "/") + path)
+	if err != nil {
+		return "failed: " + err.Error()
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "failed: " + resp.Status
+	}
+	return "passed"
+}
+
+// deployTargets logs in to each target in turn and runs the deploy there,
+// with the other deploy flags, then summarizes the results.
+func deployTargets(config *Config, names []string, args []string) {
+	for _, name := range names {
+		if _, ok := config.Targets[name]; !ok {
+			fmt.Printf("%s has no target %q; it defines: %s\n", configFile, name, strings.Join(config.targetNames(), ", "))
+			exitFailed("config")
+		}
+	}
+	if offline {
+		args = append(args, "--offline")
+	}
+
+	var results []targetResult
+	for _, name := range names {
+		target := config.Targets[name]
+		fmt.Printf("\n=== Deploying to %s (%s) ===\n", name, target.API)
+		started := time.Now()
+		err := target.login(name)
+		if err == nil {
+			cmd := target.cf(name, append([]string{"treeline", "deploy"}, args...)...)
+			cmd.Stdin = os.Stdin
+			err = cmd.Run()
+		}
+		result := targetResult{name: name, err: err, smoke: "skipped"}
+		if err == nil {
+			result.smoke = target.smokeTest()
+		}
+		result.duration = time.Since(started)
+		events.record("target-deployed", map[string]interface{}{"target": name, "ok": err == nil, "smoke": result.smoke})
+		results = append(results, result)
+	}
+
+	fmt.Println("\nTarget       Deploy   Smoke test                 Time")
+	failed := false
+	for _, result := range results {
+		status := "ok"
+		if result.err != nil {
+			status = "failed"
+		}
+		failed = failed || result.err != nil || strings.HasPrefix(result.smoke, "failed")
+		fmt.Printf("%-12s %-8s %-26s %s\n", result.name, status, result.smoke, result.duration.Round(time.Second))
+	}
+	if failed {
+		exitFailed("check-failed")
+	}
+}
+
+// withoutFlag removes a flag and its value from args.
+func withoutFlag(args []string, name string) []string {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == name || args[i] == "-"+name:
+			i++
+		case strings.HasPrefix(args[i], name+"=") || strings.HasPrefix(args[i], "-"+name+"="):
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return rest
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// telemetrySettings are kept per user. Telemetry is off until turned on
+// with 'cf treeline telemetry on --endpoint URL'.
+type telemetrySettings struct {
+	Enabled  bool   `json:"enabled"`
+	Endpoint string `json:"endpoint"`
+	// ID is random, so reports from one user can be grouped without
+	// saying who they are.
+	ID string `json:"id"`
+}
+
+// A usage report names the subcommand, never its arguments, the project or
+// the foundation.
+type usageReport struct {
+	ID         string `json:"id"`
+	Command    string `json:"command"`
+	DurationMS int64  `json:"duration_ms"`
+	Result     string `json:"result"`
+	Version    string `json:"version"`
+	OS         string `json:"os"`
+}
+
+var telemetry struct {
+	mutex    sync.Mutex
+	command  string
+	started  time.Time
+	settings telemetrySettings
+	sent     bool
+}
+
+func telemetryFile() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".treeline-cf", "telemetry.json")
+}
+
+func loadTelemetrySettings() telemetrySettings {
+	var settings telemetrySettings
+	data, err := ioutil.ReadFile(telemetryFile())
+	if err == nil {
+		json.Unmarshal(data, &settings)
+	}
+	return settings
+}
+
+func startTelemetry(command string) {
+	telemetry.mutex.Lock()
+	defer telemetry.mutex.Unlock()
+	telemetry.command = command
+	telemetry.started = time.Now()
+	telemetry.settings = loadTelemetrySettings()
+}
+
+// sendTelemetry reports how the command ended, once per run: "ok" or the
+// class of the first failure.
+func sendTelemetry(result string) {
+	telemetry.mutex.Lock()
+	defer telemetry.mutex.Unlock()
+	settings := telemetry.settings
+	if telemetry.sent || !settings.Enabled || settings.Endpoint == "" || offline {
+		return
+	}
+	telemetry.sent = true
+	command := telemetry.command
+	if command == "" {
+		command = "treeline"
+	}
+	report, err := json.Marshal(usageReport{
+		ID:         settings.ID,
+		Command:    command,
+		DurationMS: int64(time.Since(telemetry.started) / time.Millisecond),
+		Result:     result,
+		Version:    fmt.Sprintf("%d.%d.%d", pluginVersion.Major, pluginVersion.Minor, pluginVersion.Build),
+		OS:         runtime.GOOS,
+	})
+	if err != nil {
+		return
+	}
+	client := &http.Client{Timeout: 2 * time.Second, Transport: httpClient.Transport}
+	resp, err := client.Post(settings.Endpoint, "application/json", bytes.NewReader(report))
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+// succeed ends a subcommand that finished without error.
+func succeed() {
+	sendTelemetry("ok")
+	os.Exit(0)
+}
+
+// fail prints err and ends the subcommand, reporting the class of err.
+func fail(err error) {
+	fmt.Println(err)
+	exitFailed(errorClass(err))
+}
+
+// exitFailed ends a subcommand that failed, reporting class, which says
+// how without the message, as that can name apps or hold secrets.
+func exitFailed(class string) {
+	sendTelemetry(class)
+	os.Exit(1)
+}
+
+// errorClass sorts an error into network, API, command, file or config
+// failures, or "error" for the rest.
+func errorClass(err error) string {
+	var netErr net.Error
+	var exitErr *exec.ExitError
+	var pathErr *os.PathError
+	var yamlErr *yaml.TypeError
+	message := err.Error()
+	switch {
+	case errors.As(err, &netErr):
+		return "network"
+	case errors.Is(err, exec.ErrNotFound):
+		return "missing-tool"
+	case errors.As(err, &exitErr):
+		return "command"
+	case errors.As(err, &pathErr):
+		return "file"
+	case errors.As(err, &yamlErr) || strings.Contains(message, configFile):
+		return "config"
+	case capiFailure.MatchString(message):
+		return "api"
+	}
+	return "error"
+}
+
+// capiFailure matches the errors cfCurl returns for failed API requests.
+var capiFailure = regexp.MustCompile(`^(GET|POST|PUT|PATCH|DELETE) /`)
+
+// failureEvent reports whether an event records why a command failed.
+func failureEvent(event string) bool {
+	return strings.HasSuffix(event, "-failed") || strings.HasSuffix(event, "-denied") || strings.HasSuffix(event, "-closed") || strings.HasSuffix(event, "-frozen")
+}
+
+func telemetryCommand(args []string) {
+	if len(args) == 0 || (args[0] != "on" && args[0] != "off" && args[0] != "status") {
+		fmt.Println("Usage: cf treeline telemetry on --endpoint URL")
+		fmt.Println("       cf treeline telemetry off")
+		fmt.Println("       cf treeline telemetry status")
+		exitFailed("usage")
+	}
+	settings := loadTelemetrySettings()
+	switch args[0] {
+	case "status":
+		if !settings.Enabled {
+			fmt.Println("Telemetry is off")
+		} else {
+			fmt.Println("Telemetry is on, sending to", settings.Endpoint)
+		}
+		return
+	case "on":
+		flags := flag.NewFlagSet("telemetry on", flag.ExitOnError)
+		endpoint := flags.String("endpoint", settings.Endpoint, "`URL` usage reports are POSTed to")
+		flags.Parse(args[1:])
+		if *endpoint == "" {
+			fmt.Println("Usage: cf treeline telemetry on --endpoint URL")
+			exitFailed("usage")
+		}
+		settings.Enabled = true
+		settings.Endpoint = *endpoint
+		if settings.ID == "" {
+			id := make([]byte, 16)
+			rand.Read(id)
+			settings.ID = hex.EncodeToString(id)
+		}
+	case "off":
+		settings.Enabled = false
+	}
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(telemetryFile()), 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(telemetryFile(), data, 0644)
+	}
+	if err != nil {
+		fmt.Println("Could not save telemetry settings", err)
+		exitFailed(errorClass(err))
+	}
+	if settings.Enabled {
+		fmt.Println("Telemetry is on. Only the subcommand, its duration and how it ended are sent.")
+	} else {
+		fmt.Println("Telemetry is off")
+	}
+}
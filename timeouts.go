@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// timeouts parses the staging_timeout and start_timeout settings, which are
+// 0 when unset so the cf CLI defaults apply.
+func (c *Config) timeouts() (staging, start time.Duration, err error) {
+	if c.StagingTimeout != "" {
+		staging, err = time.ParseDuration(c.StagingTimeout)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid staging_timeout %q: %v", c.StagingTimeout, err)
+		}
+	}
+	if c.StartTimeout != "" {
+		start, err = time.ParseDuration(c.StartTimeout)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid start_timeout %q: %v", c.StartTimeout, err)
+		}
+	}
+	return staging, start, nil
+}
+
+// maxHealthCheckTimeout is the longest health check timeout the Cloud
+// Controller accepts by default.
+const maxHealthCheckTimeout = 180
+
+// healthCheckTimeout is start_timeout in seconds for cf push -t, or 0 when it
+// is unset or invalid, which start reports.
+func (c *Config) healthCheckTimeout() int {
+	_, start, err := c.timeouts()
+	if err != nil {
+		return 0
+	}
+	seconds := int(math.Ceil(start.Seconds()))
+	if seconds > maxHealthCheckTimeout {
+		return maxHealthCheckTimeout
+	}
+	return seconds
+}
+
+// minutes rounds up to the whole minutes CF_STAGING_TIMEOUT and
+// CF_STARTUP_TIMEOUT take.
+func minutes(duration time.Duration) string {
+	return fmt.Sprint(int(math.Ceil(duration.Minutes())))
+}
+
+// startWithTimeouts runs cf start in its own process, as the CLI only reads
+// its timeouts from the environment when it starts.
+func startWithTimeouts(name string, staging, start time.Duration) error {
+	cmd := command("cf", "start", name)
+	if staging > 0 {
+		cmd.Env = append(cmd.Env, "CF_STAGING_TIMEOUT="+minutes(staging))
+	}
+	if start > 0 {
+		cmd.Env = append(cmd.Env, "CF_STARTUP_TIMEOUT="+minutes(start))
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// startFailure says whether a failed start ran out of staging or start time,
+// by whether the app's latest build finished staging.
+func (d *deployment) startFailure(err error, staging, start time.Duration) error {
+	app, findErr := findApp(d.cliConnection, d.name)
+	if findErr != nil || app == nil {
+		return err
+	}
+	var builds struct {
+		Resources []struct {
+			State string `json:"state"`
+			Error string `json:"error"`
+		} `json:"resources"`
+	}
+	findErr = cfCurl(d.cliConnection, "GET", "/v3/builds?app_guids="+app.GUID+"&order_by=-created_at&per_page=1", nil, &builds)
+	if findErr != nil || len(builds.Resources) == 0 {
+		return err
+	}
+	setting := func(timeout time.Duration, name, flag string) string {
+		if timeout > 0 {
+			return fmt.Sprintf("%s (%s)", timeout, name)
+		}
+		return fmt.Sprintf("the cf CLI default; raise it with %s or %s", name, flag)
+	}
+	switch build := builds.Resources[0]; build.State {
+	case "STAGING":
+		return fmt.Errorf("staging timed out after %s: %v", setting(staging, "staging_timeout", "--staging-timeout"), err)
+	case "FAILED":
+		return fmt.Errorf("staging failed: %s", build.Error)
+	}
+	return fmt.Errorf("the app staged but did not start within %s: %v", setting(start, "start_timeout", "--start-timeout"), err)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ToolsConfig points at the node, npm and treeline executables to run
+// instead of the first ones on the PATH, for machines with several Node
+// installations. Set them in .treeline-cf.local.yml, as the paths differ
+// between machines, or with TREELINE_CF_NODE, TREELINE_CF_NPM and
+// TREELINE_CF_TREELINE, which take precedence.
+type ToolsConfig struct {
+	Node     string `yaml:"node,omitempty"`
+	Npm      string `yaml:"npm,omitempty"`
+	Treeline string `yaml:"treeline,omitempty"`
+}
+
+// toolPaths holds the configured executables by name.
+var toolPaths = map[string]string{}
+
+func useTools(config ToolsConfig) {
+	for name, path := range map[string]string{"node": config.Node, "npm": config.Npm, "treeline": config.Treeline} {
+		if path != "" {
+			toolPaths[name] = path
+		}
+	}
+}
+
+// toolPath returns the executable to run for name.
+func toolPath(name string) string {
+	if path := os.Getenv("TREELINE_CF_" + strings.ToUpper(name)); path != "" {
+		return expandHome(path)
+	}
+	if path, ok := toolPaths[name]; ok {
+		return expandHome(path)
+	}
+	return name
+}
+
+// toolPathEnv puts the directories of the configured executables first on
+// the PATH, so npm scripts and the #!/usr/bin/env node line of treeline and
+// npm run the same node.
+func toolPathEnv() []string {
+	var dirs []string
+	for _, name := range []string{"node", "npm", "treeline"} {
+		if path := toolPath(name); path != name {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+	return []string{"PATH=" + strings.Join(append(dirs, os.Getenv("PATH")), string(os.PathListSeparator))}
+}
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
@@ -0,0 +1,112 @@
+package main
+
+import "fmt"
+
+// TracingConfig adds a Sails hook that carries Zipkin B3 headers through the
+// app and reports a span per request to the collector.
+type TracingConfig struct {
+	// CollectorURL is the Zipkin v2 spans endpoint, such as
+	// http://zipkin.example.com:9411/api/v2/spans.
+	CollectorURL string `yaml:"collector_url,omitempty"`
+	// ServiceName defaults to the app name.
+	ServiceName string `yaml:"service_name,omitempty"`
+}
+
+func (c TracingConfig) enabled() bool {
+	return c.CollectorURL != ""
+}
+
+const tracingHookFile = "api/hooks/tracing/index.js"
+
+// tracingHook reuses the trace the Cloud Foundry router started when it has
+// tracing enabled, and starts one otherwise. Use
+// sails.hooks.tracing.headers(req) on outgoing requests to continue it.
+var tracingHook = []byte(`/**
+ * B3 trace propagation, generated by 'cf treeline config-pws'.
+ * Reads TRACING_COLLECTOR_URL and TRACING_SERVICE_NAME, set at deploy.
+ */
+
+var crypto = require('crypto');
+var http = require('http');
+var https = require('https');
+var url = require('url');
+
+function id() {
+  return crypto.randomBytes(8).toString('hex');
+}
+
+function report(span) {
+  var collector = process.env.TRACING_COLLECTOR_URL;
+  if (!collector) {
+    return;
+  }
+  var target = url.parse(collector);
+  var body = JSON.stringify([span]);
+  var req = (target.protocol === 'https:' ? https : http).request({
+    method: 'POST',
+    hostname: target.hostname,
+    port: target.port,
+    path: target.path,
+    headers: { 'Content-Type': 'application/json', 'Content-Length': Buffer.byteLength(body) }
+  });
+  req.on('error', function () {});
+  req.end(body);
+}
+
+module.exports = function tracing(sails) {
+  return {
+    headers: function (req) {
+      var trace = req.tracing || {};
+      return {
+        'X-B3-TraceId': trace.traceId,
+        'X-B3-SpanId': id(),
+        'X-B3-ParentSpanId': trace.spanId,
+        'X-B3-Sampled': '1'
+      };
+    },
+
+    routes: {
+      before: {
+        '/*': function (req, res, next) {
+          var trace = {
+            traceId: req.headers['x-b3-traceid'] || id() + id(),
+            spanId: req.headers['x-b3-spanid'] || id(),
+            parentId: req.headers['x-b3-parentspanid']
+          };
+          req.tracing = trace;
+          res.setHeader('X-B3-TraceId', trace.traceId);
+          var started = Date.now();
+          res.on('finish', function () {
+            report({
+              traceId: trace.traceId,
+              id: trace.spanId,
+              parentId: trace.parentId,
+              name: req.method + ' ' + (req.route ? req.route.path : req.path),
+              kind: 'SERVER',
+              timestamp: started * 1000,
+              duration: (Date.now() - started) * 1000,
+              localEndpoint: { serviceName: process.env.TRACING_SERVICE_NAME || 'sails' },
+              tags: {
+                'http.method': req.method,
+                'http.path': req.path,
+                'http.status_code': String(res.statusCode),
+                'cf.request_id': req.headers['x-vcap-request-id'] || ''
+              }
+            });
+          });
+          next();
+        }
+      }
+    }
+  };
+};
+`)
+
+func writeTracingHook() error {
+	err := writeGenerated(tracingHookFile, tracingHook)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Updated", tracingHookFile)
+	return nil
+}
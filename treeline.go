@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// treelineCompatible are the treeline CLI releases the plugin drives.
+const treelineCompatible = ">=0.3.0 <2.0.0"
+
+// TreelineConfig pins the treeline CLI teammates run, so they do not get
+// subtly different results from different releases.
+type TreelineConfig struct {
+	// Version is a range such as ~0.4.2 the installed treeline must match.
+	Version string `yaml:"version,omitempty"`
+	// OnMismatch is fail, the default, to refuse to run with another
+	// release, or warn.
+	OnMismatch string `yaml:"on_mismatch,omitempty"`
+}
+
+// installMissing installs the treeline CLI when it is not on the PATH. It is
+// set by --install-missing or TREELINE_CF_INSTALL_MISSING.
+var installMissing = os.Getenv("TREELINE_CF_INSTALL_MISSING") != ""
+
+var versionNumber = regexp.MustCompile(`\d+(\.\d+){0,2}`)
+
+// ensureTreeline makes sure a compatible treeline CLI is installed,
+// installing it globally with npm first when asked to. The config, which
+// has applied its tools paths, is nil when it could not be loaded; the
+// command reports why.
+func ensureTreeline(config *Config) error {
+	if _, err := exec.LookPath(toolPath("treeline")); err == nil {
+		return nil
+	}
+	if !installMissing {
+		return fmt.Errorf("Please install treeline using 'npm install -g treeline', or rerun with --install-missing")
+	}
+	if offline {
+		return fmt.Errorf("treeline is not installed, and --offline stops it being installed from npm")
+	}
+
+	wanted := treelineCompatible
+	var registry string
+	if config != nil {
+		if config.Treeline.Version != "" {
+			wanted = config.Treeline.Version
+		}
+		registry = config.Npm.Registry
+	}
+	args := []string{"install", "-g", "treeline@" + wanted}
+	// The registry is also passed on the command line, as a project .npmrc
+	// does not apply to global installs run from elsewhere.
+	if registry != "" {
+		args = append(args, "--registry", registry)
+	}
+	fmt.Println("Installing treeline with npm", strings.Join(args, " "))
+	install := command("npm", args...)
+	install.Stdout = os.Stdout
+	install.Stderr = os.Stderr
+	err := install.Run()
+	if err != nil {
+		return fmt.Errorf("could not install treeline: %v", err)
+	}
+
+	version, err := treelineVersion()
+	if err != nil {
+		return fmt.Errorf("treeline was installed but does not run, check that npm's global bin directory is on the PATH: %v", err)
+	}
+	constraint, _ := semver.NewConstraint(treelineCompatible)
+	if !constraint.Check(version) {
+		return fmt.Errorf("installed treeline %s, which is outside the supported %s", version, treelineCompatible)
+	}
+	fmt.Printf("Installed treeline %s\n", version)
+	return nil
+}
+
+// treelineVersion runs treeline --version.
+func treelineVersion() (*semver.Version, error) {
+	out, err := command("treeline", "--version").Output()
+	if err != nil {
+		return nil, err
+	}
+	number := versionNumber.FindString(string(out))
+	if number == "" {
+		return nil, fmt.Errorf("could not read a version from %q", strings.TrimSpace(string(out)))
+	}
+	return semver.NewVersion(number)
+}
+
+// checkTreelineVersion compares the installed treeline with the configured
+// pin, failing on a mismatch unless treeline.on_mismatch is warn.
+func checkTreelineVersion(config *Config) error {
+	pin := config.Treeline
+	if pin.Version == "" {
+		return nil
+	}
+	constraint, err := semver.NewConstraint(pin.Version)
+	if err != nil {
+		return fmt.Errorf("treeline.version %q in %s is not a valid range: %v", pin.Version, configFile, err)
+	}
+	version, err := treelineVersion()
+	if err != nil {
+		return fmt.Errorf("could not read the treeline version: %v", err)
+	}
+	if constraint.Check(version) {
+		return nil
+	}
+	message := fmt.Sprintf("treeline %s is installed, but %s pins %s; run 'npm install -g treeline@%s'", version, configFile, pin.Version, pin.Version)
+	switch pin.OnMismatch {
+	case "", "fail":
+		return fmt.Errorf("%s", message)
+	case "warn":
+		fmt.Println("Warning:", message)
+		return nil
+	}
+	return fmt.Errorf("treeline.on_mismatch in %s is %q, use fail or warn", configFile, pin.OnMismatch)
+}
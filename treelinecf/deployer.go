@@ -0,0 +1,120 @@
+package treelinecf
+
+import (
+	"fmt"
+	"sort"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// App is what Deployer pushes.
+type App struct {
+	Name string
+	// Path is the directory or zip to push, the current directory when
+	// empty.
+	Path       string
+	Memory     string
+	Instances  int
+	Buildpacks []string
+	Stack      string
+	// Command and HealthCheck are set for apps that are not web servers,
+	// such as workers with the "process" health check.
+	Command     string
+	HealthCheck string
+	NoRoute     bool
+	Env         map[string]string
+	Services    []Service
+
+	// HealthCheckTimeout is how many seconds an instance has to pass its
+	// health check after starting, the platform default when 0.
+	HealthCheckTimeout int
+	// ExtraArgs are appended to cf push as they are, for flags App does not
+	// model.
+	ExtraArgs []string
+}
+
+// PushArgs are the cf arguments that push the app without starting it.
+func (a App) PushArgs() []string {
+	args := []string{"push", a.Name, "--no-start"}
+	if a.NoRoute {
+		args = append(args, "--no-route")
+	}
+	if a.HealthCheck != "" {
+		args = append(args, "-u", a.HealthCheck)
+	}
+	if a.HealthCheckTimeout > 0 {
+		args = append(args, "-t", fmt.Sprint(a.HealthCheckTimeout))
+	}
+	if a.Command != "" {
+		args = append(args, "-c", a.Command)
+	}
+	if a.Memory != "" {
+		args = append(args, "-m", a.Memory)
+	}
+	for _, buildpack := range a.Buildpacks {
+		args = append(args, "-b", buildpack)
+	}
+	if a.Stack != "" {
+		args = append(args, "-s", a.Stack)
+	}
+	if a.Instances > 0 {
+		args = append(args, "-i", fmt.Sprint(a.Instances))
+	}
+	if a.Path != "" {
+		args = append(args, "-p", a.Path)
+	}
+	return append(args, a.ExtraArgs...)
+}
+
+// Deployer pushes apps to the targeted space.
+type Deployer struct {
+	Conn plugin.CliConnection
+}
+
+// Deploy pushes the app, sets its env, gives it its services and starts it.
+func (d Deployer) Deploy(app App) error {
+	err := d.Push(app)
+	if err == nil {
+		err = d.SetEnv(app.Name, app.Env)
+	}
+	if err == nil {
+		err = ServicePlanner{d.Conn}.Ensure(app.Name, app.Services)
+	}
+	if err == nil {
+		err = d.Start(app.Name)
+	}
+	return err
+}
+
+// Push uploads and stages the app without starting it.
+func (d Deployer) Push(app App) error {
+	_, err := d.Conn.CliCommand(app.PushArgs()...)
+	return err
+}
+
+// SetEnv sets the env vars on the app, in name order.
+func (d Deployer) SetEnv(name string, env map[string]string) error {
+	var keys []string
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		_, err := d.Conn.CliCommand("set-env", name, key, env[key])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d Deployer) Start(name string) error {
+	_, err := d.Conn.CliCommand("start", name)
+	return err
+}
+
+// Restart picks up env and binding changes on a running app.
+func (d Deployer) Restart(name string) error {
+	_, err := d.Conn.CliCommand("restart", name)
+	return err
+}
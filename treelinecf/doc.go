@@ -0,0 +1,6 @@
+// Package treelinecf is the part of the cf treeline plugin other Cloud
+// Foundry plugins and tools can reuse: pushing apps (Deployer), giving them
+// their services (ServicePlanner) and writing the Sails config that reads
+// those services (ConfigWriter). Everything runs through the
+// plugin.CliConnection the cf CLI hands a plugin.
+package treelinecf
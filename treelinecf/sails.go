@@ -0,0 +1,397 @@
+package treelinecf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// SailsServices are the VCAP_SERVICES labels the generated development
+// config reads credentials from.
+type SailsServices struct {
+	MySQL string
+	Redis string
+	// MySQLName, RedisName and PostgresName are the service instance names,
+	// looked up before the labels, then the services' tags. The binding
+	// names, when the services have them, are looked up before all of them.
+	MySQLName       string
+	RedisName       string
+	PostgresName    string
+	MySQLBinding    string
+	RedisBinding    string
+	PostgresBinding string
+	// MySQLDatabase is the database the binding parameters select, if any.
+	MySQLDatabase string
+	// MySQLParameters and RedisParameters are the binding parameters as
+	// JSON, noted in the generated config.
+	MySQLParameters string
+	RedisParameters string
+	// Postgres is used for the database instead of MySQL when set.
+	Postgres           string
+	PostgresParameters string
+	Pool               Pool
+}
+
+// Pool tunes the Postgres connection pool, for plans that allow fewer
+// connections than Sails opens by default. Zero values are left out.
+type Pool struct {
+	Size              int
+	IdleTimeoutMillis int64
+	// SSL is the adapter's ssl option as JavaScript, such as true or
+	// { rejectUnauthorized: false }.
+	SSL string
+}
+
+// ConfigWriter writes the Sails environment config for a project.
+type ConfigWriter struct {
+	// Dir is the Sails project, the current directory when empty.
+	Dir string
+	// Mode is the permission of the written files, 0644 when zero.
+	Mode os.FileMode
+}
+
+// DevelopmentFile, LocalFile and VCAPFile are written relative to Dir.
+// VCAPFile is outside config, as Sails would merge its exports into
+// sails.config.
+const (
+	DevelopmentFile = "config/env/development.js"
+	LocalFile       = "config/local.js"
+	VCAPFile        = "lib/vcap.js"
+)
+
+var vcapHelper = []byte(`/**
+ * Finds bound services in VCAP_SERVICES, generated by 'cf treeline config-pws'.
+ *
+ * Each key is tried in turn against the binding name, instance name, label
+ * and tags of every bound service, so the config keeps working when a broker
+ * is renamed or replaced by another offering with the same tag:
+ *
+ *   require('../lib/vcap').credentials('db', 'hackday-cleardb', 'cleardb', 'mysql')
+ */
+
+var services = [];
+if (process.env.VCAP_SERVICES) {
+  var vcapServices = JSON.parse(process.env.VCAP_SERVICES);
+  Object.keys(vcapServices).forEach(function (label) {
+    vcapServices[label].forEach(function (service) {
+      services.push(service);
+    });
+  });
+}
+
+function find() {
+  var keys = Array.prototype.slice.call(arguments);
+  for (var i = 0; i < keys.length; i++) {
+    for (var j = 0; j < services.length; j++) {
+      var service = services[j];
+      if (service.binding_name === keys[i] || service.name === keys[i] || service.label === keys[i] ||
+          (service.tags || []).indexOf(keys[i]) !== -1) {
+        return service;
+      }
+    }
+  }
+  return undefined;
+}
+
+function credentials() {
+  var service = find.apply(null, arguments);
+  if (!service) {
+    throw new Error('No bound service matches ' + Array.prototype.slice.call(arguments).join(', '));
+  }
+  return service.credentials;
+}
+
+module.exports = { find: find, credentials: credentials };
+`)
+
+// VCAPLookup quotes the non-empty keys as the JavaScript arguments of a
+// vcap.js find or credentials call.
+func VCAPLookup(keys ...string) string {
+	var quoted []string
+	for _, key := range keys {
+		if key != "" {
+			quoted = append(quoted, "'"+template.JSEscapeString(key)+"'")
+		}
+	}
+	return strings.Join(quoted, ", ")
+}
+
+var developmentTemplate = template.Must(template.New("development").Funcs(template.FuncMap{"lookup": VCAPLookup}).Parse(`
+/**
+ * Development environment settings
+ */
+
+if (process.env.VCAP_SERVICES) {
+  var vcap = require('../../lib/vcap');
+
+  /***************************************************************************
+   * Redis clients for the session store and sockets. Newer Redis offerings  *
+   * need TLS (a tls_port, a tls flag or a rediss:// uri in the credentials) *
+   * and a username as well as a password for their ACLs.                    *
+   ***************************************************************************/
+
+  var redis = require('redis');
+  var redisCredentials = vcap.credentials({{lookup .RedisBinding .RedisName .Redis "redis"}});
+  var redisTLS = Boolean(redisCredentials.tls_port || redisCredentials.tls || /^rediss:/.test(redisCredentials.uri || ''));
+  var redisClient = function (options) {
+    options = options || {};
+    options.host = redisCredentials.hostname || redisCredentials.host;
+    options.port = redisTLS && redisCredentials.tls_port ? redisCredentials.tls_port : redisCredentials.port;
+    if (redisTLS) {
+      options.tls = { servername: options.host };
+    }
+    if (!redisCredentials.username) {
+      options.password = redisCredentials.password;
+    }
+    var client = redis.createClient(options);
+    if (redisCredentials.username) {
+      client.auth(redisCredentials.username, redisCredentials.password);
+    }
+    return client;
+  };
+{{if not .Postgres}}
+  var mysqlCredentials = vcap.credentials({{lookup .MySQLBinding .MySQLName .MySQL "mysql"}});
+{{end}}
+  module.exports = {
+
+    /***************************************************************************
+     * Set the default database connection for models in the development       *
+     * environment (see config/connections.js and config/models.js )           *
+     ***************************************************************************/
+
+    models: {
+      connection: '{{if .Postgres}}sailsPostgresql{{else}}sailsMySql{{end}}',
+      migrate: 'alter'
+    },
+    connections: {
+      {{if .Postgres}}{{if .PostgresParameters}}// {{.Postgres}} is bound with the parameters {{.PostgresParameters}}
+      {{end}}sailsPostgresql: {
+        adapter: 'sails-postgresql',
+        url: vcap.credentials({{lookup .PostgresBinding .PostgresName .Postgres "postgresql" "postgres"}}).uri,{{if .Pool.Size}}
+        poolSize: {{.Pool.Size}},{{end}}{{if .Pool.IdleTimeoutMillis}}
+        poolIdleTimeout: {{.Pool.IdleTimeoutMillis}},{{end}}{{if .Pool.SSL}}
+        ssl: {{.Pool.SSL}},{{end}}
+      }{{else}}{{if .MySQLParameters}}// {{.MySQL}} is bound with the parameters {{.MySQLParameters}}
+      {{end}}sailsMySql: {
+        adapter: 'sails-mysql',
+        host      : mysqlCredentials.hostname,
+        port      : 3306,
+        user      : mysqlCredentials.username,
+        password  : mysqlCredentials.password,
+        database  : {{if .MySQLDatabase}}'{{js .MySQLDatabase}}'{{else}}mysqlCredentials.name{{end}}
+      }{{end}}
+    },
+
+    /***************************************************************************
+     * Session configuration                                                   *
+     ***************************************************************************/
+
+    {{if .RedisParameters}}// {{.Redis}} is bound with the parameters {{.RedisParameters}}
+    {{end}}session: {
+      adapter: 'redis',
+      client: redisClient(),
+      prefix: 'sess:',
+      // ttl: <redis session TTL in seconds>,
+      // db: 0,
+    },
+
+    /***************************************************************************
+     * WebSocket Configuration                                                 *
+     ***************************************************************************/
+
+    sockets: {
+      adapter: 'socket.io-redis',
+      adapterOptions: {
+        pubClient: redisClient(),
+        subClient: redisClient({ return_buffers: true })
+      },
+      // db: 'sails',
+    },
+
+    /***************************************************************************
+     * Set the port in the development environment to 80                       *
+     ***************************************************************************/
+
+    port: process.env.PORT,
+
+    /***************************************************************************
+     * Set the log level in development environment to "silent"                *
+     ***************************************************************************/
+
+    log: {
+       level: "verbose"
+    }
+
+  };
+}
+`))
+
+var localConfig = []byte(`
+/**
+ * Local environment settings
+ */
+
+module.exports = {
+
+  /***************************************************************************
+   * Set the default database connection for models in the local             *
+   * environment (see config/connections.js and config/models.js )           *
+   ***************************************************************************/
+
+  models: {
+    connection: 'localDiskDb',
+  },
+  connections: {
+    localDiskDb: {
+      adapter: 'sails-disk',
+    }
+  },
+
+  /***************************************************************************
+   * Session configuration                                                   *
+   ***************************************************************************/
+
+  session: {
+  },
+
+  /***************************************************************************
+   * WebSocket Configuration                                                 *
+   ***************************************************************************/
+
+  sockets: {
+  },
+
+  /***************************************************************************
+   * Set the port in the development environment to 80                       *
+   ***************************************************************************/
+
+  port: process.env.PORT || 1337,
+
+  /***************************************************************************
+   * Set the log level in development environment to "silent"                *
+   ***************************************************************************/
+
+  log: {
+     level: "verbose"
+  }
+
+};
+`)
+
+// WriteDevelopment writes the development environment config, which reads
+// the database, session store and socket adapter from the bound services
+// when running on Cloud Foundry.
+func (w ConfigWriter) WriteDevelopment(services SailsServices) error {
+	var config bytes.Buffer
+	err := developmentTemplate.Execute(&config, services)
+	if err != nil {
+		return err
+	}
+	return w.write(DevelopmentFile, config.Bytes())
+}
+
+// WriteVCAP writes the vcap.js helper the generated config finds its
+// services with.
+func (w ConfigWriter) WriteVCAP() error {
+	return w.write(VCAPFile, vcapHelper)
+}
+
+// WriteLocal writes the local config, which uses sails-disk, so the app
+// still runs on a laptop without the services.
+func (w ConfigWriter) WriteLocal() error {
+	return w.write(LocalFile, localConfig)
+}
+
+func (w ConfigWriter) write(name string, data []byte) error {
+	mode := w.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	return WriteManaged(filepath.Join(w.Dir, filepath.FromSlash(name)), data, mode)
+}
+
+// BeginManaged and EndManaged mark the part of a generated JavaScript file
+// that is rewritten when it is generated again. Everything outside them is
+// the user's and kept.
+const (
+	BeginManaged = "// BEGIN cf treeline managed block: changes in here are overwritten by 'cf treeline config-pws'"
+	EndManaged   = "// END cf treeline managed block"
+)
+
+const managedTrailer = "// Add your own settings after this line, such as module.exports.custom = {};\n"
+
+// Unmanaged reports whether file exists without the managed block markers,
+// so WriteManaged would set it aside as file.orig.
+func Unmanaged(file string) bool {
+	existing, err := ioutil.ReadFile(file)
+	return err == nil && !strings.Contains(string(existing), BeginManaged)
+}
+
+// WriteManaged writes generated JavaScript between the managed block
+// markers. When file already has the markers only the block is replaced,
+// so keys and comments the user added around it survive. A file without
+// them is kept as file.orig before being replaced.
+func WriteManaged(file string, data []byte, mode os.FileMode) error {
+	block := BeginManaged + "\n" + strings.TrimPrefix(string(data), "\n")
+	if !strings.HasSuffix(block, "\n") {
+		block += "\n"
+	}
+	block += EndManaged + "\n"
+
+	existing, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return WriteFile(file, []byte(block+"\n"+managedTrailer), mode)
+	}
+	if err != nil {
+		return err
+	}
+	current := string(existing)
+	begin := strings.Index(current, BeginManaged)
+	end := strings.Index(current, EndManaged)
+	if begin < 0 || end < begin {
+		err = WriteFile(file+".orig", existing, mode)
+		if err != nil {
+			return err
+		}
+		return WriteFile(file, []byte(block+"\n"+managedTrailer), mode)
+	}
+	rest := current[end+len(EndManaged):]
+	rest = strings.TrimPrefix(strings.TrimPrefix(rest, "\r"), "\n")
+	return WriteFile(file, []byte(current[:begin]+block+rest), mode)
+}
+
+// WriteFile writes data to a temporary file next to file and renames it
+// over file, so an interrupted write never leaves half a config behind.
+// Missing parent directories are created, readable and searchable by
+// whoever may read the file.
+func WriteFile(file string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(file)
+	err := os.MkdirAll(dir, mode|(mode&0444)>>2)
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(dir, "."+filepath.Base(file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Chmod(mode)
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package treelinecf
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// Service is a service instance an app is bound to.
+type Service struct {
+	Name string
+	// Offering and Plan create the instance when it is missing. They are
+	// empty for user-provided services, which must already exist.
+	Offering string
+	Plan     string
+	// Parameters are passed to the broker when binding.
+	Parameters map[string]interface{}
+	// BindingName names the binding, so the app can find the credentials
+	// by their role, such as db, whatever the instance and offering are.
+	BindingName string
+}
+
+// BindArgs are the cf arguments that bind the service to app.
+func (s Service) BindArgs(app string) ([]string, error) {
+	args := []string{"bs", app, s.Name}
+	if s.BindingName != "" {
+		args = append(args, "--binding-name", s.BindingName)
+	}
+	if len(s.Parameters) == 0 {
+		return args, nil
+	}
+	parameters, err := json.Marshal(s.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("service %s has parameters that are not valid JSON: %v", s.Name, err)
+	}
+	return append(args, "-c", string(parameters)), nil
+}
+
+// A ServiceChange is one thing ServicePlanner does: create an instance or
+// bind it.
+type ServiceChange struct {
+	// Action is "create" or "bind".
+	Action  string
+	Service Service
+}
+
+// ServicePlanner works out, and makes, the changes that give an app its
+// services in the targeted space.
+type ServicePlanner struct {
+	Conn plugin.CliConnection
+}
+
+// Plan lists the instances to create and bindings to add, in the order to
+// make them.
+func (p ServicePlanner) Plan(app string, services []Service) ([]ServiceChange, error) {
+	existing, err := p.Conn.GetServices()
+	if err != nil {
+		return nil, err
+	}
+	var changes []ServiceChange
+	for _, service := range services {
+		found, bound := false, false
+		for _, instance := range existing {
+			if instance.Name == service.Name {
+				found = true
+				for _, name := range instance.ApplicationNames {
+					if name == app {
+						bound = true
+					}
+				}
+			}
+		}
+		if !found && service.Offering == "" {
+			return nil, fmt.Errorf("service %s does not exist and has no service and plan to create it from", service.Name)
+		}
+		if !found {
+			changes = append(changes, ServiceChange{"create", service})
+		}
+		if !bound {
+			changes = append(changes, ServiceChange{"bind", service})
+		}
+	}
+	return changes, nil
+}
+
+// Apply makes the changes Plan returned.
+func (p ServicePlanner) Apply(app string, changes []ServiceChange) error {
+	for _, change := range changes {
+		args := []string{"cs", change.Service.Offering, change.Service.Plan, change.Service.Name}
+		if change.Action == "bind" {
+			var err error
+			args, err = change.Service.BindArgs(app)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := p.Conn.CliCommand(args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ensure creates and binds whatever of services the app is missing.
+func (p ServicePlanner) Ensure(app string, services []Service) error {
+	changes, err := p.Plan(app, services)
+	if err != nil {
+		return err
+	}
+	return p.Apply(app, changes)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+const uiRefresh = 5 * time.Second
+
+// dashboard is the state of 'cf treeline ui'.
+type dashboard struct {
+	cliConnection plugin.CliConnection
+	app           string
+	// logsOnly shows a full screen of logs instead of the overview.
+	logsOnly bool
+	message  string
+	rows     int
+	cols     int
+}
+
+// ui is a terminal dashboard for the configured app: health, instances,
+// services and recent logs, with keys to restart, scale and tail logs.
+func ui(cliConnection plugin.CliConnection, config *Config, args []string) {
+	if runtime.GOOS == "windows" {
+		fmt.Println("cf treeline ui needs a Unix terminal")
+		exitFailed("unsupported")
+	}
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	} else {
+		name = config.App
+	}
+
+	restore, err := rawTerminal()
+	if err != nil {
+		fmt.Println("Could not switch the terminal to raw mode:", err)
+		exitFailed(errorClass(err))
+	}
+	defer restore()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	keys := make(chan byte)
+	go func() {
+		buffer := make([]byte, 1)
+		for {
+			n, err := os.Stdin.Read(buffer)
+			if err != nil {
+				close(keys)
+				return
+			}
+			if n == 1 {
+				keys <- buffer[0]
+			}
+		}
+	}()
+
+	d := &dashboard{cliConnection: cliConnection, app: name}
+	ticker := time.NewTicker(uiRefresh)
+	defer ticker.Stop()
+	d.render()
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok || !d.handle(key) {
+				fmt.Print("\x1b[2J\x1b[H")
+				return
+			}
+		case <-ticker.C:
+		case <-signals:
+			return
+		}
+		d.render()
+	}
+}
+
+// handle acts on a key and reports whether to keep running.
+func (d *dashboard) handle(key byte) bool {
+	d.message = ""
+	switch key {
+	case 'q', 3, 4: // q, Ctrl-C, Ctrl-D
+		return false
+	case 'r':
+		d.status("Restarting " + d.app + "...")
+		_, err := d.cliConnection.CliCommandWithoutTerminalOutput("restart", d.app)
+		d.message = "Restarted " + d.app
+		if err != nil {
+			d.message = "Restart failed: " + err.Error()
+		}
+	case '+', '-':
+		app, err := d.cliConnection.GetApp(d.app)
+		if err != nil {
+			d.message = err.Error()
+			break
+		}
+		instances := app.InstanceCount + 1
+		if key == '-' {
+			instances = app.InstanceCount - 1
+		}
+		if instances < 1 {
+			d.message = "Already at one instance"
+			break
+		}
+		d.status(fmt.Sprintf("Scaling to %d instances...", instances))
+		_, err = d.cliConnection.CliCommandWithoutTerminalOutput("scale", d.app, "-i", fmt.Sprint(instances))
+		d.message = fmt.Sprintf("Scaled to %d instances", instances)
+		if err != nil {
+			d.message = "Scale failed: " + err.Error()
+		}
+	case 'l':
+		d.logsOnly = !d.logsOnly
+	}
+	return true
+}
+
+// status shows a message while a slow action runs.
+func (d *dashboard) status(message string) {
+	fmt.Printf("\x1b[%d;1H\x1b[2K%s", d.rows, message)
+}
+
+func (d *dashboard) render() {
+	d.rows, d.cols = terminalSize()
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		line := fmt.Sprintf(format, args...)
+		if len(line) > d.cols {
+			line = line[:d.cols]
+		}
+		lines = append(lines, line)
+	}
+
+	app, err := d.cliConnection.GetApp(d.app)
+	if err != nil {
+		add("\x1b[1m%s\x1b[0m  could not read the app: %v", d.app, err)
+	} else if !d.logsOnly {
+		add("\x1b[1m%s\x1b[0m  %s  %d/%d instances running  %dM  %s", d.app, app.State, app.RunningInstances, app.InstanceCount, app.Memory, time.Now().Format("15:04:05"))
+		add("")
+		add("%-4s %-10s %-8s %-16s %s", "#", "STATE", "CPU", "MEMORY", "SINCE")
+		for i, instance := range app.Instances {
+			since := ""
+			if !instance.Since.IsZero() {
+				since = time.Since(instance.Since).Round(time.Second).String()
+			}
+			add("%-4d %-10s %-8s %-16s %s", i, strings.ToLower(instance.State), fmt.Sprintf("%.1f%%", instance.CpuUsage*100),
+				fmt.Sprintf("%dM/%dM", instance.MemUsage/1024/1024, instance.MemQuota/1024/1024), since)
+		}
+		add("")
+		var services []string
+		for _, service := range app.Services {
+			services = append(services, service.Name)
+		}
+		add("Services: %s", strings.Join(services, ", "))
+		add("")
+	} else {
+		add("\x1b[1m%s\x1b[0m  recent logs  %s", d.app, time.Now().Format("15:04:05"))
+	}
+
+	logRows := d.rows - len(lines) - 2
+	recent, _ := recentLogs(d.cliConnection, d.app)
+	if logRows > 0 && len(recent) > logRows {
+		recent = recent[len(recent)-logRows:]
+	}
+	for _, line := range recent {
+		if logRows <= 0 {
+			break
+		}
+		add("%s", strings.TrimSpace(line))
+	}
+
+	help := "r restart  + - scale  l toggle logs  q quit"
+	if d.message != "" {
+		help = d.message
+	}
+	fmt.Print("\x1b[2J\x1b[H" + strings.Join(lines, "\r\n"))
+	fmt.Printf("\x1b[%d;1H\x1b[7m%s\x1b[0m", d.rows, help)
+}
+
+// rawTerminal turns off line buffering and echo until the returned function
+// is called.
+func rawTerminal() (func(), error) {
+	saved := command("stty", "-g")
+	saved.Stdin = os.Stdin
+	state, err := saved.Output()
+	if err != nil {
+		return nil, err
+	}
+	raw := command("stty", "raw", "-echo")
+	raw.Stdin = os.Stdin
+	err = raw.Run()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Print("\x1b[?25l")
+	return func() {
+		restore := command("stty", strings.TrimSpace(string(state)))
+		restore.Stdin = os.Stdin
+		restore.Run()
+		fmt.Print("\x1b[?25h\r\n")
+	}, nil
+}
+
+// terminalSize falls back to 24x80 when stty cannot tell.
+func terminalSize() (int, int) {
+	size := command("stty", "size")
+	size.Stdin = os.Stdin
+	out, err := size.Output()
+	var rows, cols int
+	if err == nil {
+		fmt.Sscan(string(out), &rows, &cols)
+	}
+	if rows == 0 || cols == 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// pluginVersion is the version reported to the cf CLI and compared with the
+// latest release.
+var pluginVersion = plugin.VersionType{Major: 1, Minor: 0, Build: 0}
+
+const releasesURL = "https://api.github.com/repos/SocalNick/cf-treeline-cli/releases/latest"
+
+const updateCheckInterval = 24 * time.Hour
+
+type updateCheck struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Latest    string    `json:"latest"`
+}
+
+func updateCheckFile() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".treeline-cf", "update-check.json")
+}
+
+// checkForUpdate prints a notice when the last check found a newer release,
+// and refreshes the check in the background once it is a day old, so
+// commands never wait on it. TREELINE_CF_NO_UPDATE_CHECK or
+// update_check: false in the config, which is nil when it could not be
+// loaded, turn it off.
+func checkForUpdate(config *Config) {
+	if offline || os.Getenv("TREELINE_CF_NO_UPDATE_CHECK") != "" {
+		return
+	}
+	if config != nil && config.UpdateCheck != nil && !*config.UpdateCheck {
+		return
+	}
+	var last updateCheck
+	data, err := ioutil.ReadFile(updateCheckFile())
+	if err == nil {
+		json.Unmarshal(data, &last)
+	}
+	if newerVersion(last.Latest, pluginVersion) {
+		fmt.Fprintf(os.Stderr, "cf treeline %s is available (you have %d.%d.%d): https://github.com/SocalNick/cf-treeline-cli/releases\n",
+			last.Latest, pluginVersion.Major, pluginVersion.Minor, pluginVersion.Build)
+	}
+	if time.Since(last.CheckedAt) > updateCheckInterval {
+		go refreshUpdateCheck()
+	}
+}
+
+func refreshUpdateCheck() {
+	resp, err := httpClient.Get(releasesURL)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if resp.StatusCode != 200 || json.NewDecoder(resp.Body).Decode(&release) != nil {
+		return
+	}
+	data, err := json.Marshal(updateCheck{CheckedAt: time.Now(), Latest: release.TagName})
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(updateCheckFile()), 0755) == nil {
+		ioutil.WriteFile(updateCheckFile(), data, 0644)
+	}
+}
+
+// newerVersion reports whether a release tag such as v1.2.0 is newer than
+// current.
+func newerVersion(tag string, current plugin.VersionType) bool {
+	parts := strings.SplitN(strings.TrimPrefix(tag, "v"), ".", 3)
+	if len(parts) != 3 {
+		return false
+	}
+	var numbers [3]int
+	for i, part := range parts {
+		number, err := strconv.Atoi(strings.SplitN(part, "-", 2)[0])
+		if err != nil {
+			return false
+		}
+		numbers[i] = number
+	}
+	for i, have := range []int{current.Major, current.Minor, current.Build} {
+		if numbers[i] != have {
+			return numbers[i] > have
+		}
+	}
+	return false
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// UploadConfig uploads the app's bits through the v3 packages API instead of
+// cf push, for big packages on slow or flaky connections. Only files the
+// Cloud Controller does not already have from an earlier push are sent.
+// Uploads are not resumable: the Cloud Controller takes a package in one
+// request, so a failed upload is sent again from the start.
+type UploadConfig struct {
+	// Bandwidth caps the upload, such as 512K or 2M bytes a second.
+	Bandwidth string `yaml:"bandwidth,omitempty"`
+	// Retries is how many times a failed upload is sent again in full, 3
+	// by default.
+	Retries int `yaml:"retries,omitempty"`
+}
+
+func (c UploadConfig) enabled() bool {
+	return c.Bandwidth != "" || c.Retries > 0
+}
+
+func (c UploadConfig) retries() int {
+	if c.Retries > 0 {
+		return c.Retries
+	}
+	return 3
+}
+
+// minimumResourceSize is the smallest file the Cloud Controller caches, so
+// smaller ones are not worth matching.
+const minimumResourceSize = 64 * 1024
+
+// resourceMatchBatch is how many files one resource match request asks about.
+const resourceMatchBatch = 1000
+
+// appResource identifies a file by content for the resource match API.
+type appResource struct {
+	Checksum struct {
+		Value string `json:"value"`
+	} `json:"checksum"`
+	Size int64  `json:"size_in_bytes"`
+	Path string `json:"path,omitempty"`
+	Mode string `json:"mode,omitempty"`
+}
+
+// uploadChunk is how much is read, and so sent, between bandwidth checks.
+const uploadChunk = 32 * 1024
+
+type v3Package struct {
+	GUID  string `json:"guid"`
+	State string `json:"state"`
+}
+
+// bytesPerSecond parses a bandwidth such as 512K, 2M or 2MB/s.
+func bytesPerSecond(rate string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(rate))
+	value = strings.TrimSuffix(value, "/S")
+	value = strings.TrimSuffix(value, "B")
+	multiplier := int64(1)
+	for suffix, size := range map[string]int64{"K": 1024, "M": 1024 * 1024, "G": 1024 * 1024 * 1024} {
+		if strings.HasSuffix(value, suffix) {
+			multiplier = size
+			value = strings.TrimSuffix(value, suffix)
+		}
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q", rate)
+	}
+	return number * multiplier, nil
+}
+
+// throttledReader reads no faster than rate bytes a second on average.
+type throttledReader struct {
+	reader  io.Reader
+	rate    int64
+	started time.Time
+	read    int64
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.started.IsZero() {
+		t.started = time.Now()
+	}
+	if len(p) > uploadChunk {
+		p = p[:uploadChunk]
+	}
+	n, err := t.reader.Read(p)
+	t.read += int64(n)
+	due := time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second))
+	if ahead := due - time.Since(t.started); ahead > 0 {
+		time.Sleep(ahead)
+	}
+	return n, err
+}
+
+// uploadBits replaces the bits of the pushed app with a new package built
+// from path, a directory or zip. cf start stages the newest package.
+func (d *deployment) uploadBits() error {
+	config := d.config.Upload
+	var rate int64
+	if config.Bandwidth != "" {
+		var err error
+		rate, err = bytesPerSecond(config.Bandwidth)
+		if err != nil {
+			return err
+		}
+	}
+	archive := d.pushPath()
+	var matched []appResource
+	if info, err := os.Stat(archive); err != nil {
+		return err
+	} else if info.IsDir() {
+		matched, err = matchResources(d.cliConnection, archive)
+		if err != nil {
+			return fmt.Errorf("matching resources: %v", err)
+		}
+		cached := map[string]bool{}
+		for _, resource := range matched {
+			cached[resource.Path] = true
+		}
+		archive, err = zipAppFiles(archive, cached)
+		if err != nil {
+			return fmt.Errorf("zipping %s: %v", d.pushPath(), err)
+		}
+		defer os.Remove(archive)
+	}
+
+	app, err := findApp(d.cliConnection, d.name)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("app %s not found", d.name)
+	}
+	var created v3Package
+	err = cfCurl(d.cliConnection, "POST", "/v3/packages", map[string]interface{}{
+		"type":          "bits",
+		"relationships": map[string]interface{}{"app": map[string]interface{}{"data": map[string]string{"guid": app.GUID}}},
+	}, &created)
+	if err != nil {
+		return err
+	}
+
+	// The Cloud Controller takes a package in one request, so a failed
+	// upload is sent again, unless it got through before the connection
+	// dropped.
+	for attempt := 1; ; attempt++ {
+		err = uploadPackage(d.cliConnection, created.GUID, archive, matched, rate)
+		if err == nil {
+			break
+		}
+		var current v3Package
+		if cfCurl(d.cliConnection, "GET", "/v3/packages/"+created.GUID, nil, &current) == nil && current.State != "AWAITING_UPLOAD" {
+			break
+		}
+		if attempt > config.retries() {
+			return fmt.Errorf("uploading %s: %v", d.pushPath(), err)
+		}
+		wait := time.Duration(attempt*attempt) * 5 * time.Second
+		fmt.Printf("Upload failed (%v), trying again in %s\n", err, wait)
+		time.Sleep(wait)
+	}
+
+	for {
+		var uploaded v3Package
+		err = cfCurl(d.cliConnection, "GET", "/v3/packages/"+created.GUID, nil, &uploaded)
+		if err != nil {
+			return err
+		}
+		switch uploaded.State {
+		case "READY":
+			fmt.Printf("Uploaded package %s for %s\n", created.GUID, d.name)
+			return nil
+		case "FAILED", "EXPIRED":
+			return fmt.Errorf("package upload %s", strings.ToLower(uploaded.State))
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// matchResources returns the files under root the Cloud Controller already
+// has, so they need not be uploaded again.
+func matchResources(cliConnection plugin.CliConnection, root string) ([]appResource, error) {
+	var candidates []appResource
+	err := walkAppFiles(root, func(path string, info os.FileInfo) error {
+		if info.Size() < minimumResourceSize {
+			return nil
+		}
+		resource := appResource{Size: info.Size(), Path: path, Mode: fmt.Sprintf("%o", info.Mode().Perm())}
+		var err error
+		resource.Checksum.Value, err = fileSHA1(filepath.Join(root, filepath.FromSlash(path)))
+		candidates = append(candidates, resource)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []appResource
+	var matchedSize int64
+	for start := 0; start < len(candidates); start += resourceMatchBatch {
+		batch := candidates[start:]
+		if len(batch) > resourceMatchBatch {
+			batch = batch[:resourceMatchBatch]
+		}
+		var request, response struct {
+			Resources []appResource `json:"resources"`
+		}
+		for _, resource := range batch {
+			resource.Path, resource.Mode = "", ""
+			request.Resources = append(request.Resources, resource)
+		}
+		err = cfCurl(cliConnection, "POST", "/v3/resource_matches", request, &response)
+		if err != nil {
+			return nil, err
+		}
+		known := map[string]bool{}
+		for _, resource := range response.Resources {
+			known[resource.Checksum.Value] = true
+		}
+		for _, resource := range batch {
+			if known[resource.Checksum.Value] {
+				matched = append(matched, resource)
+				matchedSize += resource.Size
+			}
+		}
+	}
+	if len(matched) > 0 {
+		fmt.Printf("Skipping %d unchanged files (%d KB) the Cloud Controller already has\n", len(matched), matchedSize/1024)
+	}
+	return matched, nil
+}
+
+// uploadPackage sends the zip as the package's bits, with the matched
+// resources it leaves out, at most rate bytes a second when rate is not 0.
+func uploadPackage(cliConnection plugin.CliConnection, guid, archive string, matched []appResource, rate int64) error {
+	resources, err := json.Marshal(matched)
+	if err != nil {
+		return err
+	}
+	if matched == nil {
+		resources = []byte("[]")
+	}
+	bits, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer bits.Close()
+	var source io.Reader = bits
+	if rate > 0 {
+		source = &throttledReader{reader: bits, rate: rate}
+	}
+	if info, err := bits.Stat(); err == nil {
+		fmt.Printf("Uploading %d KB\n", info.Size()/1024)
+	}
+
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		err := form.WriteField("resources", string(resources))
+		var part io.Writer
+		if err == nil {
+			part, err = form.CreateFormFile("bits", "app.zip")
+		}
+		if err == nil {
+			_, err = io.Copy(part, source)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	resp, err := capiRequest(cliConnection, "POST", "/v3/packages/"+guid+"/upload", body, form.FormDataContentType())
+	body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// zipAppFiles zips the files cf push would upload from root, except those in
+// skip, into a temporary file, returning its name.
+func zipAppFiles(root string, skip map[string]bool) (string, error) {
+	out, err := ioutil.TempFile("", "treeline-cf-*.zip")
+	if err != nil {
+		return "", err
+	}
+	archive := zip.NewWriter(out)
+	err = walkAppFiles(root, func(path string, info os.FileInfo) error {
+		if skip[path] {
+			return nil
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = path
+		header.Method = zip.Deflate
+		writer, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(filepath.Join(root, filepath.FromSlash(path)))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(writer, file)
+		return err
+	})
+	if err == nil {
+		err = archive.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// placeholderDir is pushed in place of the app when its bits are uploaded
+// separately, so cf push only applies the app's settings.
+func placeholderDir() (string, error) {
+	dir, err := ioutil.TempDir("", "treeline-cf-placeholder")
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "placeholder.txt"), []byte("Replaced by the uploaded package.\n"), 0644)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
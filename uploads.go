@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// UploadsConfig picks where skipper stores file uploads. Instances have an
+// ephemeral disk, so uploads only survive restarts in a bucket.
+type UploadsConfig struct {
+	// Service is an S3-compatible service instance, listed under services
+	// so it is bound, that holds uploads on Cloud Foundry. Uploads go to Dir
+	// on the local disk when it is empty or the app runs locally.
+	Service string `yaml:"service,omitempty"`
+	Dir     string `yaml:"dir,omitempty"`
+}
+
+var uploadsTemplate = template.Must(template.New("uploads").Parse(`/**
+ * File upload storage, generated by 'cf treeline config-pws'.
+ * Pass it to skipper with req.file('name').upload(sails.config.uploads, cb).
+ */
+
+var uploads = {
+  adapter: require('skipper-disk'),
+  dirname: require('path').resolve('{{.Dir}}')
+};
+{{if .Service}}
+var bucket = require('../lib/vcap').find('{{js .Service}}');
+if (bucket) {
+  var credentials = bucket.credentials;
+  uploads = {
+    adapter: require('skipper-s3'),
+    key: credentials.access_key_id || credentials.aws_access_key_id,
+    secret: credentials.secret_access_key || credentials.aws_secret_access_key,
+    bucket: credentials.bucket || credentials.bucket_name,
+    region: credentials.region,
+    endpoint: credentials.endpoint
+  };
+}
+{{end}}
+module.exports.uploads = uploads;
+`))
+
+// uploadPackages are the skipper adapters config/uploads.js requires.
+func uploadPackages(config *Config) []string {
+	if config.Uploads.Service == "" {
+		return []string{"skipper-disk"}
+	}
+	return []string{"skipper-disk", "skipper-s3"}
+}
+
+func writeUploadsConfig(config *Config) error {
+	uploads := config.Uploads
+	if uploads.Dir == "" {
+		uploads.Dir = ".tmp/uploads"
+	}
+	var uploadsConfig bytes.Buffer
+	err := uploadsTemplate.Execute(&uploadsConfig, uploads)
+	if err != nil {
+		return err
+	}
+	err = writeGenerated("config/uploads.js", uploadsConfig.Bytes())
+	if err != nil {
+		return err
+	}
+	fmt.Println("Updated config/uploads.js")
+	if uploads.Service == "" {
+		fmt.Printf("Uploads are kept on the instance's disk and lost on restart; set uploads.service in %s to store them in a bucket\n", configFile)
+	}
+	return nil
+}
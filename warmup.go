@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WarmupConfig lists requests sent once the app is running, to prime the
+// JIT and Waterline caches before real users arrive.
+type WarmupConfig struct {
+	Paths   []string          `yaml:"paths,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Count is how many times each path is requested, 1 by default.
+	Count int `yaml:"count,omitempty"`
+}
+
+// warmup requests each configured path on the app's first route. Failed
+// requests are reported but do not fail the deploy, since the app already
+// passed its health check.
+func (d *deployment) warmup() error {
+	config := d.config.Warmup
+	if len(config.Paths) == 0 {
+		return nil
+	}
+	base := appURL(d.cliConnection, d.name)
+	if base == "" {
+		fmt.Println("Skipping warmup, the app has no route")
+		return nil
+	}
+	count := config.Count
+	if count < 1 {
+		count = 1
+	}
+
+	for _, path := range config.Paths {
+		target := strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+		var slowest time.Duration
+		failed := 0
+		for i := 0; i < count; i++ {
+			req, err := http.NewRequest("GET", target, nil)
+			if err != nil {
+				return err
+			}
+			for key, value := range config.Headers {
+				req.Header.Set(key, value)
+			}
+			started := time.Now()
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				failed++
+				continue
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			if elapsed := time.Since(started); elapsed > slowest {
+				slowest = elapsed
+			}
+			if resp.StatusCode >= 400 {
+				failed++
+			}
+		}
+		fmt.Printf("Warmed up %s: %d requests, %d failed, slowest %v\n", path, count, failed, slowest.Round(time.Millisecond))
+		events.record("warmup", map[string]interface{}{"path": path, "requests": count, "failed": failed})
+	}
+	return nil
+}
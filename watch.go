@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+type watchOptions struct {
+	window       time.Duration
+	maxErrorRate float64
+	maxCrashes   int
+	rollback     bool
+}
+
+const watchInterval = 30 * time.Second
+
+func watch(cliConnection plugin.CliConnection, config *Config, args []string) {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	window := flags.Duration("window", 10*time.Minute, "how long to watch the app")
+	maxErrorRate := flags.Float64("max-error-rate", 5, "fail when more than this percent of requests get a 5xx status")
+	maxCrashes := flags.Int("max-crashes", 0, "fail when instances crash more than this many times")
+	rollback := flags.Bool("rollback", false, "go back to the previous droplet when a threshold is exceeded")
+	flags.Parse(args)
+
+	name := config.App
+	if flags.NArg() > 0 {
+		name = flags.Arg(0)
+	}
+	d := newDeployment(cliConnection, config, name)
+	err := d.recordPreviousVersion()
+	if err == nil {
+		err = d.watch(watchOptions{*window, *maxErrorRate, *maxCrashes, *rollback})
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+// watch counts 5xx responses in the router logs and crash events for the
+// window, failing as soon as either passes its threshold. With rollback the
+// app goes back to the droplet it ran before.
+func (d *deployment) watch(options watchOptions) error {
+	if d.appGUID == "" {
+		return fmt.Errorf("app %s not found", d.name)
+	}
+	fmt.Printf("Watching %s for %v\n", d.name, options.window)
+	started := time.Now()
+	seen := map[string]bool{}
+	requests, failures := 0, 0
+	for {
+		lines, err := recentLogs(d.cliConnection, d.name)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			match := routerStatus.FindStringSubmatch(line)
+			if match == nil || seen[line] {
+				continue
+			}
+			seen[line] = true
+			requests++
+			if match[1][0] == '5' {
+				failures++
+			}
+		}
+		crashes, err := crashEventsSince(d, d.appGUID, started)
+		if err != nil {
+			return err
+		}
+
+		rate := 0.0
+		if requests > 0 {
+			rate = 100 * float64(failures) / float64(requests)
+		}
+		var breach error
+		switch {
+		case rate > options.maxErrorRate:
+			breach = fmt.Errorf("%d of %d requests got a 5xx status (%.1f%%), above %.1f%%", failures, requests, rate, options.maxErrorRate)
+		case len(crashes) > options.maxCrashes:
+			breach = fmt.Errorf("instances crashed %d times, above %d", len(crashes), options.maxCrashes)
+		}
+		if breach != nil {
+			events.record("watch-failed", map[string]interface{}{"app": d.name, "error": breach.Error()})
+			if options.rollback {
+				return d.rollBack(breach)
+			}
+			return breach
+		}
+		if time.Since(started) >= options.window {
+			fmt.Printf("%s served %d requests, %d with a 5xx status, and had no crashes over the limit\n", d.name, requests, failures)
+			return nil
+		}
+		time.Sleep(watchInterval)
+	}
+}
+
+// rollBack restarts the app on the droplet it ran before the current one.
+func (d *deployment) rollBack(reason error) error {
+	current := currentDroplet(d.cliConnection, d.appGUID)
+	previous := d.previousDroplet
+	if previous == current {
+		previous = ""
+	}
+	var droplets struct {
+		Resources []v3Droplet `json:"resources"`
+	}
+	err := cfCurl(d.cliConnection, "GET", "/v3/apps/"+d.appGUID+"/droplets?states=STAGED&order_by=-created_at&per_page=5", nil, &droplets)
+	if err == nil && previous == "" {
+		for _, droplet := range droplets.Resources {
+			if droplet.GUID != current {
+				previous = droplet.GUID
+				break
+			}
+		}
+	}
+	if previous == "" {
+		return fmt.Errorf("%v; there is no previous droplet to roll back to", reason)
+	}
+	fmt.Printf("%v, rolling %s back to droplet %s\n", reason, d.name, previous)
+	d.previousDroplet = previous
+	err = d.restartPreviousVersion()
+	if err != nil {
+		return fmt.Errorf("%v; rolling back failed: %v", reason, err)
+	}
+	return fmt.Errorf("%v; rolled back", reason)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DeployWindowsConfig limits when deploys may run, such as weekdays from
+// 09:00 to 17:00.
+type DeployWindowsConfig struct {
+	// Timezone is an IANA name like Europe/Berlin, the local zone if empty.
+	Timezone string         `yaml:"timezone,omitempty"`
+	Windows  []DeployWindow `yaml:"windows,omitempty"`
+}
+
+// DeployWindow allows deploys on Days (mon, tue, ... or "weekdays"; every
+// day if empty) between From and To, given as HH:MM.
+type DeployWindow struct {
+	Days []string `yaml:"days,omitempty"`
+	From string   `yaml:"from"`
+	To   string   `yaml:"to"`
+}
+
+func (w DeployWindow) String() string {
+	days := "every day"
+	if len(w.Days) > 0 {
+		days = strings.Join(w.Days, ",")
+	}
+	return fmt.Sprintf("%s %s-%s", days, w.From, w.To)
+}
+
+var weekdays = map[string][]time.Weekday{
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+// minuteOfDay parses HH:MM.
+func minuteOfDay(clock string) (int, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", clock)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// contains reports whether t falls in the window. A window whose To is
+// before its From runs past midnight.
+func (w DeployWindow) contains(t time.Time) (bool, error) {
+	from, err := minuteOfDay(w.From)
+	if err != nil {
+		return false, err
+	}
+	to, err := minuteOfDay(w.To)
+	if err != nil {
+		return false, err
+	}
+	day := t.Weekday()
+	minute := t.Hour()*60 + t.Minute()
+	if to < from && minute < to {
+		day = (day + 6) % 7
+		minute += 24 * 60
+		to += 24 * 60
+	} else if to < from {
+		to += 24 * 60
+	}
+	if len(w.Days) > 0 {
+		allowed := false
+		for _, name := range w.Days {
+			days, ok := weekdays[strings.ToLower(name)]
+			if !ok {
+				return false, fmt.Errorf("invalid day %q in deploy_windows", name)
+			}
+			for _, d := range days {
+				allowed = allowed || d == day
+			}
+		}
+		if !allowed {
+			return false, nil
+		}
+	}
+	return minute >= from && minute < to, nil
+}
+
+// checkDeployWindow returns an error when now is outside every window. No
+// windows means deploys are always allowed.
+func checkDeployWindow(config DeployWindowsConfig, now time.Time) error {
+	if len(config.Windows) == 0 {
+		return nil
+	}
+	if config.Timezone != "" {
+		location, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid deploy_windows timezone: %v", err)
+		}
+		now = now.In(location)
+	}
+	var allowed []string
+	for _, window := range config.Windows {
+		inside, err := window.contains(now)
+		if err != nil {
+			return err
+		}
+		if inside {
+			return nil
+		}
+		allowed = append(allowed, window.String())
+	}
+	return fmt.Errorf("it is %s, outside the deploy windows (%s); use --override-window to deploy anyway", now.Format("Mon 15:04 MST"), strings.Join(allowed, "; "))
+}